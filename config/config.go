@@ -1,4 +1,104 @@
 package config
 
+import "time"
+
 var ENV string
 var PORT string
+
+// BCRYPT_COST is the cost used when hashing passwords. Stored hashes with a
+// lower cost are rehashed transparently on the next successful login.
+var BCRYPT_COST = 10
+
+var ACCESS_TOKEN_TTL = 15 * time.Minute
+var REFRESH_TOKEN_TTL = 30 * 24 * time.Hour
+
+var JWT_ISSUER = "go-task-mgmt"
+var JWT_AUDIENCE = "go-task-mgmt"
+
+// USER_CACHE_TTL is how long JwtMiddleware may reuse a user row read from
+// the database before reading it again.
+var USER_CACHE_TTL = 5 * time.Second
+
+// EMAIL_CHANGE_TOKEN_TTL is how long the token sent to confirm a new email
+// address is valid.
+var EMAIL_CHANGE_TOKEN_TTL = 24 * time.Hour
+var EMAIL_VERIFICATION_TOKEN_TTL = 48 * time.Hour
+var PASSWORD_RESET_TOKEN_TTL = time.Hour
+
+// AVATAR_DIR is the directory uploaded avatars are stored in and served
+// from.
+var AVATAR_DIR = "avatars"
+var AVATAR_MAX_BYTES int64 = 2 << 20
+
+// Failed logins are counted per account and per client IP. After
+// LOGIN_BACKOFF_AFTER failures (LOGIN_IP_BACKOFF_AFTER for an IP) further
+// attempts are refused for LOGIN_BACKOFF_BASE, doubling with every failure
+// up to LOGIN_BACKOFF_MAX. LOGIN_LOCKOUT_THRESHOLD failures (or
+// LOGIN_IP_LOCKOUT_THRESHOLD) lock the account or IP out for
+// LOGIN_LOCKOUT_DURATION. Failures are forgotten LOGIN_FAILURE_WINDOW after
+// the last one.
+var LOGIN_BACKOFF_AFTER = 3
+var LOGIN_IP_BACKOFF_AFTER = 20
+var LOGIN_BACKOFF_BASE = time.Second
+var LOGIN_BACKOFF_MAX = 15 * time.Minute
+var LOGIN_LOCKOUT_THRESHOLD = 10
+var LOGIN_IP_LOCKOUT_THRESHOLD = 100
+var LOGIN_LOCKOUT_DURATION = 30 * time.Minute
+var LOGIN_FAILURE_WINDOW = time.Hour
+
+// TOTP_ISSUER names the application in authenticator apps. TOTP_SKEW is the
+// number of 30 second time steps a code may be off by to allow for clock
+// drift.
+var TOTP_ISSUER = "go-task-mgmt"
+var TOTP_SKEW = 1
+
+// TWO_FACTOR_TOKEN_TTL is how long the token returned by the first login
+// step of a user with two-factor authentication can be exchanged for a
+// session.
+var TWO_FACTOR_TOKEN_TTL = 5 * time.Minute
+var RECOVERY_CODE_COUNT = 10
+
+// Personal access tokens expire after the number of days asked for when
+// creating them, PERSONAL_ACCESS_TOKEN_TTL if none is given, and at most
+// after PERSONAL_ACCESS_TOKEN_MAX_TTL.
+var PERSONAL_ACCESS_TOKEN_TTL = 30 * 24 * time.Hour
+var PERSONAL_ACCESS_TOKEN_MAX_TTL = 365 * 24 * time.Hour
+
+// OIDC_LOGIN_TTL is how long a user has to log in at the identity provider
+// after being sent there.
+var OIDC_LOGIN_TTL = 10 * time.Minute
+
+// The due soon task list covers the rest of today and DUE_SOON_DAYS days
+// after it in the user's time zone unless asked for another number of days,
+// which may be at most DUE_SOON_MAX_DAYS.
+var DUE_SOON_DAYS = 1
+var DUE_SOON_MAX_DAYS = 90
+
+// Assignees are reminded of open tasks due within DUE_REMINDER_BEFORE.
+var DUE_REMINDER_BEFORE = 24 * time.Hour
+
+// Webhook deliveries time out after WEBHOOK_TIMEOUT. A failed delivery is
+// retried after WEBHOOK_BACKOFF_BASE, doubling after each further failure
+// up to WEBHOOK_BACKOFF_MAX, and given up after WEBHOOK_MAX_ATTEMPTS
+// attempts. A webhook is disabled after WEBHOOK_DISABLE_AFTER failed
+// attempts in a row.
+var WEBHOOK_TIMEOUT = 10 * time.Second
+var WEBHOOK_BACKOFF_BASE = 30 * time.Second
+var WEBHOOK_BACKOFF_MAX = 6 * time.Hour
+var WEBHOOK_MAX_ATTEMPTS = 10
+var WEBHOOK_DISABLE_AFTER = 25
+
+// Webhook secrets must be at least WEBHOOK_SECRET_MIN_LENGTH characters
+// long.
+var WEBHOOK_SECRET_MIN_LENGTH = 16
+
+// Webhooks may only be delivered to public addresses. Tests set
+// WEBHOOK_ALLOW_LOOPBACK to deliver to loopback receivers.
+var WEBHOOK_ALLOW_LOOPBACK = false
+
+// Project event streams send a heartbeat comment every
+// EVENT_HEARTBEAT_INTERVAL, which is also how often they read the event log
+// for events recorded by other processes. Events are kept for
+// EVENT_LOG_RETENTION for streams to resume from.
+var EVENT_HEARTBEAT_INTERVAL = 15 * time.Second
+var EVENT_LOG_RETENTION = 7 * 24 * time.Hour
@@ -0,0 +1,46 @@
+package constants
+
+type ProjectRole string
+
+const (
+	ProjectOwner       ProjectRole = "owner"
+	ProjectMaintainer  ProjectRole = "maintainer"
+	ProjectContributor ProjectRole = "contributor"
+	ProjectViewer      ProjectRole = "viewer"
+)
+
+// ProjectRolePermissions lists the permissions each project role grants
+// within its project. A user needs both the permission in their global role
+// and a project role that grants it.
+var ProjectRolePermissions = map[ProjectRole][]string{
+	ProjectOwner: {
+		"read project", "update project", "delete project",
+		"create task", "read task", "update task", "delete task",
+	},
+	ProjectMaintainer: {
+		"read project", "update project",
+		"create task", "read task", "update task", "delete task",
+	},
+	ProjectContributor: {
+		"read project",
+		"create task", "read task", "update task", "delete task",
+	},
+	ProjectViewer: {
+		"read project",
+		"read task",
+	},
+}
+
+func (r ProjectRole) Valid() bool {
+	_, ok := ProjectRolePermissions[r]
+	return ok
+}
+
+func (r ProjectRole) HasPermission(permission string) bool {
+	for _, p := range ProjectRolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
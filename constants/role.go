@@ -7,3 +7,9 @@ const (
 	ProjectManagerRoleID RoleID = 2
 	UserRoleID           RoleID = 3
 )
+
+// BuiltIn reports whether the role is one of the roles created by the
+// initial migration. Built-in roles cannot be renamed or deleted.
+func (id RoleID) BuiltIn() bool {
+	return id == AdminRoleID || id == ProjectManagerRoleID || id == UserRoleID
+}
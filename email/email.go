@@ -0,0 +1,123 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users.
+type Mailer interface {
+	Send(m Message) error
+}
+
+var headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// Bytes formats m as a plain text RFC 5322 message from the address from.
+func (m Message) Bytes(from string, date time.Time) []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "From: %s\r\n", headerReplacer.Replace(from))
+	fmt.Fprintf(b, "To: %s\r\n", headerReplacer.Replace(m.To))
+	fmt.Fprintf(b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerReplacer.Replace(m.Subject)))
+	fmt.Fprintf(b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// LogMailer writes messages to the standard logger instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(m Message) error {
+	log.Printf("mail to '%s': %s\n%s\n", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileMailer writes each message to its own .eml file in Dir. It is meant
+// for development and for machines without access to a mail server.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return err
+	}
+	now := time.Now()
+	f, err := os.CreateTemp(m.Dir, fmt.Sprintf("%d-*.eml", now.UnixNano()))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(msg.Bytes(m.From, now)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
+
+// SMTPMailer sends messages through the SMTP server at Addr. Auth may be
+// nil for servers that do not require authentication.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, msg.Bytes(m.From, time.Now()))
+}
+
+var ErrUnsupportedMailer = errors.New("unsupported mailer")
+
+// FromEnv builds the mailer selected by GO_TASK_MGMT_MAILER: "log"
+// (default), "file" or "smtp". Messages are sent from GO_TASK_MGMT_MAIL_FROM.
+// The file mailer writes to GO_TASK_MGMT_MAIL_DIR. The SMTP mailer connects
+// to GO_TASK_MGMT_SMTP_ADDR and, if GO_TASK_MGMT_SMTP_USERNAME is set,
+// authenticates with it and GO_TASK_MGMT_SMTP_PASSWORD.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("GO_TASK_MGMT_MAIL_FROM")
+	if from == "" {
+		from = "go-task-mgmt@localhost"
+	}
+
+	switch os.Getenv("GO_TASK_MGMT_MAILER") {
+	case "", "log":
+		return LogMailer{}, nil
+	case "file":
+		dir := os.Getenv("GO_TASK_MGMT_MAIL_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "go-task-mgmt-mail")
+		}
+		return FileMailer{Dir: dir, From: from}, nil
+	case "smtp":
+		addr := os.Getenv("GO_TASK_MGMT_SMTP_ADDR")
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GO_TASK_MGMT_SMTP_ADDR '%s': %w", addr, err)
+		}
+		m := SMTPMailer{Addr: addr, From: from}
+		if username := os.Getenv("GO_TASK_MGMT_SMTP_USERNAME"); username != "" {
+			m.Auth = smtp.PlainAuth("", username, os.Getenv("GO_TASK_MGMT_SMTP_PASSWORD"), host)
+		}
+		return m, nil
+	}
+	return nil, ErrUnsupportedMailer
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+)
+
+func TestMessageBytesShouldStripHeaderInjection(t *testing.T) {
+	m := Message{To: "user@example.com\r\nBcc: evil@example.com", Subject: "Hello", Body: "line 1\nline 2"}
+	s := string(m.Bytes("app@example.com", time.Unix(0, 0).UTC()))
+
+	assert.AssertEq(t, strings.Contains(s, "\r\nBcc:"), false)
+	assert.AssertEq(t, strings.HasSuffix(s, "\r\n\r\nline 1\r\nline 2"), true)
+}
+
+func TestFileMailerShouldWriteMessage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mail")
+	assert.AssertEq(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	m := FileMailer{Dir: dir, From: "app@example.com"}
+	err = m.Send(Message{To: "user@example.com", Subject: "Hello", Body: "token: abc"})
+	assert.AssertEq(t, err, nil)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(files), 1)
+	b, err := os.ReadFile(files[0])
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, strings.Contains(string(b), "To: user@example.com\r\n"), true)
+	assert.AssertEq(t, strings.Contains(string(b), "token: abc"), true)
+}
+
+func TestFromEnvShouldRejectUnknownMailer(t *testing.T) {
+	t.Setenv("GO_TASK_MGMT_MAILER", "carrier-pigeon")
+	_, err := FromEnv()
+	assert.AssertEq(t, err, ErrUnsupportedMailer)
+}
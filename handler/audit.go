@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+// HandleGetAudit lists audit entries newest first, optionally filtered by
+// action and subject.
+func HandleGetAudit(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		filter := model.AuditFilter{Action: c.QueryParam("action"), Subject: c.QueryParam("subject")}
+
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != "-id" {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.BeforeID = lc.ID
+		}
+
+		entries := model.AuditEntries{}
+		filter.Limit = limit + 1
+		if err := entries.List(db, filter); err != nil {
+			log.Println("err listing audit entries: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list audit entries"})
+		}
+		total, err := entries.Count(db, filter)
+		if err != nil {
+			log.Println("err counting audit entries: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list audit entries"})
+		}
+
+		page := schema.Page[model.AuditEntry]{Items: entries, Total: total}
+		if len(entries) > limit {
+			page.Items = entries[:limit]
+			page.NextCursor, err = utils.EncodeCursor(listCursor{Sort: "-id", ID: page.Items[limit-1].ID})
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list audit entries"})
+			}
+		}
+		return c.JSON(http.StatusOK, page)
+	})
+}
@@ -8,7 +8,7 @@ import (
 	"log"
 	"net/http"
 	"net/mail"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -16,10 +16,12 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/mattn/go-sqlite3"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/email"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
 	"github.com/tomihaapalainen/go-task-mgmt/schema"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
 )
 
 func emailIsValid(s string) bool {
@@ -47,7 +49,10 @@ func passwordIsValid(s string) bool {
 	return count >= 8 && nums >= 1 && upper >= 1 && lower >= 1
 }
 
-func HandlePostRegister(db *sql.DB) echo.HandlerFunc {
+// HandlePostRegister creates a user with an unverified email address and
+// mails them a verification token. Until the address is verified, routes
+// requiring a permission are closed to the user.
+func HandlePostRegister(db *sql.DB, mailer email.Mailer) echo.HandlerFunc {
 	return echo.HandlerFunc(func(c echo.Context) error {
 		userIn := schema.UserIn{}
 		if err := json.NewDecoder(c.Request().Body).Decode(&userIn); err != nil {
@@ -79,7 +84,7 @@ func HandlePostRegister(db *sql.DB) echo.HandlerFunc {
 			)
 		}
 
-		passwordHash, err := bcrypt.GenerateFromPassword([]byte(userIn.Password), 4)
+		passwordHash, err := utils.HashPassword(userIn.Password)
 		if err != nil {
 			log.Println("err generating password hash:", err)
 			return c.JSON(
@@ -88,7 +93,7 @@ func HandlePostRegister(db *sql.DB) echo.HandlerFunc {
 			)
 		}
 
-		user := model.User{Email: userIn.Email, PasswordHash: string(passwordHash), RoleID: constants.UserRoleID}
+		user := model.User{Email: userIn.Email, PasswordHash: passwordHash, RoleID: constants.UserRoleID}
 		if err := user.Create(db); err != nil {
 			log.Printf("err creating user %+v: %+v\n", user, err)
 			if errors.Is(err, sqlite3.ErrConstraintUnique) {
@@ -105,6 +110,11 @@ func HandlePostRegister(db *sql.DB) echo.HandlerFunc {
 			)
 		}
 
+		if err := sendVerification(db, mailer, user); err != nil {
+			// The user can ask for a new verification email once logged in.
+			log.Println("err sending verification: ", err)
+		}
+
 		return c.JSON(
 			http.StatusOK,
 			model.User{ID: user.ID, Email: user.Email, RoleID: user.RoleID},
@@ -112,6 +122,9 @@ func HandlePostRegister(db *sql.DB) echo.HandlerFunc {
 	})
 }
 
+// HandlePostLogIn checks the user's password and starts a session. Users
+// with two-factor authentication get a TwoFactorChallenge instead, to be
+// completed with HandlePostLogInTwoFactor.
 func HandlePostLogIn(db *sql.DB) echo.HandlerFunc {
 	return echo.HandlerFunc(func(c echo.Context) error {
 		userIn := schema.UserIn{}
@@ -125,42 +138,342 @@ func HandlePostLogIn(db *sql.DB) echo.HandlerFunc {
 			)
 		}
 
-		user := model.User{Email: userIn.Email}
+		now := time.Now()
+		accountKey := accountThrottleKey(userIn.Email)
+		ipKey := ipThrottleKey(c.RealIP())
+		attempt, wait, err := startLoginAttempt(db, now, accountKey, ipKey)
+		if err != nil {
+			log.Println("err counting login attempt: ", err)
+			return c.JSON(
+				http.StatusInternalServerError,
+				schema.MessageResponse{Message: "Unable to log in"},
+			)
+		}
+		if wait > 0 {
+			return tooManyLoginAttempts(c, wait)
+		}
+
+		user := model.User{Email: strings.TrimSpace(userIn.Email)}
 		if err := user.ReadByEmail(db); err != nil {
-			log.Println("err reading user by email: ", err)
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Println("err reading user by email: ", err)
+				attempt.forgive(db)
+				return c.JSON(
+					http.StatusInternalServerError,
+					schema.MessageResponse{Message: "Unable to log in"},
+				)
+			}
+			utils.CheckPasswordDummy(userIn.Password)
+			attempt.fail(db)
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid credentials"},
+			)
+		}
+
+		if !utils.CheckPassword(user.PasswordHash, userIn.Password) {
+			attempt.fail(db)
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid credentials"},
+			)
+		}
+		attempt.forgive(db)
+		// With two-factor authentication the failures are only reset by the
+		// second step, so that knowing the password does not allow guessing
+		// codes without limit.
+		if !user.TwoFactorEnabled() {
+			throttle := model.LoginThrottle{Key: accountKey}
+			if err := throttle.Delete(db); err != nil {
+				log.Println("err resetting login throttle: ", err)
+			}
+		}
+		if !user.Active() {
+			return c.JSON(
+				http.StatusForbidden,
+				schema.MessageResponse{Message: "User has been deactivated"},
+			)
+		}
+
+		if utils.PasswordNeedsRehash(user.PasswordHash) {
+			passwordHash, err := utils.HashPassword(userIn.Password)
+			if err != nil {
+				log.Println("err generating password hash:", err)
+			} else {
+				user.PasswordHash = passwordHash
+				if err := user.UpdatePasswordHash(db); err != nil {
+					log.Println("err updating password hash:", err)
+				}
+			}
+		}
+
+		if user.TwoFactorEnabled() {
+			return startTwoFactorChallenge(c, user, now)
+		}
+
+		return startSession(c, db, user)
+	})
+}
+
+// startTwoFactorChallenge responds with the token user exchanges for a
+// session together with a code at HandlePostLogInTwoFactor.
+func startTwoFactorChallenge(c echo.Context, user model.User, now time.Time) error {
+	token, err := utils.SignUserToken(user.ID, model.TokenTwoFactorLogin, config.TWO_FACTOR_TOKEN_TTL)
+	if err != nil {
+		log.Println("err signing two-factor token: ", err)
+		return c.JSON(
+			http.StatusInternalServerError,
+			schema.MessageResponse{Message: "Unable to log in"},
+		)
+	}
+	return c.JSON(http.StatusOK, schema.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		TwoFactorToken:    token,
+		Expires:           now.Add(config.TWO_FACTOR_TOKEN_TTL).Unix(),
+	})
+}
+
+// HandlePostLogInTwoFactor completes the login of a user with two-factor
+// authentication. Failed codes count towards the login throttle like
+// failed passwords.
+func HandlePostLogInTwoFactor(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		loginIn := schema.TwoFactorLoginIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&loginIn); err != nil {
+			log.Println("err decoding request body: ", err)
 			return c.JSON(
 				http.StatusBadRequest,
 				schema.MessageResponse{
-					Message: fmt.Sprintf("error reading user by email '%s'", user.Email),
+					Message: "Invalid request data",
 				},
 			)
 		}
 
-		exp := time.Now().Add(time.Hour * 24).Unix()
-		b, err := json.Marshal(user)
+		userID, err := utils.ParseUserToken(loginIn.Token, model.TokenTwoFactorLogin)
 		if err != nil {
-			log.Println("err marshaling user: ", err)
-			return errors.New("internal server error")
-		}
-		token := jwt.NewWithClaims(
-			jwt.SigningMethodHS256,
-			jwt.MapClaims{
-				"exp":  exp,
-				"data": string(b),
-			},
-		)
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid or expired two-factor token"},
+			)
+		}
+		user := model.User{ID: userID}
+		if err := user.ReadByID(db); err != nil || !user.TwoFactorEnabled() {
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Println("err reading user by id: ", err)
+				return c.JSON(
+					http.StatusInternalServerError,
+					schema.MessageResponse{Message: "Unable to log in"},
+				)
+			}
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid or expired two-factor token"},
+			)
+		}
+		if !user.Active() {
+			return c.JSON(
+				http.StatusForbidden,
+				schema.MessageResponse{Message: "User has been deactivated"},
+			)
+		}
+
+		now := time.Now()
+		accountKey := accountThrottleKey(user.Email)
+		ipKey := ipThrottleKey(c.RealIP())
+		attempt, wait, err := startLoginAttempt(db, now, accountKey, ipKey)
+		if err != nil {
+			log.Println("err counting login attempt: ", err)
+			return c.JSON(
+				http.StatusInternalServerError,
+				schema.MessageResponse{Message: "Unable to log in"},
+			)
+		}
+		if wait > 0 {
+			return tooManyLoginAttempts(c, wait)
+		}
 
-		tokenString, err := token.SignedString([]byte(os.Getenv("GO_TASK_MGMT_SIGNING_SECRET")))
+		ok, err := checkSecondFactor(db, user, loginIn.Code, loginIn.RecoveryCode, now)
 		if err != nil {
-			log.Println("err signing token ", err)
+			log.Println("err checking second factor: ", err)
+			attempt.forgive(db)
 			return c.JSON(
 				http.StatusInternalServerError,
-				schema.MessageResponse{Message: "Error signing JWT token"},
+				schema.MessageResponse{Message: "Unable to log in"},
+			)
+		}
+		if !ok {
+			attempt.fail(db)
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid two-factor code"},
+			)
+		}
+		attempt.forgive(db)
+		throttle := model.LoginThrottle{Key: accountKey}
+		if err := throttle.Delete(db); err != nil {
+			log.Println("err resetting login throttle: ", err)
+		}
+
+		return startSession(c, db, user)
+	})
+}
+
+// startSession responds with the tokens of a new session family for user.
+func startSession(c echo.Context, db *sql.DB, user model.User) error {
+	familyID, err := utils.GenerateToken(16)
+	if err != nil {
+		log.Println("err generating session family ID: ", err)
+		return c.JSON(
+			http.StatusInternalServerError,
+			schema.MessageResponse{Message: "Unable to create session"},
+		)
+	}
+
+	r, err := issueTokens(db, user, familyID)
+	if err != nil {
+		log.Println("err issuing tokens: ", err)
+		return c.JSON(
+			http.StatusInternalServerError,
+			schema.MessageResponse{Message: "Unable to create session"},
+		)
+	}
+
+	return c.JSON(http.StatusOK, r)
+}
+
+// issueTokens signs an access token for user and stores a new refresh token
+// in the session family identified by familyID.
+func issueTokens(db *sql.DB, user model.User, familyID string) (schema.AuthResponse, error) {
+	now := time.Now()
+	exp := now.Add(config.ACCESS_TOKEN_TTL).Unix()
+	jti, err := utils.GenerateToken(16)
+	if err != nil {
+		return schema.AuthResponse{}, err
+	}
+	tokenString, err := utils.SignClaims(&utils.Claims{
+		SessionID:    familyID,
+		TokenVersion: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.JWT_ISSUER,
+			Audience:  jwt.ClaimStrings{config.JWT_AUDIENCE},
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(time.Unix(exp, 0)),
+			ID:        jti,
+		},
+	})
+	if err != nil {
+		return schema.AuthResponse{}, err
+	}
+
+	refreshToken, err := utils.GenerateToken(32)
+	if err != nil {
+		return schema.AuthResponse{}, err
+	}
+	session := model.Session{
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(refreshToken),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(config.REFRESH_TOKEN_TTL).Unix(),
+	}
+	if err := session.Create(db); err != nil {
+		return schema.AuthResponse{}, err
+	}
+
+	return schema.AuthResponse{
+		AccessToken:  tokenString,
+		TokenType:    "Bearer",
+		Expires:      exp,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func HandlePostRefresh(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		refreshIn := schema.RefreshIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&refreshIn); err != nil {
+			log.Println("err decoding request body: ", err)
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{
+					Message: "Invalid request data",
+				},
 			)
 		}
 
-		r := schema.AuthResponse{AccessToken: tokenString, TokenType: "Bearer", Expires: exp}
+		session := model.Session{TokenHash: utils.HashToken(refreshIn.RefreshToken)}
+		if err := session.Rotate(db); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Println("err rotating session: ", err)
+				return c.JSON(
+					http.StatusInternalServerError,
+					schema.MessageResponse{Message: "Unable to refresh session"},
+				)
+			}
+
+			// A token that was already rotated is being presented again, so it
+			// may have been stolen. Revoke every token descended from the same login.
+			if err := session.ReadByTokenHash(db); err == nil && session.RotatedAt.Valid {
+				log.Printf("refresh token reuse detected for session family '%s'\n", session.FamilyID)
+				if err := session.RevokeFamily(db); err != nil {
+					log.Println("err revoking session family: ", err)
+				}
+			}
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid refresh token"},
+			)
+		}
+
+		user := model.User{ID: session.UserID}
+		if err := user.ReadByID(db); err != nil || !user.Active() {
+			if err != nil {
+				log.Println("err reading user by id: ", err)
+			}
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: "Invalid refresh token"},
+			)
+		}
+
+		r, err := issueTokens(db, user, session.FamilyID)
+		if err != nil {
+			log.Println("err issuing tokens: ", err)
+			return c.JSON(
+				http.StatusInternalServerError,
+				schema.MessageResponse{Message: "Unable to refresh session"},
+			)
+		}
 
 		return c.JSON(http.StatusOK, r)
 	})
 }
+
+func HandlePostLogOut(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		session := model.Session{FamilyID: c.Get("session_id").(string)}
+		if err := session.RevokeFamily(db); err != nil {
+			log.Println("err revoking session family: ", err)
+			return c.JSON(
+				http.StatusInternalServerError,
+				schema.MessageResponse{Message: "Unable to log out"},
+			)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+func HandlePostLogOutAll(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		if err := user.RevokeSessions(db); err != nil {
+			log.Println("err revoking user sessions: ", err)
+			return c.JSON(
+				http.StatusInternalServerError,
+				schema.MessageResponse{Message: "Unable to log out"},
+			)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
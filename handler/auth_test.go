@@ -8,12 +8,16 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
 	"github.com/tomihaapalainen/go-task-mgmt/mw"
 	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestPostRegisterUserShouldPass(t *testing.T) {
@@ -21,7 +25,7 @@ func TestPostRegisterUserShouldPass(t *testing.T) {
 
 	rec, c := createContext("POST", "http://localhost:8080/auth/register", jsonStr)
 
-	err := HandlePostRegister(tDB)(c)
+	err := HandlePostRegister(tDB, tMailer)(c)
 	assert.AssertEq(t, err, nil)
 
 	assert.AssertEq(t, rec.Code, http.StatusOK)
@@ -52,7 +56,7 @@ func TestPostRegisterShouldFail(t *testing.T) {
 		t.Run(fmt.Sprintf("Body: %s", tc.requestBody), func(t *testing.T) {
 			rec, c := createContext("POST", "http://localhost:8080/auth/register", tc.requestBody)
 
-			err := HandlePostRegister(tDB)(c)
+			err := HandlePostRegister(tDB, tMailer)(c)
 			assert.AssertEq(t, err, nil)
 			assert.AssertEq(t, rec.Code, http.StatusBadRequest)
 			res := schema.MessageResponse{}
@@ -67,7 +71,7 @@ func TestPostLogInWithValidCredentialsShouldPass(t *testing.T) {
 
 	_, c := createContext("POST", "http://localhost:8080/auth/register", jsonStr)
 
-	err := HandlePostRegister(tDB)(c)
+	err := HandlePostRegister(tDB, tMailer)(c)
 	assert.AssertEq(t, err, nil)
 
 	rec, c := createContext("POST", "http://localhost:8080/auth/login", jsonStr)
@@ -92,3 +96,194 @@ func TestPostLoginWithInvalidContentTypeShouldFail(t *testing.T) {
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
 }
+
+func TestPostLogInWithInvalidCredentialsShouldFail(t *testing.T) {
+	testCases := []struct {
+		email    string
+		password string
+	}{
+		{testUserIn.Email, "Wrongpass1"},
+		{testUserIn.Email, ""},
+		{"doesnotexist@example.com", "Testpass1"},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.email, tc.password), func(t *testing.T) {
+			jsonStr := fmt.Sprintf(`{"email": "%s", "password": "%s"}`, tc.email, tc.password)
+			rec, c := createContext("POST", "http://localhost:8080/auth/login", jsonStr)
+
+			err := HandlePostLogIn(tDB)(c)
+			assert.AssertEq(t, err, nil)
+			assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+			res := schema.MessageResponse{}
+			err = json.NewDecoder(rec.Body).Decode(&res)
+			assert.AssertEq(t, err, nil)
+			assert.AssertEq(t, res.Message, "Invalid credentials")
+		})
+	}
+}
+
+func TestPostLogInRehashesLowCostPassword(t *testing.T) {
+	_, u := createTestUserWithRole("testrehash@example.com", "Testpass1", constants.UserRoleID)
+
+	defaultCost := config.BCRYPT_COST
+	config.BCRYPT_COST = bcrypt.MinCost + 1
+	defer func() { config.BCRYPT_COST = defaultCost }()
+
+	login(t, u.Email, "Testpass1")
+
+	err := u.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	cost, err := bcrypt.Cost([]byte(u.PasswordHash))
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, cost, bcrypt.MinCost+1)
+}
+
+func refresh(refreshToken string) (*httptest.ResponseRecorder, error) {
+	jsonStr := fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)
+	rec, c := createContext("POST", "http://localhost:8080/auth/refresh", jsonStr)
+	err := HandlePostRefresh(tDB)(c)
+	return rec, err
+}
+
+func TestPostRefreshShouldRotateToken(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	assert.AssertNotEq(t, authRes.RefreshToken, "")
+
+	rec, err := refresh(authRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	refreshed := schema.AuthResponse{}
+	err = json.NewDecoder(rec.Body).Decode(&refreshed)
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, refreshed.AccessToken, "")
+	assert.AssertNotEq(t, refreshed.RefreshToken, authRes.RefreshToken)
+
+	rec, err = refresh(refreshed.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+}
+
+func TestPostRefreshWithReusedTokenShouldRevokeFamily(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	rec, err := refresh(authRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	refreshed := schema.AuthResponse{}
+	err = json.NewDecoder(rec.Body).Decode(&refreshed)
+	assert.AssertEq(t, err, nil)
+
+	rec, err = refresh(authRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+
+	rec, err = refresh(refreshed.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+
+	rec, c := createContextWithParams(
+		"GET",
+		"http://localhost:8080/project/:id",
+		"",
+		[]string{"id"},
+		[]string{fmt.Sprintf("%d", testProject.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", refreshed.TokenType, refreshed.AccessToken))
+	err = mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read project")(HandleGetProjectID(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestPostRefreshWithInvalidTokenShouldFail(t *testing.T) {
+	rec, err := refresh("invalid")
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestPostLogOutShouldRevokeSession(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	otherAuthRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	rec, c := createContext("POST", "http://localhost:8080/auth/logout", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(HandlePostLogOut(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+
+	rec, c = createContext("POST", "http://localhost:8080/auth/logout", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(HandlePostLogOut(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+
+	rec, err = refresh(authRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+
+	rec, err = refresh(otherAuthRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+}
+
+func TestPostLogOutAllShouldRevokeAllSessions(t *testing.T) {
+	_, u := createTestUserWithRole("testlogoutall@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, u.Email, "Testpass1")
+	otherAuthRes := login(t, u.Email, "Testpass1")
+
+	rec, c := createContext("POST", "http://localhost:8080/auth/logout/all", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(HandlePostLogOutAll(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+
+	rec, err = refresh(otherAuthRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestJwtMiddlewareWithStaleTokenVersionShouldFail(t *testing.T) {
+	_, u := createTestUserWithRole("teststaletoken@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, u.Email, "Testpass1")
+
+	err := u.UpdateRole(tDB)
+	assert.AssertEq(t, err, nil)
+	mw.ForgetUser(u.ID)
+
+	rec, c := createContext("POST", "http://localhost:8080/auth/logout", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(HandlePostLogOut(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestJwtMiddlewareWithForeignAudienceShouldFail(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	claims, err := utils.ParseClaims(authRes.AccessToken)
+	assert.AssertEq(t, err, nil)
+	claims.Audience = jwt.ClaimStrings{"some-other-service"}
+	tokenString, err := utils.SignClaims(claims)
+	assert.AssertEq(t, err, nil)
+
+	rec, c := createContext("POST", "http://localhost:8080/auth/logout", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	err = mw.JwtMiddleware(tDB)(HandlePostLogOut(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+}
+
+func TestJwtMiddlewareWithUnsignedTokenShouldFail(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	claims, err := utils.ParseClaims(authRes.AccessToken)
+	assert.AssertEq(t, err, nil)
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	token.Header["kid"] = "default"
+	tokenString, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.AssertEq(t, err, nil)
+
+	rec, c := createContext("POST", "http://localhost:8080/auth/logout", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+	err = mw.JwtMiddleware(tDB)(HandlePostLogOut(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+}
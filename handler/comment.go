@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/policy"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+const maxCommentLength = 10000
+
+// readRouteComment reads the comment named by the "commentID" route
+// parameter on the task. Deleted comments are not found. If the comment
+// cannot be read, a response has been written and the returned comment has
+// no ID.
+func readRouteComment(c echo.Context, db *sql.DB, task model.Task) (model.Comment, error) {
+	commentID := c.Param("commentID")
+	cID, err := strconv.Atoi(commentID)
+	if err != nil || cID <= 0 {
+		return model.Comment{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid comment ID '%s'", commentID)})
+	}
+	comment := model.Comment{ID: cID, TaskID: task.ID}
+	err = comment.Read(db)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && comment.DeletedAt != 0) {
+		return model.Comment{}, c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("comment '%d' not found on task '%d'", cID, task.ID)})
+	}
+	if err != nil {
+		log.Println("err reading comment: ", err)
+		return model.Comment{}, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read comment"})
+	}
+	return comment, nil
+}
+
+// decodeCommentContent decodes the comment in the request body. msg explains
+// why it is invalid if it is not empty.
+func decodeCommentContent(c echo.Context) (commentIn schema.CommentIn, msg string) {
+	if err := json.NewDecoder(c.Request().Body).Decode(&commentIn); err != nil {
+		log.Println("err decoding body: ", err)
+		return commentIn, "invalid request body"
+	}
+	commentIn.Content = strings.TrimSpace(commentIn.Content)
+	if commentIn.Content == "" {
+		return commentIn, "comment content must not be empty"
+	}
+	if utf8.RuneCountInString(commentIn.Content) > maxCommentLength {
+		return commentIn, fmt.Sprintf("comment must be at most %d characters long", maxCommentLength)
+	}
+	return commentIn, ""
+}
+
+// commentMentions returns the mention source of the comment. Mentions are
+// attributed to the author of the comment.
+func commentMentions(task model.Task, comment model.Comment) model.MentionSource {
+	return model.MentionSource{ProjectID: task.ProjectID, TaskID: task.ID, CommentID: comment.ID, AuthorID: comment.UserID}
+}
+
+func HandlePostComment(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		task, err := readRouteTask(c, db)
+		if err != nil || task.ID == 0 {
+			return err
+		}
+		commentIn, msg := decodeCommentContent(c)
+		if msg != "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: msg})
+		}
+
+		comment := model.Comment{TaskID: task.ID, UserID: user.ID, ParentID: commentIn.ParentID, Content: commentIn.Content}
+		if err := comment.Create(db); err != nil {
+			if errors.Is(err, model.ErrInvalidParent) {
+				return c.JSON(
+					http.StatusBadRequest,
+					schema.MessageResponse{Message: fmt.Sprintf("cannot reply to comment '%d'", commentIn.ParentID)})
+			}
+			log.Println("err creating comment: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to create comment"})
+		}
+		mentioned := updateMentions(db, commentMentions(task, comment), comment.Content)
+		notifyComment(db, task, comment, mentioned)
+		return c.JSON(http.StatusOK, comment)
+	})
+}
+
+// HandleGetComments lists the top-level comments of the task, oldest first,
+// each with all of its replies.
+func HandleGetComments(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		task, err := readRouteTask(c, db)
+		if err != nil || task.ID == 0 {
+			return err
+		}
+
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+		filter := model.CommentFilter{TaskID: task.ID}
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != "id" {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.AfterID = lc.ID
+		}
+
+		comments := model.Comments{}
+		filter.Limit = limit + 1
+		if err := comments.List(db, filter); err != nil {
+			log.Println("err listing comments: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list comments"})
+		}
+		total, err := comments.Count(db, filter)
+		if err != nil {
+			log.Println("err counting comments: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list comments"})
+		}
+
+		page := schema.Page[model.Comment]{Items: comments, Total: total}
+		if len(comments) > limit {
+			page.Items = comments[:limit]
+			page.NextCursor, err = utils.EncodeCursor(listCursor{Sort: "id", ID: page.Items[limit-1].ID})
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list comments"})
+			}
+		}
+		return c.JSON(http.StatusOK, page)
+	})
+}
+
+// HandlePatchComment replaces the content of a comment. Only the author can
+// edit a comment.
+func HandlePatchComment(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		task, err := readRouteTask(c, db)
+		if err != nil || task.ID == 0 {
+			return err
+		}
+		comment, err := readRouteComment(c, db, task)
+		if err != nil || comment.ID == 0 {
+			return err
+		}
+		if comment.UserID != user.ID {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "only the author can edit a comment"})
+		}
+		commentIn, msg := decodeCommentContent(c)
+		if msg != "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: msg})
+		}
+		if commentIn.Content == comment.Content {
+			return c.JSON(http.StatusOK, comment)
+		}
+
+		comment.Content = commentIn.Content
+		if err := comment.Update(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("comment '%d' not found on task '%d'", comment.ID, task.ID)})
+			}
+			log.Println("err updating comment: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update comment"})
+		}
+		updateMentions(db, commentMentions(task, comment), comment.Content)
+		return c.JSON(http.StatusOK, comment)
+	})
+}
+
+// HandleDeleteComment deletes a comment. The author, admins and the
+// managers of the project can delete comments.
+func HandleDeleteComment(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		task, err := readRouteTask(c, db)
+		if err != nil || task.ID == 0 {
+			return err
+		}
+		comment, err := readRouteComment(c, db, task)
+		if err != nil || comment.ID == 0 {
+			return err
+		}
+		if comment.UserID != user.ID {
+			permissions := model.Permissions{}
+			if err := permissions.ReadRolePermissions(db, user.RoleID); err != nil {
+				log.Println("err reading role permissions: ", err)
+				return errors.New("unable to read role permissions")
+			}
+			role, _ := c.Get("project_role").(constants.ProjectRole)
+			subject := policy.Subject{User: user, Permissions: permissions, ProjectRole: role}
+			if ok, _ := policy.AnyOf(policy.IsAdmin, policy.IsProjectManager)(subject, task); !ok {
+				return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "only the author or a project manager can delete a comment"})
+			}
+		}
+
+		if err := comment.Delete(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("comment '%d' not found on task '%d'", comment.ID, task.ID)})
+			}
+			log.Println("err deleting comment: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to delete comment"})
+		}
+		updateMentions(db, commentMentions(task, comment), "")
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// HandleGetCommentHistory lists the earlier contents of a comment, oldest
+// first.
+func HandleGetCommentHistory(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		task, err := readRouteTask(c, db)
+		if err != nil || task.ID == 0 {
+			return err
+		}
+		comment, err := readRouteComment(c, db, task)
+		if err != nil || comment.ID == 0 {
+			return err
+		}
+
+		revisions := model.CommentRevisions{}
+		if err := revisions.ReadByComment(db, comment.ID); err != nil {
+			log.Println("err reading comment history: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read comment history"})
+		}
+		return c.JSON(http.StatusOK, revisions)
+	})
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func commentRequest(t *testing.T, authRes schema.AuthResponse, method, path, jsonStr string, task model.Task, commentID int, permission string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	names := []string{"projectID", "id"}
+	values := []string{fmt.Sprintf("%d", task.ProjectID), fmt.Sprintf("%d", task.ID)}
+	if commentID != 0 {
+		names = append(names, "commentID")
+		values = append(values, fmt.Sprintf("%d", commentID))
+	}
+	return authRequest(
+		t, authRes, method, "http://localhost:8080/project/:projectID/task/:id/comments"+path, jsonStr, names, values,
+		h, mw.ProjectPermissionRequired(tDB, permission),
+	)
+}
+
+func postComment(t *testing.T, authRes schema.AuthResponse, task model.Task, content string, parentID int) (int, model.Comment) {
+	jsonStr := fmt.Sprintf(`{"content": "%s", "parent_id": %d}`, content, parentID)
+	rec := commentRequest(t, authRes, "POST", "", jsonStr, task, 0, "update task", HandlePostComment(tDB))
+	comment := model.Comment{}
+	if rec.Code == http.StatusOK {
+		err := json.NewDecoder(rec.Body).Decode(&comment)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, comment
+}
+
+func listComments(t *testing.T, authRes schema.AuthResponse, task model.Task, query string) schema.Page[model.Comment] {
+	rec := commentRequest(t, authRes, "GET", "?"+query, "", task, 0, "read task", HandleGetComments(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	page := schema.Page[model.Comment]{}
+	err := json.NewDecoder(rec.Body).Decode(&page)
+	assert.AssertEq(t, err, nil)
+	return page
+}
+
+func TestCommentsShouldThreadReplies(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "Discussed task", "Content", constants.Todo)
+	userAuth := login(t, testUserIn.Email, testUserIn.Password)
+	managerAuth := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	code, first := postComment(t, userAuth, task, "First", 0)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, first.UserID, testUser.ID)
+	code, reply := postComment(t, managerAuth, task, "Reply", first.ID)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, reply.ParentID, first.ID)
+	code, _ = postComment(t, userAuth, task, "Nested", reply.ID)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+	code, _ = postComment(t, userAuth, task, "   ", 0)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+	for _, content := range []string{"Second", "Third"} {
+		code, _ = postComment(t, userAuth, task, content, 0)
+		assert.AssertEq(t, code, http.StatusOK)
+	}
+
+	// Replies only belong to comments on the same task.
+	code, _ = postComment(t, userAuth, testTask, "Elsewhere", first.ID)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+
+	page := listComments(t, userAuth, task, "limit=2")
+	assert.AssertEq(t, page.Total, 3)
+	assert.AssertEq(t, len(page.Items), 2)
+	assert.AssertEq(t, page.Items[0].Content, "First")
+	assert.AssertEq(t, len(page.Items[0].Replies), 1)
+	assert.AssertEq(t, page.Items[0].Replies[0].Content, "Reply")
+	page = listComments(t, userAuth, task, "limit=2&cursor="+page.NextCursor)
+	assert.AssertEq(t, len(page.Items), 1)
+	assert.AssertEq(t, page.Items[0].Content, "Third")
+	assert.AssertEq(t, page.NextCursor, "")
+}
+
+func TestEditCommentShouldKeepHistory(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "Edited discussion", "Content", constants.Todo)
+	userAuth := login(t, testUserIn.Email, testUserIn.Password)
+	managerAuth := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	_, comment := postComment(t, userAuth, task, "Original", 0)
+
+	edit := func(authRes schema.AuthResponse, content string) *httptest.ResponseRecorder {
+		jsonStr := fmt.Sprintf(`{"content": "%s"}`, content)
+		return commentRequest(t, authRes, "PATCH", "/:commentID", jsonStr, task, comment.ID, "update task", HandlePatchComment(tDB))
+	}
+	assert.AssertEq(t, edit(managerAuth, "Hijacked").Code, http.StatusForbidden)
+	assert.AssertEq(t, edit(userAuth, "Edited").Code, http.StatusOK)
+	rec := edit(userAuth, "Edited again")
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	edited := model.Comment{}
+	err := json.NewDecoder(rec.Body).Decode(&edited)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, edited.Content, "Edited again")
+	assert.AssertNotEq(t, edited.EditedAt, int64(0))
+
+	rec = commentRequest(t, managerAuth, "GET", "/:commentID/history", "", task, comment.ID, "read task", HandleGetCommentHistory(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	revisions := model.CommentRevisions{}
+	err = json.NewDecoder(rec.Body).Decode(&revisions)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(revisions), 2)
+	assert.AssertEq(t, revisions[0].Content, "Original")
+	assert.AssertEq(t, revisions[1].Content, "Edited")
+}
+
+func TestDeleteCommentShouldKeepReplies(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "Moderated discussion", "Content", constants.Todo)
+	userAuth := login(t, testUserIn.Email, testUserIn.Password)
+	managerAuth := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	_, comment := postComment(t, managerAuth, task, "Off topic", 0)
+	_, reply := postComment(t, userAuth, task, "Agreed", comment.ID)
+
+	remove := func(authRes schema.AuthResponse, commentID int) int {
+		rec := commentRequest(t, authRes, "DELETE", "/:commentID", "", task, commentID, "update task", HandleDeleteComment(tDB))
+		return rec.Code
+	}
+	assert.AssertEq(t, remove(userAuth, comment.ID), http.StatusForbidden)
+	// Project maintainers can delete comments of others.
+	assert.AssertEq(t, remove(managerAuth, reply.ID), http.StatusNoContent)
+	assert.AssertEq(t, remove(managerAuth, comment.ID), http.StatusNoContent)
+	assert.AssertEq(t, remove(managerAuth, comment.ID), http.StatusNotFound)
+
+	page := listComments(t, userAuth, task, "")
+	assert.AssertEq(t, len(page.Items), 1)
+	assert.AssertEq(t, page.Items[0].Content, "")
+	assert.AssertNotEq(t, page.Items[0].DeletedAt, int64(0))
+	assert.AssertEq(t, len(page.Items[0].Replies), 1)
+	assert.AssertEq(t, page.Items[0].Replies[0].Content, "")
+
+	code, _ := postComment(t, userAuth, task, "Too late", comment.ID)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+}
@@ -0,0 +1,7 @@
+// Package handler implements the HTTP handlers of the API.
+//
+// Side effects of a change, such as mentions, notifications, project events
+// and webhook events, are recorded by helpers called after the change has
+// been saved. The request has succeeded by then, so these helpers log their
+// failures instead of returning them.
+package handler
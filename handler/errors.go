@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isConstraintError reports whether err is an SQLite error with one of the
+// given extended constraint codes. sqlite3.Error does not implement Is, so
+// errors.Is cannot be used to match the codes.
+func isConstraintError(err error, codes ...sqlite3.ErrNoExtended) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	for _, code := range codes {
+		if sqliteErr.ExtendedCode == code {
+			return true
+		}
+	}
+	return false
+}
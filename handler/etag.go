@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+// setETag sets the ETag header of the response to the entity version.
+func setETag(c echo.Context, version int) {
+	c.Response().Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// ifMatchVersion returns the entity version required by the If-Match header
+// of the request, or 0 for "*", which matches any version. If the header is
+// missing or cannot match any version, a response has been written and ok
+// is false.
+func ifMatchVersion(c echo.Context) (version int, ok bool, err error) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if ifMatch == "" {
+		return 0, false, c.JSON(http.StatusPreconditionRequired, schema.MessageResponse{Message: "If-Match header is required"})
+	}
+	if ifMatch == "*" {
+		return 0, true, nil
+	}
+	// Weak tags never match in If-Match, and lists are not supported.
+	version, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(ifMatch, `"`), `"`))
+	if err != nil || version <= 0 || !strings.HasPrefix(ifMatch, `"`) {
+		return 0, false, c.JSON(http.StatusPreconditionFailed, schema.MessageResponse{Message: fmt.Sprintf("invalid If-Match '%s'", ifMatch)})
+	}
+	return version, true, nil
+}
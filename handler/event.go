@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+// projectEventBatchSize is the number of events read from the log at a
+// time for a stream.
+const projectEventBatchSize = 100
+
+// projectEventSignals holds a channel per project with open event streams,
+// which is closed to wake them up when an event is recorded for the
+// project.
+var projectEventSignals = struct {
+	sync.Mutex
+	signals map[int]chan struct{}
+}{signals: map[int]chan struct{}{}}
+
+// projectEventSignal returns a channel that is closed when the next event
+// of the project is recorded.
+func projectEventSignal(projectID int) <-chan struct{} {
+	projectEventSignals.Lock()
+	defer projectEventSignals.Unlock()
+	signal, ok := projectEventSignals.signals[projectID]
+	if !ok {
+		signal = make(chan struct{})
+		projectEventSignals.signals[projectID] = signal
+	}
+	return signal
+}
+
+// signalProjectEvent wakes up the event streams of the project.
+func signalProjectEvent(projectID int) {
+	projectEventSignals.Lock()
+	defer projectEventSignals.Unlock()
+	if signal, ok := projectEventSignals.signals[projectID]; ok {
+		close(signal)
+		delete(projectEventSignals.signals, projectID)
+	}
+}
+
+// publishTaskEvent records the event in the event log of the task's
+// project, wakes up its event streams and queues the event for its
+// webhooks. The webhooks are sent the event even if it could not be
+// recorded in the log.
+func publishTaskEvent(db *sql.DB, event string, task model.Task) {
+	now := time.Now()
+	data, err := json.Marshal(task)
+	if err != nil {
+		log.Println("err encoding project event: ", err)
+	} else {
+		e := model.ProjectEvent{ProjectID: task.ProjectID, Type: event, TaskID: task.ID, Data: data, CreatedAt: now.Unix()}
+		if err := e.Create(db, now.Add(-config.EVENT_LOG_RETENTION).Unix()); err != nil {
+			log.Println("err creating project event: ", err)
+		} else {
+			signalProjectEvent(task.ProjectID)
+		}
+	}
+	enqueueWebhookEvent(db, task.ProjectID, event, task)
+}
+
+// writeProjectEvents writes the events of the project after afterID to the
+// stream and returns the ID of the last event written.
+func writeProjectEvents(res *echo.Response, db *sql.DB, projectID, afterID int) (int, error) {
+	for {
+		events := model.ProjectEvents{}
+		if err := events.ListAfter(db, projectID, afterID, projectEventBatchSize); err != nil {
+			return afterID, err
+		}
+		for _, e := range events {
+			if _, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data); err != nil {
+				return afterID, err
+			}
+			afterID = e.ID
+		}
+		if len(events) > 0 {
+			res.Flush()
+		}
+		if len(events) < projectEventBatchSize {
+			return afterID, nil
+		}
+	}
+}
+
+// HandleGetProjectEvents streams the task events of the project as
+// Server-Sent Events. Each event has the ID of its entry in the event log,
+// the task event as its type and the task as its data. A client resuming
+// with the Last-Event-ID header is first sent the events it missed that
+// are still in the log; other clients only receive new events. If some of
+// the events it missed have already been removed from the log, it is sent
+// a "reset" event instead and should read the tasks again. A heartbeat
+// comment is sent every config.EVENT_HEARTBEAT_INTERVAL, and the stream
+// ends once the token it was opened with expires or is revoked, or the
+// user may no longer read the tasks of the project.
+func HandleGetProjectEvents(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		expiresAt, _ := c.Get("token_expires_at").(int64)
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		afterID := 0
+		reset := false
+		lastEventID := c.Request().Header.Get("Last-Event-ID")
+		if lastEventID != "" {
+			afterID, err = strconv.Atoi(lastEventID)
+			if err != nil || afterID < 0 {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid Last-Event-ID '%s'", lastEventID)})
+			}
+			oldestID, err := model.OldestProjectEventID(db)
+			if err != nil {
+				log.Println("err reading oldest project event ID: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read project events"})
+			}
+			reset = afterID+1 < oldestID
+		}
+		if lastEventID == "" || reset {
+			afterID, err = model.LastProjectEventID(db)
+			if err != nil {
+				log.Println("err reading last project event ID: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read project events"})
+			}
+		}
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set(echo.HeaderCacheControl, "no-cache")
+		res.Header().Set(echo.HeaderConnection, "keep-alive")
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+		if reset {
+			if _, err := fmt.Fprintf(res, "id: %d\nevent: reset\ndata: {}\n\n", afterID); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+
+		ctx := c.Request().Context()
+		heartbeat := time.NewTicker(config.EVENT_HEARTBEAT_INTERVAL)
+		defer heartbeat.Stop()
+		expiry := time.NewTimer(time.Until(time.Unix(expiresAt, 0)))
+		defer expiry.Stop()
+		for {
+			ok, err := mw.ProjectPermissionHolds(c, db, "read task")
+			if err != nil {
+				log.Println("err checking project event stream access: ", err)
+				return nil
+			}
+			if !ok {
+				return nil
+			}
+			// Take the signal before reading the log so that an event
+			// recorded in between wakes the stream up.
+			signal := projectEventSignal(pID)
+			afterID, err = writeProjectEvents(res, db, pID, afterID)
+			if err != nil {
+				log.Println("err writing project events: ", err)
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-expiry.C:
+				return nil
+			case <-signal:
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+				res.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+type sseEvent struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+// newEventServer serves project event streams the way main routes them.
+func newEventServer(t *testing.T) *httptest.Server {
+	heartbeat := config.EVENT_HEARTBEAT_INTERVAL
+	config.EVENT_HEARTBEAT_INTERVAL = 50 * time.Millisecond
+	e := echo.New()
+	e.GET("/project/:id/events", HandleGetProjectEvents(tDB), mw.JwtMiddleware(tDB), mw.ProjectPermissionRequired(tDB, "read task"))
+	srv := httptest.NewServer(e)
+	t.Cleanup(func() {
+		srv.Close()
+		config.EVENT_HEARTBEAT_INTERVAL = heartbeat
+	})
+	return srv
+}
+
+// openEventStream opens the event stream of the project. The caller closes
+// the body of the response if its status is 200.
+func openEventStream(t *testing.T, srv *httptest.Server, authRes schema.AuthResponse, projectID int, lastEventID string) *http.Response {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/project/%d/events", srv.URL, projectID), nil)
+	assert.AssertEq(t, err, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	assert.AssertEq(t, err, nil)
+	return res
+}
+
+// readSSEEvent reads the next event or comment of the stream.
+func readSSEEvent(t *testing.T, r *bufio.Reader) (sseEvent, error) {
+	e := sseEvent{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return e, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return e, nil
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			e.id = value
+		case "event":
+			e.event = value
+		case "data":
+			e.data = value
+		case "":
+			e.comment = value
+		default:
+			t.Fatalf("unexpected field '%s'", field)
+		}
+	}
+}
+
+// readUntilEnd reads the stream until it ends, expecting only heartbeats.
+func readUntilEnd(t *testing.T, r *bufio.Reader) {
+	for {
+		e, err := readSSEEvent(t, r)
+		if err != nil {
+			assert.AssertEq(t, err.Error(), "EOF")
+			return
+		}
+		assert.AssertEq(t, e.comment, "heartbeat")
+	}
+}
+
+// readTaskEvent reads the next event of the stream, skipping heartbeats.
+func readTaskEvent(t *testing.T, r *bufio.Reader) (sseEvent, model.Task) {
+	for {
+		e, err := readSSEEvent(t, r)
+		assert.AssertEq(t, err, nil)
+		if e.comment == "heartbeat" {
+			continue
+		}
+		task := model.Task{}
+		err = json.Unmarshal([]byte(e.data), &task)
+		assert.AssertEq(t, err, nil)
+		return e, task
+	}
+}
+
+func TestProjectEventsShouldStreamTaskEvents(t *testing.T) {
+	srv := newEventServer(t)
+	userAuth := login(t, testUserIn.Email, testUserIn.Password)
+	managerAuth := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	res := openEventStream(t, srv, userAuth, testProject.ID, "")
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	assert.AssertEq(t, res.Header.Get("Content-Type"), "text/event-stream")
+	defer res.Body.Close()
+	stream := bufio.NewReader(res.Body)
+	heartbeat, err := readSSEEvent(t, stream)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, heartbeat.comment, "heartbeat")
+
+	rec, c := createContextWithParams(
+		"POST",
+		"http://localhost:8080/project/:projectID/task/create",
+		fmt.Sprintf(`{"assignee_id": %d, "title": "Live", "content": "Content"}`, testUser.ID),
+		[]string{"projectID"},
+		[]string{fmt.Sprintf("%d", testProject.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", managerAuth.TokenType, managerAuth.AccessToken))
+	err = mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	task := model.Task{}
+	err = json.NewDecoder(rec.Body).Decode(&task)
+	assert.AssertEq(t, err, nil)
+
+	created, data := readTaskEvent(t, stream)
+	assert.AssertEq(t, created.event, model.WebhookTaskCreated)
+	assert.AssertEq(t, data.ID, task.ID)
+	assert.AssertEq(t, data.Title, "Live")
+
+	code, _ := transitionTask(t, managerAuth, task, "doing")
+	assert.AssertEq(t, code, http.StatusOK)
+	updated, data := readTaskEvent(t, stream)
+	assert.AssertEq(t, updated.event, model.WebhookTaskUpdated)
+	assert.AssertEq(t, string(data.Status), "doing")
+
+	rec, c = createContextWithParams(
+		"DELETE",
+		"http://localhost:8080/project/:projectID/task/:id",
+		"",
+		[]string{"projectID", "id"},
+		[]string{fmt.Sprintf("%d", testProject.ID), fmt.Sprintf("%d", task.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", managerAuth.TokenType, managerAuth.AccessToken))
+	err = mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "delete task")(mw.TaskPolicyRequired(tDB, "delete task")(HandleDeleteTask(tDB))))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	deleted, data := readTaskEvent(t, stream)
+	assert.AssertEq(t, deleted.event, model.WebhookTaskDeleted)
+	assert.AssertEq(t, data.ID, task.ID)
+	res.Body.Close()
+
+	// A client resuming after the first event is sent the events it missed.
+	res = openEventStream(t, srv, userAuth, testProject.ID, created.id)
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	stream = bufio.NewReader(res.Body)
+	e, _ := readTaskEvent(t, stream)
+	assert.AssertEq(t, e.id, updated.id)
+	assert.AssertEq(t, e.event, model.WebhookTaskUpdated)
+	e, _ = readTaskEvent(t, stream)
+	assert.AssertEq(t, e.id, deleted.id)
+}
+
+func TestProjectEventsShouldRequireReadTask(t *testing.T) {
+	srv := newEventServer(t)
+	userIn, user := createTestUserWithRole("eventviewer@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+
+	res := openEventStream(t, srv, authRes, testProject.ID, "")
+	res.Body.Close()
+	assert.AssertEq(t, res.StatusCode, http.StatusForbidden)
+
+	addTestProjectMember(testProject.ID, user.ID, constants.ProjectViewer)
+	res = openEventStream(t, srv, authRes, testProject.ID, "not-an-id")
+	res.Body.Close()
+	assert.AssertEq(t, res.StatusCode, http.StatusBadRequest)
+
+	res = openEventStream(t, srv, authRes, testProject.ID, "")
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	stream := bufio.NewReader(res.Body)
+	e, err := readSSEEvent(t, stream)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, e.comment, "heartbeat")
+
+	// The stream ends once the user is removed from the project.
+	member := model.ProjectMember{ProjectID: testProject.ID, UserID: user.ID}
+	err = member.Delete(tDB)
+	assert.AssertEq(t, err, nil)
+	readUntilEnd(t, stream)
+}
+
+func TestProjectEventsShouldEndWhenTokenIsRevokedOrExpires(t *testing.T) {
+	srv := newEventServer(t)
+	userIn, user := createTestUserWithRole("eventrevoked@example.com", "Testpass1", constants.UserRoleID)
+	addTestProjectMember(testProject.ID, user.ID, constants.ProjectViewer)
+
+	authRes := login(t, userIn.Email, userIn.Password)
+	res := openEventStream(t, srv, authRes, testProject.ID, "")
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	err := user.RevokeSessions(tDB)
+	assert.AssertEq(t, err, nil)
+	readUntilEnd(t, bufio.NewReader(res.Body))
+
+	ttl := config.ACCESS_TOKEN_TTL
+	config.ACCESS_TOKEN_TTL = time.Second
+	t.Cleanup(func() { config.ACCESS_TOKEN_TTL = ttl })
+	authRes = login(t, userIn.Email, userIn.Password)
+	res = openEventStream(t, srv, authRes, testProject.ID, "")
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	readUntilEnd(t, bufio.NewReader(res.Body))
+}
+
+func TestProjectEventsShouldEndWhenRoleRequiresTwoFactor(t *testing.T) {
+	srv := newEventServer(t)
+	role := model.Role{Name: "two-factor event reader"}
+	err := role.Create(tDB, []int{int(constants.ReadTask)})
+	assert.AssertEq(t, err, nil)
+	userIn, user := createTestUserWithRole("eventtwofactor@example.com", "Testpass1", role.ID)
+	addTestProjectMember(testProject.ID, user.ID, constants.ProjectViewer)
+
+	authRes := login(t, userIn.Email, userIn.Password)
+	res := openEventStream(t, srv, authRes, testProject.ID, "")
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	role.TwoFactorRequired = true
+	err = role.UpdateTwoFactorRequired(tDB)
+	assert.AssertEq(t, err, nil)
+	readUntilEnd(t, bufio.NewReader(res.Body))
+}
+
+func TestProjectEventsShouldResetWhenEventsHaveBeenRemoved(t *testing.T) {
+	srv := newEventServer(t)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	// The log is pruned by age, so the retained event is recorded later
+	// than every other event and the rest of the log is removed.
+	now := time.Now().Unix()
+	removed := model.ProjectEvent{ProjectID: testProject.ID, Type: model.WebhookTaskUpdated, Data: []byte("{}"), CreatedAt: now}
+	err := removed.Create(tDB, 0)
+	assert.AssertEq(t, err, nil)
+	retained := model.ProjectEvent{ProjectID: testProject.ID, Type: model.WebhookTaskUpdated, Data: []byte("{}"), CreatedAt: now + 100}
+	err = retained.Create(tDB, now+50)
+	assert.AssertEq(t, err, nil)
+
+	res := openEventStream(t, srv, authRes, testProject.ID, fmt.Sprintf("%d", removed.ID-1))
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	e, _ := readTaskEvent(t, bufio.NewReader(res.Body))
+	assert.AssertEq(t, e.event, "reset")
+	assert.AssertEq(t, e.id, fmt.Sprintf("%d", retained.ID))
+
+	res = openEventStream(t, srv, authRes, testProject.ID, fmt.Sprintf("%d", removed.ID))
+	assert.AssertEq(t, res.StatusCode, http.StatusOK)
+	defer res.Body.Close()
+	e, _ = readTaskEvent(t, bufio.NewReader(res.Body))
+	assert.AssertEq(t, e.id, fmt.Sprintf("%d", retained.ID))
+	assert.AssertEq(t, e.event, model.WebhookTaskUpdated)
+}
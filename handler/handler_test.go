@@ -8,14 +8,18 @@ import (
 	"log"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/labstack/echo/v4"
 	"github.com/pressly/goose"
 	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
 	"github.com/tomihaapalainen/go-task-mgmt/dotenv"
+	"github.com/tomihaapalainen/go-task-mgmt/email"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
 	"github.com/tomihaapalainen/go-task-mgmt/schema"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -33,9 +37,42 @@ var testProject model.Project
 var testProjectForDeletion model.Project
 var testTask model.Task
 var testTaskForDeletion model.Task
+var tMailer = &testMailer{}
+
+// testMailer records sent messages instead of delivering them.
+type testMailer struct {
+	messages []email.Message
+}
+
+func (m *testMailer) Send(msg email.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// lastToken returns the token in the last message sent to address.
+func (m *testMailer) lastToken(t *testing.T, address string) string {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != address {
+			continue
+		}
+		_, rest, ok := strings.Cut(m.messages[i].Body, "token: ")
+		if !ok {
+			t.Fatalf("no token in '%s'", m.messages[i].Body)
+		}
+		return strings.Fields(rest)[0]
+	}
+	t.Fatalf("no message sent to '%s'", address)
+	return ""
+}
 
 func TestMain(m *testing.M) {
 	dotenv.ParseDotenv("../.env")
+	config.BCRYPT_COST = bcrypt.MinCost
+	avatarDir, err := os.MkdirTemp("", "avatars")
+	if err != nil {
+		log.Fatal("err creating avatar directory: ", err)
+	}
+	config.AVATAR_DIR = avatarDir
 	tDB, _ = sql.Open("sqlite3", "file:.///db.sqlite3?_fk=ON")
 
 	if err := goose.SetDialect("sqlite3"); err != nil {
@@ -51,11 +88,14 @@ func TestMain(m *testing.M) {
 	testUserForRoleIn, testUserForRole = createTestUserWithRole("testuserforrole@example.com", "Testpass1", constants.UserRoleID)
 	testProject = createTestProject("Test project", testAdmin.ID)
 	testProjectForDeletion = createTestProject("Test project for deletion", testAdmin.ID)
+	addTestProjectMember(testProject.ID, testProjectManager.ID, constants.ProjectMaintainer)
+	addTestProjectMember(testProject.ID, testUser.ID, constants.ProjectContributor)
 	testTask = createTestTask(testUser.ID, testUser.ID, "Test user task", "Test user task content", constants.Todo)
 	testTaskForDeletion = createTestTask(testUser.ID, testUser.ID, "Test user task for deletion", "Test user task content", constants.Todo)
 
 	code := m.Run()
-	if err := goose.Down(tDB, "../migrations"); err != nil {
+	os.RemoveAll(avatarDir)
+	if err := goose.DownTo(tDB, "../migrations", 0); err != nil {
 		log.Fatal("err running migrations: ", err)
 	}
 	os.Exit(code)
@@ -90,12 +130,15 @@ func createTestUserWithRole(email, password string, roleID constants.RoleID) (sc
 	userIn.Password = password
 	user.Email = email
 	user.RoleID = roleID
-	b, _ := bcrypt.GenerateFromPassword([]byte(userIn.Email), 4)
+	b, _ := bcrypt.GenerateFromPassword([]byte(userIn.Password), bcrypt.MinCost)
 	user.PasswordHash = string(b)
 	err := user.Create(tDB)
 	if err != nil {
 		log.Fatal("err creating test user: ", err)
 	}
+	if err := user.SetEmailVerified(tDB); err != nil {
+		log.Fatal("err verifying test user: ", err)
+	}
 	return userIn, user
 }
 
@@ -111,6 +154,13 @@ func createTestProject(name string, userID int) model.Project {
 	return p
 }
 
+func addTestProjectMember(projectID, userID int, role constants.ProjectRole) {
+	m := model.ProjectMember{ProjectID: projectID, UserID: userID, Role: role}
+	if err := m.Create(tDB); err != nil {
+		log.Fatal("err creating test project member: ", err)
+	}
+}
+
 func createTestTask(assigneeID, creatorID int, title, content string, status constants.TaskStatus) model.Task {
 	t := model.Task{
 		ProjectID:  testProject.ID,
@@ -137,3 +187,19 @@ func login(t *testing.T, email, password string) schema.AuthResponse {
 	assert.AssertEq(t, err, nil)
 	return res
 }
+
+// authRequest runs h for a request made with the tokens of authRes, behind
+// JwtMiddleware and then middlewares in the order given.
+func authRequest(
+	t *testing.T, authRes schema.AuthResponse, method, url, jsonStr string, names, values []string,
+	h echo.HandlerFunc, middlewares ...echo.MiddlewareFunc,
+) *httptest.ResponseRecorder {
+	rec, c := createContextWithParams(method, url, jsonStr, names, values)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	err := mw.JwtMiddleware(tDB)(h)(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
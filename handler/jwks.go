@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/keys"
+)
+
+func HandleGetJWKS(ks *keys.KeySet) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "public, max-age=300")
+		return c.JSON(http.StatusOK, ks.JWKS())
+	})
+}
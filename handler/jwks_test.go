@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/keys"
+)
+
+func TestGetJWKSShouldPublishOnlyPublicKeys(t *testing.T) {
+	ks := keys.NewKeySet(keys.NewHMAC("hmac", []byte("secret")))
+	rsaKey, err := ks.RotateTo(keys.RS256, time.Hour)
+	assert.AssertEq(t, err, nil)
+	edKey, err := ks.RotateTo(keys.EdDSA, time.Hour)
+	assert.AssertEq(t, err, nil)
+
+	rec, c := createContext("GET", "http://localhost:8080/.well-known/jwks.json", "")
+	err = HandleGetJWKS(ks)(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	jwks := keys.JWKS{}
+	err = json.NewDecoder(rec.Body).Decode(&jwks)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(jwks.Keys), 2)
+	assert.AssertEq(t, jwks.Keys[0].Kid, rsaKey.ID)
+	assert.AssertEq(t, jwks.Keys[0].Kty, "RSA")
+	assert.AssertEq(t, jwks.Keys[0].E, "AQAB")
+	assert.AssertEq(t, jwks.Keys[1].Kid, edKey.ID)
+	assert.AssertEq(t, jwks.Keys[1].Kty, "OKP")
+	assert.AssertEq(t, jwks.Keys[1].Crv, "Ed25519")
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginAttempt is a login attempt counted against the throttles of an
+// account and a client IP before the credentials are checked.
+type loginAttempt struct {
+	now     time.Time
+	account model.LoginThrottle
+	ip      model.LoginThrottle
+}
+
+// startLoginAttempt counts a login attempt under the account and IP keys.
+// If either of them is blocked or locked out, nothing is counted and wait
+// is how long the client has to wait before it may try again. Expired
+// throttles are removed first.
+func startLoginAttempt(db *sql.DB, now time.Time, accountKey, ipKey string) (a loginAttempt, wait time.Duration, err error) {
+	resetBefore := now.Add(-config.LOGIN_FAILURE_WINDOW).Unix()
+	if err := model.DeleteExpiredLoginThrottles(db, now.Unix(), resetBefore); err != nil {
+		log.Println("err deleting expired login throttles: ", err)
+	}
+
+	a = loginAttempt{now: now, account: model.LoginThrottle{Key: accountKey}, ip: model.LoginThrottle{Key: ipKey}}
+	ok, err := a.ip.Attempt(db, now.Unix(), resetBefore, config.LOGIN_IP_LOCKOUT_THRESHOLD)
+	if err != nil || !ok {
+		return a, retryAfter(now, a.ip), err
+	}
+	ok, err = a.account.Attempt(db, now.Unix(), resetBefore, config.LOGIN_LOCKOUT_THRESHOLD)
+	if err != nil || !ok {
+		if err := a.ip.Forgive(db); err != nil {
+			log.Println("err forgiving login attempt: ", err)
+		}
+		return a, retryAfter(now, a.account), err
+	}
+	return a, 0, nil
+}
+
+// retryAfter returns how long the client has to wait before t lets it try
+// to log in again. A throttle that is not blocked has attempts in progress
+// up to its limit, so the client waits for them.
+func retryAfter(now time.Time, t model.LoginThrottle) time.Duration {
+	until := max(t.BlockedUntil, t.LockedUntil)
+	if until <= now.Unix() {
+		return config.LOGIN_BACKOFF_BASE
+	}
+	return time.Unix(until, 0).Sub(now)
+}
+
+// forgive uncounts the attempt, once the credentials have been accepted or
+// if they could not be checked.
+func (a loginAttempt) forgive(db *sql.DB) {
+	for _, t := range []model.LoginThrottle{a.account, a.ip} {
+		if err := t.Forgive(db); err != nil {
+			log.Println("err forgiving login attempt: ", err)
+		}
+	}
+}
+
+// fail blocks the account and the IP if the attempt brought them to their
+// limits.
+func (a loginAttempt) fail(db *sql.DB) {
+	if err := blockLogin(db, a.now, a.account, config.LOGIN_BACKOFF_AFTER, config.LOGIN_LOCKOUT_THRESHOLD); err != nil {
+		log.Println("err recording failed login: ", err)
+	}
+	if err := blockLogin(db, a.now, a.ip, config.LOGIN_IP_BACKOFF_AFTER, config.LOGIN_IP_LOCKOUT_THRESHOLD); err != nil {
+		log.Println("err recording failed login: ", err)
+	}
+}
+
+// blockLogin blocks further attempts under t for an exponentially growing
+// time once backoffAfter failures have been counted. At lockoutThreshold
+// failures the key is locked out for config.LOGIN_LOCKOUT_DURATION and an
+// audit entry is made.
+func blockLogin(db *sql.DB, now time.Time, t model.LoginThrottle, backoffAfter, lockoutThreshold int) error {
+	if t.Failures >= lockoutThreshold {
+		t.LockedUntil = now.Add(config.LOGIN_LOCKOUT_DURATION).Unix()
+		audit := model.AuditEntry{
+			CreatedAt: now.Unix(),
+			Action:    model.AuditLoginLockout,
+			Subject:   t.Key,
+			Details: fmt.Sprintf(
+				"%d failed logins, locked until %s",
+				t.Failures, time.Unix(t.LockedUntil, 0).UTC().Format(time.RFC3339)),
+		}
+		if err := audit.Create(db); err != nil {
+			log.Println("err creating audit entry: ", err)
+		}
+	} else if t.Failures >= backoffAfter {
+		backoff := config.LOGIN_BACKOFF_MAX
+		if shift := t.Failures - backoffAfter; shift < 32 {
+			backoff = min(config.LOGIN_BACKOFF_BASE<<shift, config.LOGIN_BACKOFF_MAX)
+		}
+		t.BlockedUntil = now.Add(backoff).Unix()
+	} else {
+		return nil
+	}
+	return t.UpdateBlock(db)
+}
+
+func tooManyLoginAttempts(c echo.Context, wait time.Duration) error {
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	return c.JSON(
+		http.StatusTooManyRequests,
+		schema.MessageResponse{Message: "Too many failed login attempts"},
+	)
+}
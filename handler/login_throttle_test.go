@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func loginFrom(t *testing.T, ip, email, password string) *httptest.ResponseRecorder {
+	jsonStr := fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password)
+	rec, c := createContext("POST", "http://localhost:8080/auth/login", jsonStr)
+	c.Request().Header.Set("X-Real-IP", ip)
+	err := HandlePostLogIn(tDB)(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func TestLoginBackoffShouldReturnRetryAfter(t *testing.T) {
+	userIn, _ := createTestUserWithRole("backoff@example.com", "Testpass1", constants.UserRoleID)
+	ip := "198.51.100.1"
+
+	for i := 0; i < config.LOGIN_BACKOFF_AFTER; i++ {
+		rec := loginFrom(t, ip, userIn.Email, "Wrongpass1")
+		assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	}
+	rec := loginFrom(t, ip, userIn.Email, userIn.Password)
+	assert.AssertEq(t, rec.Code, http.StatusTooManyRequests)
+	assert.AssertEq(t, rec.Header().Get("Retry-After"), "1")
+}
+
+func TestLoginLockoutShouldBeAuditedAndUnlockable(t *testing.T) {
+	userIn, user := createTestUserWithRole("lockout@example.com", "Testpass1", constants.UserRoleID)
+	ip := "198.51.100.2"
+	backoffAfter, threshold := config.LOGIN_BACKOFF_AFTER, config.LOGIN_LOCKOUT_THRESHOLD
+	config.LOGIN_BACKOFF_AFTER, config.LOGIN_LOCKOUT_THRESHOLD = 100, 3
+	defer func() { config.LOGIN_BACKOFF_AFTER, config.LOGIN_LOCKOUT_THRESHOLD = backoffAfter, threshold }()
+
+	for i := 0; i < config.LOGIN_LOCKOUT_THRESHOLD; i++ {
+		rec := loginFrom(t, ip, userIn.Email, "Wrongpass1")
+		assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	}
+	rec := loginFrom(t, ip, userIn.Email, userIn.Password)
+	assert.AssertEq(t, rec.Code, http.StatusTooManyRequests)
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	assert.AssertEq(t, err, nil)
+	if retryAfter < int(config.LOGIN_LOCKOUT_DURATION.Seconds())-1 {
+		t.Fatalf("Retry-After %d is shorter than the lockout", retryAfter)
+	}
+
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	rec, c := createContext("GET", "http://localhost:8080/audit?subject="+url.QueryEscape("account:"+userIn.Email), "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "manage users")(HandleGetAudit(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	page := schema.Page[model.AuditEntry]{}
+	err = json.NewDecoder(rec.Body).Decode(&page)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, page.Total, 1)
+	assert.AssertEq(t, page.Items[0].Action, model.AuditLoginLockout)
+
+	rec = userRequest(
+		t, authRes, "POST", "http://localhost:8080/user/:id/unlock",
+		[]string{fmt.Sprintf("%d", user.ID)}, HandlePostUnlockUser(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	rec = loginFrom(t, ip, userIn.Email, userIn.Password)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+}
+
+func TestLoginBackoffShouldApplyPerIP(t *testing.T) {
+	backoffAfter := config.LOGIN_IP_BACKOFF_AFTER
+	config.LOGIN_IP_BACKOFF_AFTER = 2
+	defer func() { config.LOGIN_IP_BACKOFF_AFTER = backoffAfter }()
+
+	for i := 0; i < config.LOGIN_IP_BACKOFF_AFTER; i++ {
+		rec := loginFrom(t, "198.51.100.3", fmt.Sprintf("guess%d@example.com", i), "Testpass1")
+		assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	}
+	rec := loginFrom(t, "198.51.100.3", testUserIn.Email, testUserIn.Password)
+	assert.AssertEq(t, rec.Code, http.StatusTooManyRequests)
+	rec = loginFrom(t, "198.51.100.4", testUserIn.Email, testUserIn.Password)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+}
+
+func TestLoginAttemptsInProgressShouldCountTowardsLockout(t *testing.T) {
+	threshold := config.LOGIN_LOCKOUT_THRESHOLD
+	config.LOGIN_LOCKOUT_THRESHOLD = 3
+	defer func() { config.LOGIN_LOCKOUT_THRESHOLD = threshold }()
+
+	// Attempts whose passwords are still being checked are counted, so
+	// concurrent guesses cannot get past the lockout threshold.
+	now := time.Now()
+	for i := 0; i < config.LOGIN_LOCKOUT_THRESHOLD; i++ {
+		_, wait, err := startLoginAttempt(tDB, now, accountThrottleKey("concurrent@example.com"), ipThrottleKey(fmt.Sprintf("198.51.100.%d", 10+i)))
+		assert.AssertEq(t, err, nil)
+		assert.AssertEq(t, wait, time.Duration(0))
+	}
+	_, wait, err := startLoginAttempt(tDB, now, accountThrottleKey("concurrent@example.com"), ipThrottleKey("198.51.100.20"))
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, wait, time.Duration(0))
+
+	// The refused attempt is not counted against the IP.
+	ip := model.LoginThrottle{Key: ipThrottleKey("198.51.100.20")}
+	err = ip.Read(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, ip.Failures, 0)
+}
+
+func TestExpiredLoginThrottlesShouldBeDeleted(t *testing.T) {
+	rec := loginFrom(t, "198.51.100.30", "unknown@example.com", "Testpass1")
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	throttle := model.LoginThrottle{Key: accountThrottleKey("unknown@example.com")}
+	err := throttle.Read(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, throttle.Failures, 1)
+
+	later := time.Now().Add(2 * config.LOGIN_FAILURE_WINDOW)
+	_, _, err = startLoginAttempt(tDB, later, accountThrottleKey("later@example.com"), ipThrottleKey("198.51.100.31"))
+	assert.AssertEq(t, err, nil)
+	throttle = model.LoginThrottle{Key: accountThrottleKey("unknown@example.com")}
+	err = throttle.Read(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, throttle.LastFailureAt, int64(0))
+}
@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/email"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+	"golang.org/x/text/language"
+)
+
+const maxDisplayNameLength = 100
+
+// avatarTypes maps the accepted avatar content types to file extensions.
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func timezoneIsValid(s string) bool {
+	if s == "" || s == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(s)
+	return err == nil
+}
+
+func HandleGetMe(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		setETag(c, user.Version)
+		return c.JSON(http.StatusOK, user)
+	})
+}
+
+func HandlePatchMe(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		profileIn := schema.ProfileIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&profileIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if profileIn.DisplayName != nil {
+			user.DisplayName = strings.TrimSpace(*profileIn.DisplayName)
+			if utf8.RuneCountInString(user.DisplayName) > maxDisplayNameLength {
+				return c.JSON(
+					http.StatusBadRequest,
+					schema.MessageResponse{Message: fmt.Sprintf("display name must be at most %d characters long", maxDisplayNameLength)})
+			}
+		}
+		if profileIn.Handle != nil {
+			user.Handle = strings.TrimPrefix(strings.TrimSpace(*profileIn.Handle), "@")
+			if user.Handle != "" && !model.ValidHandle(user.Handle) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid handle '%s'", user.Handle)})
+			}
+		}
+		if profileIn.Timezone != nil {
+			if !timezoneIsValid(*profileIn.Timezone) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid timezone '%s'", *profileIn.Timezone)})
+			}
+			user.Timezone = *profileIn.Timezone
+		}
+		if profileIn.Locale != nil {
+			tag, err := language.Parse(*profileIn.Locale)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid locale '%s'", *profileIn.Locale)})
+			}
+			user.Locale = tag.String()
+		}
+
+		if err := user.UpdateProfile(db); err != nil {
+			log.Println("err updating profile: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("handle '%s' is already taken", user.Handle)})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update profile"})
+		}
+		mw.ForgetUser(user.ID)
+		setETag(c, user.Version)
+		return c.JSON(http.StatusOK, user)
+	})
+}
+
+// HandlePutMeAvatar stores the image in the "avatar" form field in
+// config.AVATAR_DIR and replaces the user's previous avatar with it.
+func HandlePutMeAvatar(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		// Leave room for the rest of the multipart body around the file.
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, config.AVATAR_MAX_BYTES+4096)
+		fh, err := c.FormFile("avatar")
+		if err != nil {
+			log.Println("err reading avatar: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "missing or too large 'avatar' file"})
+		}
+		if fh.Size > config.AVATAR_MAX_BYTES {
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{Message: fmt.Sprintf("avatar must be at most %d bytes", config.AVATAR_MAX_BYTES)})
+		}
+		f, err := fh.Open()
+		if err != nil {
+			log.Println("err opening avatar: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read avatar"})
+		}
+		defer f.Close()
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Println("err reading avatar: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "unable to read avatar"})
+		}
+		contentType := http.DetectContentType(head[:n])
+		ext, ok := avatarTypes[contentType]
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("unsupported avatar type '%s'", contentType)})
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			log.Println("err reading avatar: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read avatar"})
+		}
+
+		suffix, err := utils.GenerateToken(8)
+		if err != nil {
+			log.Println("err generating avatar name: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to store avatar"})
+		}
+		name := fmt.Sprintf("%d-%s%s", user.ID, suffix, ext)
+		if err := writeAvatar(name, f); err != nil {
+			log.Println("err writing avatar: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to store avatar"})
+		}
+
+		previous := user.Avatar
+		user.Avatar = name
+		if err := user.UpdateAvatar(db); err != nil {
+			log.Println("err updating avatar: ", err)
+			removeAvatar(name)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to store avatar"})
+		}
+		removeAvatar(previous)
+		mw.ForgetUser(user.ID)
+		return c.JSON(http.StatusOK, user)
+	})
+}
+
+func HandleDeleteMeAvatar(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		previous := user.Avatar
+		user.Avatar = ""
+		if err := user.UpdateAvatar(db); err != nil {
+			log.Println("err updating avatar: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to remove avatar"})
+		}
+		removeAvatar(previous)
+		mw.ForgetUser(user.ID)
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+func writeAvatar(name string, r io.Reader) error {
+	if err := os.MkdirAll(config.AVATAR_DIR, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(config.AVATAR_DIR, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
+
+func removeAvatar(name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(config.AVATAR_DIR, filepath.Base(name))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("err removing avatar: ", err)
+	}
+}
+
+// HandlePostMePassword changes the user's password. All sessions and access
+// tokens are revoked, and the user is given the tokens of a new session.
+func HandlePostMePassword(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		passwordIn := schema.PasswordChangeIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&passwordIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !utils.CheckPassword(user.PasswordHash, passwordIn.CurrentPassword) {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "current password is incorrect"})
+		}
+		if !passwordIsValid(passwordIn.NewPassword) {
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{
+					Message: `'new_password' must be at least 8 characters long,
+						contain 1 upper case character, 1 lower case character and 1 digit`,
+				},
+			)
+		}
+
+		passwordHash, err := utils.HashPassword(passwordIn.NewPassword)
+		if err != nil {
+			log.Println("err generating password hash: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to change password"})
+		}
+		user.PasswordHash = passwordHash
+		if err := user.ChangePassword(db); err != nil {
+			log.Println("err changing password: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to change password"})
+		}
+		mw.ForgetUser(user.ID)
+		return startSession(c, db, user)
+	})
+}
+
+// HandlePostMeEmail starts an email change by mailing a confirmation token
+// to the new address. The address is changed by HandlePostConfirmEmail.
+func HandlePostMeEmail(db *sql.DB, mailer email.Mailer) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		emailIn := schema.EmailChangeIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&emailIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !utils.CheckPassword(user.PasswordHash, emailIn.Password) {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "current password is incorrect"})
+		}
+		emailIn.Email = strings.TrimSpace(emailIn.Email)
+		if !emailIsValid(emailIn.Email) {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "'email' must be a valid email address"})
+		}
+		existing := model.User{Email: emailIn.Email}
+		if err := existing.ReadByEmail(db); err == nil {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("User with email '%s' already exists", emailIn.Email)})
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("err reading user by email: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to change email"})
+		}
+
+		token, err := issueUserToken(db, user.ID, model.TokenEmailChange, emailIn.Email, config.EMAIL_CHANGE_TOKEN_TTL)
+		if err != nil {
+			log.Println("err issuing email change token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to change email"})
+		}
+
+		err = mailer.Send(email.Message{
+			To:      emailIn.Email,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf(
+				"Confirm your new email address with this token: %s\n\nThe token expires in %s.\n",
+				token, config.EMAIL_CHANGE_TOKEN_TTL),
+		})
+		if err != nil {
+			log.Println("err sending email change token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to send confirmation email"})
+		}
+		return c.JSON(
+			http.StatusAccepted,
+			schema.MessageResponse{Message: fmt.Sprintf("confirmation sent to '%s'", emailIn.Email)})
+	})
+}
+
+// HandlePostConfirmEmail changes the email address of the user the token
+// was sent to and marks it verified. It does not require authentication so
+// that the token can be used from any device.
+func HandlePostConfirmEmail(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		tokenIn := schema.TokenIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&tokenIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+
+		ut, err := consumeUserToken(db, tokenIn.Token, model.TokenEmailChange)
+		if err != nil {
+			if errors.Is(err, errInvalidUserToken) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: err.Error()})
+			}
+			log.Println("err consuming email change token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to change email"})
+		}
+
+		user := model.User{ID: ut.UserID, Email: ut.Data}
+		if err := user.UpdateEmail(db); err != nil {
+			log.Println("err updating email: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("User with email '%s' already exists", user.Email)})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to change email"})
+		}
+		if err := user.SetEmailVerified(db); err != nil {
+			log.Println("err verifying email: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to verify email"})
+		}
+		mw.ForgetUser(user.ID)
+		if err := user.ReadByID(db); err != nil {
+			log.Println("err reading user by id: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read user"})
+		}
+		return c.JSON(http.StatusOK, user)
+	})
+}
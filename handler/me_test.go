@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func meRequest(t *testing.T, authRes schema.AuthResponse, method, jsonStr string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	return authRequest(t, authRes, method, "http://localhost:8080/me", jsonStr, nil, nil, h)
+}
+
+func TestPatchMeShouldPass(t *testing.T) {
+	userIn, _ := createTestUserWithRole("profile@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+
+	jsonStr := `{"display_name": " Profile User ", "timezone": "Europe/Helsinki", "locale": "fi-fi"}`
+	rec := meRequest(t, authRes, "PATCH", jsonStr, HandlePatchMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+
+	rec = meRequest(t, authRes, "GET", "", HandleGetMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	u := model.User{}
+	err := json.NewDecoder(rec.Body).Decode(&u)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, u.DisplayName, "Profile User")
+	assert.AssertEq(t, u.Timezone, "Europe/Helsinki")
+	assert.AssertEq(t, u.Locale, "fi-FI")
+	assert.AssertEq(t, u.FormatTime(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)), "Mon, 15 Jan 2024 14:00 EET")
+
+	rec = meRequest(t, authRes, "PATCH", `{"locale": "en"}`, HandlePatchMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	u = model.User{}
+	err = json.NewDecoder(rec.Body).Decode(&u)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, u.Timezone, "Europe/Helsinki")
+	assert.AssertEq(t, u.Locale, "en")
+}
+
+func TestPatchMeWithInvalidDataShouldFail(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	for _, jsonStr := range []string{
+		`{"timezone": "Mars/Olympus_Mons"}`,
+		`{"timezone": "Local"}`,
+		`{"locale": "not a locale"}`,
+		fmt.Sprintf(`{"display_name": "%s"}`, strings.Repeat("x", maxDisplayNameLength+1)),
+	} {
+		t.Run(jsonStr, func(t *testing.T) {
+			rec := meRequest(t, authRes, "PATCH", jsonStr, HandlePatchMe(tDB))
+			assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+		})
+	}
+}
+
+func uploadAvatar(t *testing.T, authRes schema.AuthResponse, content []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("avatar", "avatar")
+	assert.AssertEq(t, err, nil)
+	_, err = part.Write(content)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, w.Close(), nil)
+
+	req := httptest.NewRequest("PUT", "http://localhost:8080/me/avatar", body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+	c.SetPath("/me/avatar")
+	err = mw.ContentTypeApplicationJSONOnlyExcept("/me/avatar")(mw.JwtMiddleware(tDB)(HandlePutMeAvatar(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func TestPutMeAvatarShouldPass(t *testing.T) {
+	userIn, _ := createTestUserWithRole("avatar@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+
+	rec := uploadAvatar(t, authRes, png)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	first := model.User{}
+	err := json.NewDecoder(rec.Body).Decode(&first)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, filepath.Ext(first.Avatar), ".png")
+	_, err = os.Stat(filepath.Join(config.AVATAR_DIR, first.Avatar))
+	assert.AssertEq(t, err, nil)
+
+	rec = uploadAvatar(t, authRes, png)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	_, err = os.Stat(filepath.Join(config.AVATAR_DIR, first.Avatar))
+	assert.AssertEq(t, os.IsNotExist(err), true)
+
+	rec = uploadAvatar(t, authRes, []byte("<html>not an image</html>"))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestPostMePasswordShouldPass(t *testing.T) {
+	userIn, _ := createTestUserWithRole("password@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	otherAuthRes := login(t, userIn.Email, userIn.Password)
+
+	rec := meRequest(t, authRes, "POST", `{"current_password": "Wrongpass1", "new_password": "Newpass12"}`, HandlePostMePassword(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+	rec = meRequest(t, authRes, "POST", `{"current_password": "Testpass1", "new_password": "weak"}`, HandlePostMePassword(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+	rec = meRequest(t, authRes, "POST", `{"current_password": "Testpass1", "new_password": "Newpass12"}`, HandlePostMePassword(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	changedAuthRes := schema.AuthResponse{}
+	err := json.NewDecoder(rec.Body).Decode(&changedAuthRes)
+	assert.AssertEq(t, err, nil)
+
+	// Tokens issued before the change are no longer accepted, including
+	// those of the session that changed the password.
+	rec = meRequest(t, authRes, "GET", "", HandleGetMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	rec = meRequest(t, otherAuthRes, "GET", "", HandleGetMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	rec, err = refresh(authRes.RefreshToken)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	rec = meRequest(t, changedAuthRes, "GET", "", HandleGetMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+
+	newAuthRes := login(t, userIn.Email, "Newpass12")
+	assert.AssertNotEq(t, newAuthRes.AccessToken, "")
+}
+
+func TestChangeEmailShouldPass(t *testing.T) {
+	userIn, user := createTestUserWithRole("oldaddress@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	mailer := tMailer
+
+	rec := meRequest(t, authRes, "POST", `{"email": "newaddress@example.com", "password": "Wrongpass1"}`, HandlePostMeEmail(tDB, mailer))
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+	jsonStr := fmt.Sprintf(`{"email": "%s", "password": "Testpass1"}`, testUserIn.Email)
+	rec = meRequest(t, authRes, "POST", jsonStr, HandlePostMeEmail(tDB, mailer))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+	rec = meRequest(t, authRes, "POST", `{"email": "newaddress@example.com", "password": "Testpass1"}`, HandlePostMeEmail(tDB, mailer))
+	assert.AssertEq(t, rec.Code, http.StatusAccepted)
+
+	token := mailer.lastToken(t, "newaddress@example.com")
+	confirm := func() int {
+		rec, c := createContext("POST", "http://localhost:8080/auth/email/confirm", fmt.Sprintf(`{"token": "%s"}`, token))
+		err := HandlePostConfirmEmail(tDB)(c)
+		assert.AssertEq(t, err, nil)
+		return rec.Code
+	}
+	assert.AssertEq(t, confirm(), http.StatusOK)
+	assert.AssertEq(t, confirm(), http.StatusBadRequest)
+
+	err := user.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, user.Email, "newaddress@example.com")
+	newAuthRes := login(t, "newaddress@example.com", userIn.Password)
+	assert.AssertNotEq(t, newAuthRes.AccessToken, "")
+}
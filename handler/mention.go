@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+)
+
+// updateMentions replaces the mentions of the source with the users
+// mentioned in content and returns the users who were added, or none if
+// the mentions could not be updated.
+func updateMentions(db *sql.DB, source model.MentionSource, content string) []int {
+	added, err := source.Update(db, content)
+	if err != nil {
+		log.Println("err updating mentions: ", err)
+	}
+	return added
+}
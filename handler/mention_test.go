@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+)
+
+// createMentionedUser creates a member of the test project with the handle.
+func createMentionedUser(t *testing.T, email, handle string) model.User {
+	userIn, user := createTestUserWithRole(email, "Testpass1", constants.UserRoleID)
+	addTestProjectMember(testProject.ID, user.ID, constants.ProjectContributor)
+	authRes := login(t, userIn.Email, userIn.Password)
+	rec := meRequest(t, authRes, "PATCH", fmt.Sprintf(`{"handle": "%s"}`, handle), HandlePatchMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	err := json.NewDecoder(rec.Body).Decode(&user)
+	assert.AssertEq(t, err, nil)
+	return user
+}
+
+// mentionNotifications returns the mention notifications of the user about
+// the task.
+func mentionNotifications(t *testing.T, userID, taskID int) model.Notifications {
+	notifications := model.Notifications{}
+	err := notifications.List(tDB, model.NotificationFilter{UserID: userID, Limit: 100})
+	assert.AssertEq(t, err, nil)
+	found := model.Notifications{}
+	for _, n := range notifications {
+		if n.Type == model.NotificationMention && n.TaskID == taskID {
+			found = append(found, n)
+		}
+	}
+	return found
+}
+
+func mentioned(t *testing.T, source model.MentionSource) []int {
+	userIDs, err := source.Mentioned(tDB)
+	assert.AssertEq(t, err, nil)
+	return userIDs
+}
+
+func TestTaskContentMentionsShouldNotifyMembers(t *testing.T) {
+	member := createMentionedUser(t, "mentioned@example.com", "Mention.Me")
+	assert.AssertEq(t, member.Handle, "Mention.Me")
+	_, outsider := createTestUserWithRole("outsider@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	content := fmt.Sprintf(
+		"Ping @mention.me, @%s and @nobody. Also me@%s and @%s.",
+		outsider.Email, strings.ToUpper(testUserIn.Email), testUserIn.Email,
+	)
+	rec, c := createContextWithParams(
+		"POST",
+		"http://localhost:8080/project/:projectID/task/create",
+		fmt.Sprintf(`{"assignee_id": %d, "title": "Mentions", "content": "%s"}`, testUser.ID, content),
+		[]string{"projectID"},
+		[]string{fmt.Sprintf("%d", testProject.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	task := model.Task{}
+	err = json.NewDecoder(rec.Body).Decode(&task)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, task.Content, content)
+
+	// Outsiders and unknown handles are left as plain text.
+	source := model.MentionSource{ProjectID: testProject.ID, TaskID: task.ID}
+	assert.AssertEq(t, fmt.Sprint(mentioned(t, source)), fmt.Sprint([]int{testUser.ID, member.ID}))
+	notifications := mentionNotifications(t, member.ID, task.ID)
+	assert.AssertEq(t, len(notifications), 1)
+	assert.AssertEq(t, notifications[0].ActorID, testUser.ID)
+	assert.AssertEq(t, notifications[0].CommentID, 0)
+	assert.AssertEq(t, len(mentionNotifications(t, testUser.ID, task.ID)), 0)
+	assert.AssertEq(t, len(mentionNotifications(t, outsider.ID, task.ID)), 0)
+
+	task.Content = "Nobody to ping"
+	rec, c = createContextWithParams(
+		"PATCH",
+		"http://localhost:8080/project/:projectID/task/:id",
+		fmt.Sprintf(`{"assignee_id": %d, "title": "Mentions", "content": "%s"}`, testUser.ID, task.Content),
+		[]string{"projectID", "id"},
+		[]string{fmt.Sprintf("%d", testProject.ID), fmt.Sprintf("%d", task.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	c.Request().Header.Set("If-Match", "*")
+	err = mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update task")(mw.TaskPolicyRequired(tDB, "update task")(HandlePatchTaskID(tDB))))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	assert.AssertEq(t, len(mentioned(t, source)), 0)
+	assert.AssertEq(t, len(mentionNotifications(t, member.ID, task.ID)), 0)
+}
+
+func TestCommentMentionsShouldFollowEdits(t *testing.T) {
+	member := createMentionedUser(t, "commentmention@example.com", "commenter")
+	task := createTestTask(testUser.ID, testUser.ID, "Mentioned in comments", "Content", constants.Todo)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	code, comment := postComment(t, authRes, task, "@commenter have a look", 0)
+	assert.AssertEq(t, code, http.StatusOK)
+	source := model.MentionSource{ProjectID: testProject.ID, TaskID: task.ID, CommentID: comment.ID}
+	assert.AssertEq(t, fmt.Sprint(mentioned(t, source)), fmt.Sprint([]int{member.ID}))
+	notifications := mentionNotifications(t, member.ID, task.ID)
+	assert.AssertEq(t, len(notifications), 1)
+	assert.AssertEq(t, notifications[0].CommentID, comment.ID)
+
+	// Editing the comment does not notify users who are still mentioned.
+	rec := commentRequest(
+		t, authRes, "PATCH", "/:commentID", `{"content": "@COMMENTER have another look"}`,
+		task, comment.ID, "update task", HandlePatchComment(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	assert.AssertEq(t, len(mentionNotifications(t, member.ID, task.ID)), 1)
+
+	rec = commentRequest(t, authRes, "DELETE", "/:commentID", "", task, comment.ID, "update task", HandleDeleteComment(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	assert.AssertEq(t, len(mentioned(t, source)), 0)
+	assert.AssertEq(t, len(mentionNotifications(t, member.ID, task.ID)), 0)
+}
+
+func TestPatchMeHandleShouldBeValidAndUnique(t *testing.T) {
+	createMentionedUser(t, "handleowner@example.com", "taken")
+	userIn, _ := createTestUserWithRole("handlechooser@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+
+	for _, handle := range []string{"has space", "trailing.", "-leading", "x@example.com", strings.Repeat("x", 33), "TAKEN"} {
+		t.Run(handle, func(t *testing.T) {
+			rec := meRequest(t, authRes, "PATCH", fmt.Sprintf(`{"handle": "%s"}`, handle), HandlePatchMe(tDB))
+			assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+		})
+	}
+
+	rec := meRequest(t, authRes, "PATCH", `{"handle": "@chooser"}`, HandlePatchMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	u := model.User{}
+	err := json.NewDecoder(rec.Body).Decode(&u)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, u.Handle, "chooser")
+
+	rec = meRequest(t, authRes, "PATCH", `{"handle": ""}`, HandlePatchMe(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	u = model.User{}
+	err = json.NewDecoder(rec.Body).Decode(&u)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, u.Handle, "")
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+// notify creates the notifications, if there are any.
+func notify(db *sql.DB, notifications model.Notifications) {
+	if len(notifications) == 0 {
+		return
+	}
+	if err := notifications.Create(db); err != nil {
+		log.Println("err creating notifications: ", err)
+	}
+}
+
+// taskWatchers returns the assignee and the creator of the task, and any
+// other users in also, except the user who acted and the users in except.
+func taskWatchers(task model.Task, actorID int, also []int, except []int) []int {
+	watchers := []int{}
+	for _, userID := range append([]int{task.AssigneeID, task.CreatorID}, also...) {
+		if userID == 0 || userID == actorID || slices.Contains(except, userID) || slices.Contains(watchers, userID) {
+			continue
+		}
+		watchers = append(watchers, userID)
+	}
+	return watchers
+}
+
+// notifyTaskChange notifies users of an assignment and a status change of
+// the task made by the actor. previous is the task before the change, or
+// the zero Task if it was created.
+func notifyTaskChange(db *sql.DB, actorID int, previous, task model.Task) {
+	now := time.Now().Unix()
+	notifications := model.Notifications{}
+	if task.AssigneeID != previous.AssigneeID && task.AssigneeID != actorID {
+		notifications = append(notifications, model.Notification{
+			UserID:    task.AssigneeID,
+			Type:      model.NotificationAssignment,
+			ActorID:   actorID,
+			TaskID:    task.ID,
+			CreatedAt: now,
+		})
+	}
+	if previous.ID != 0 && task.Status != previous.Status {
+		for _, userID := range taskWatchers(task, actorID, nil, nil) {
+			notifications = append(notifications, model.Notification{
+				UserID:    userID,
+				Type:      model.NotificationStatusChange,
+				ActorID:   actorID,
+				TaskID:    task.ID,
+				Details:   fmt.Sprintf("%s -> %s", previous.Status, task.Status),
+				CreatedAt: now,
+			})
+		}
+	}
+	notify(db, notifications)
+}
+
+// notifyComment notifies the watchers of the task and the author of the
+// comment replied to of a new comment. Users in mentioned have already been
+// notified of being mentioned in it.
+func notifyComment(db *sql.DB, task model.Task, comment model.Comment, mentioned []int) {
+	also := []int{}
+	if comment.ParentID != 0 {
+		parent := model.Comment{ID: comment.ParentID, TaskID: task.ID}
+		if err := parent.Read(db); err != nil {
+			log.Println("err reading comment: ", err)
+		}
+		also = append(also, parent.UserID)
+	}
+	notifications := model.Notifications{}
+	for _, userID := range taskWatchers(task, comment.UserID, also, mentioned) {
+		notifications = append(notifications, model.Notification{
+			UserID:    userID,
+			Type:      model.NotificationComment,
+			ActorID:   comment.UserID,
+			TaskID:    task.ID,
+			CommentID: comment.ID,
+			CreatedAt: comment.CreatedAt,
+		})
+	}
+	notify(db, notifications)
+}
+
+// HandleGetNotifications lists the notifications of the user, newest first.
+// Only unread notifications are listed if the "unread" parameter is true.
+func HandleGetNotifications(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		filter := model.NotificationFilter{UserID: user.ID}
+
+		if unread := c.QueryParam("unread"); unread != "" {
+			b, err := strconv.ParseBool(unread)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid unread flag '%s'", unread)})
+			}
+			filter.Unread = b
+		}
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != "-id" {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.BeforeID = lc.ID
+		}
+
+		notifications := model.Notifications{}
+		filter.Limit = limit + 1
+		if err := notifications.List(db, filter); err != nil {
+			log.Println("err listing notifications: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list notifications"})
+		}
+		total, err := notifications.Count(db, filter)
+		if err != nil {
+			log.Println("err counting notifications: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list notifications"})
+		}
+
+		page := schema.Page[model.Notification]{Items: notifications, Total: total}
+		if len(notifications) > limit {
+			page.Items = notifications[:limit]
+			page.NextCursor, err = utils.EncodeCursor(listCursor{Sort: "-id", ID: page.Items[limit-1].ID})
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list notifications"})
+			}
+		}
+		return c.JSON(http.StatusOK, page)
+	})
+}
+
+func HandlePostNotificationRead(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		notificationID := c.Param("id")
+		nID, err := strconv.Atoi(notificationID)
+		if err != nil || nID <= 0 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid notification ID '%s'", notificationID)})
+		}
+
+		notification := model.Notification{ID: nID, UserID: user.ID}
+		if err := notification.MarkRead(db, time.Now().Unix()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("notification '%d' not found", nID)})
+			}
+			log.Println("err marking notification read: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to mark notification read"})
+		}
+		return c.JSON(http.StatusOK, notification)
+	})
+}
+
+// HandlePostNotificationsRead marks all unread notifications of the user as
+// read.
+func HandlePostNotificationsRead(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		n, err := model.MarkAllNotificationsRead(db, user.ID, time.Now().Unix())
+		if err != nil {
+			log.Println("err marking notifications read: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to mark notifications read"})
+		}
+		return c.JSON(http.StatusOK, schema.MessageResponse{Message: fmt.Sprintf("marked %d notifications read", n)})
+	})
+}
+
+func HandleGetNotificationPreferences(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		preferences := model.NotificationPreferences{}
+		if err := preferences.ReadByUser(db, user.ID); err != nil {
+			log.Println("err reading notification preferences: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read notification preferences"})
+		}
+		return c.JSON(http.StatusOK, preferences)
+	})
+}
+
+// HandlePatchNotificationPreferences turns notification types on or off
+// for the user. Types missing from the body keep their earlier preference.
+func HandlePatchNotificationPreferences(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		preferencesIn := schema.NotificationPreferencesIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&preferencesIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		for t := range preferencesIn {
+			if !model.ValidNotificationType(t) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid notification type '%s'", t)})
+			}
+		}
+
+		if err := model.NotificationPreferences(preferencesIn).Update(db, user.ID); err != nil {
+			log.Println("err updating notification preferences: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update notification preferences"})
+		}
+		preferences := model.NotificationPreferences{}
+		if err := preferences.ReadByUser(db, user.ID); err != nil {
+			log.Println("err reading notification preferences: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read notification preferences"})
+		}
+		return c.JSON(http.StatusOK, preferences)
+	})
+}
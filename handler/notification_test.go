@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/reminder"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func notificationRequest(t *testing.T, authRes schema.AuthResponse, method, path, jsonStr string, names, values []string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	return authRequest(t, authRes, method, "http://localhost:8080/me/notifications"+path, jsonStr, names, values, h)
+}
+
+func listNotifications(t *testing.T, authRes schema.AuthResponse, query string) schema.Page[model.Notification] {
+	rec := notificationRequest(t, authRes, "GET", "?"+query, "", nil, nil, HandleGetNotifications(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	page := schema.Page[model.Notification]{}
+	err := json.NewDecoder(rec.Body).Decode(&page)
+	assert.AssertEq(t, err, nil)
+	return page
+}
+
+func patchNotificationPreferences(t *testing.T, authRes schema.AuthResponse, jsonStr string) (int, model.NotificationPreferences) {
+	rec := notificationRequest(t, authRes, "PATCH", "/preferences", jsonStr, nil, nil, HandlePatchNotificationPreferences(tDB))
+	preferences := model.NotificationPreferences{}
+	if rec.Code == http.StatusOK {
+		err := json.NewDecoder(rec.Body).Decode(&preferences)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, preferences
+}
+
+func TestTaskEventsShouldNotifyUsers(t *testing.T) {
+	userIn, user := createTestUserWithRole("inbox@example.com", "Testpass1", constants.UserRoleID)
+	addTestProjectMember(testProject.ID, user.ID, constants.ProjectContributor)
+	userAuth := login(t, userIn.Email, userIn.Password)
+	managerAuth := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	rec, c := createContextWithParams(
+		"POST",
+		"http://localhost:8080/project/:projectID/task/create",
+		fmt.Sprintf(`{"assignee_id": %d, "title": "Inbox", "content": "Content"}`, user.ID),
+		[]string{"projectID"},
+		[]string{fmt.Sprintf("%d", testProject.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", managerAuth.TokenType, managerAuth.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	task := model.Task{}
+	err = json.NewDecoder(rec.Body).Decode(&task)
+	assert.AssertEq(t, err, nil)
+
+	code, _ := transitionTask(t, managerAuth, task, "doing")
+	assert.AssertEq(t, code, http.StatusOK)
+	code, comment := postComment(t, userAuth, task, "Started", 0)
+	assert.AssertEq(t, code, http.StatusOK)
+	// Being mentioned in a reply is notified once, as a mention.
+	code, reply := postComment(t, managerAuth, task, "@inbox@example.com thanks", comment.ID)
+	assert.AssertEq(t, code, http.StatusOK)
+
+	page := listNotifications(t, userAuth, "limit=2")
+	assert.AssertEq(t, page.Total, 3)
+	assert.AssertEq(t, len(page.Items), 2)
+	assert.AssertEq(t, page.Items[0].Type, model.NotificationMention)
+	assert.AssertEq(t, page.Items[0].CommentID, reply.ID)
+	assert.AssertEq(t, page.Items[1].Type, model.NotificationStatusChange)
+	assert.AssertEq(t, page.Items[1].Details, "todo -> doing")
+	assert.AssertEq(t, page.Items[1].ActorID, testProjectManager.ID)
+	page = listNotifications(t, userAuth, "limit=2&cursor="+page.NextCursor)
+	assert.AssertEq(t, len(page.Items), 1)
+	assert.AssertEq(t, page.Items[0].Type, model.NotificationAssignment)
+	assert.AssertEq(t, page.Items[0].TaskID, task.ID)
+	assert.AssertEq(t, page.NextCursor, "")
+
+	// The creator of the task hears of the comment but not of their own
+	// actions.
+	page = listNotifications(t, managerAuth, "unread=true&limit=100")
+	found := model.Notifications{}
+	for _, n := range page.Items {
+		if n.TaskID == task.ID {
+			found = append(found, n)
+		}
+	}
+	assert.AssertEq(t, len(found), 1)
+	assert.AssertEq(t, found[0].Type, model.NotificationComment)
+	assert.AssertEq(t, found[0].CommentID, comment.ID)
+	assert.AssertEq(t, found[0].ActorID, user.ID)
+}
+
+func TestMarkNotificationsReadShouldPass(t *testing.T) {
+	userIn, user := createTestUserWithRole("reader@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	otherAuth := login(t, testUserIn.Email, testUserIn.Password)
+	notifications := model.Notifications{}
+	for i := 0; i < 3; i++ {
+		notifications = append(notifications, model.Notification{
+			UserID: user.ID, Type: model.NotificationAssignment, TaskID: testTask.ID, CreatedAt: time.Now().Unix(),
+		})
+	}
+	err := notifications.Create(tDB)
+	assert.AssertEq(t, err, nil)
+
+	markRead := func(authRes schema.AuthResponse, id int) *httptest.ResponseRecorder {
+		return notificationRequest(
+			t, authRes, "POST", "/:id/read", "", []string{"id"}, []string{fmt.Sprintf("%d", id)}, HandlePostNotificationRead(tDB),
+		)
+	}
+	assert.AssertEq(t, markRead(otherAuth, notifications[0].ID).Code, http.StatusNotFound)
+	rec := markRead(authRes, notifications[0].ID)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	n := model.Notification{}
+	err = json.NewDecoder(rec.Body).Decode(&n)
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, n.ReadAt, int64(0))
+
+	page := listNotifications(t, authRes, "unread=true")
+	assert.AssertEq(t, page.Total, 2)
+	rec = notificationRequest(t, authRes, "POST", "/read", "", nil, nil, HandlePostNotificationsRead(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	res := schema.MessageResponse{}
+	err = json.NewDecoder(rec.Body).Decode(&res)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, res.Message, "marked 2 notifications read")
+	assert.AssertEq(t, listNotifications(t, authRes, "unread=true").Total, 0)
+	assert.AssertEq(t, listNotifications(t, authRes, "").Total, 3)
+
+	rec = notificationRequest(t, authRes, "GET", "?unread=maybe", "", nil, nil, HandleGetNotifications(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestNotificationPreferencesShouldFilterNotifications(t *testing.T) {
+	userIn, user := createTestUserWithRole("quiet@example.com", "Testpass1", constants.UserRoleID)
+	addTestProjectMember(testProject.ID, user.ID, constants.ProjectContributor)
+	authRes := login(t, userIn.Email, userIn.Password)
+	managerAuth := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	rec := notificationRequest(t, authRes, "GET", "/preferences", "", nil, nil, HandleGetNotificationPreferences(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	preferences := model.NotificationPreferences{}
+	err := json.NewDecoder(rec.Body).Decode(&preferences)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(preferences), len(model.NotificationTypes))
+	assert.AssertEq(t, preferences[model.NotificationComment], true)
+
+	code, _ := patchNotificationPreferences(t, authRes, `{"comment": true, "fan_mail": false}`)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+	code, preferences = patchNotificationPreferences(t, authRes, `{"comment": false, "due_reminder": false}`)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, preferences[model.NotificationComment], false)
+	assert.AssertEq(t, preferences[model.NotificationDueReminder], false)
+	assert.AssertEq(t, preferences[model.NotificationMention], true)
+
+	task := createTestTask(user.ID, user.ID, "Quiet task", "Content", constants.Todo)
+	code, _ = postComment(t, managerAuth, task, "Nobody hears this", 0)
+	assert.AssertEq(t, code, http.StatusOK)
+	code, _ = postComment(t, managerAuth, task, "But @quiet@example.com hears this", 0)
+	assert.AssertEq(t, code, http.StatusOK)
+	page := listNotifications(t, authRes, "")
+	assert.AssertEq(t, page.Total, 1)
+	assert.AssertEq(t, page.Items[0].Type, model.NotificationMention)
+
+	code, _ = patchNotificationPreferences(t, authRes, `{"comment": true}`)
+	assert.AssertEq(t, code, http.StatusOK)
+	code, _ = postComment(t, managerAuth, task, "Heard again", 0)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, listNotifications(t, authRes, "").Total, 2)
+}
+
+func TestDueRemindersShouldBeSentOncePerDueTime(t *testing.T) {
+	userIn, user := createTestUserWithRole("reminded@example.com", "Testpass1", constants.UserRoleID)
+	user.Timezone = "Europe/Helsinki"
+	err := user.UpdateProfile(tDB)
+	assert.AssertEq(t, err, nil)
+	authRes := login(t, userIn.Email, userIn.Password)
+	now := time.Now()
+	createDueTask := func(title string, dueAt time.Time) model.Task {
+		task := model.Task{
+			ProjectID:  testProject.ID,
+			AssigneeID: user.ID,
+			CreatorID:  testProjectManager.ID,
+			Title:      title,
+			Content:    "Content",
+			Status:     constants.Todo,
+			DueAt:      dueAt.Unix(),
+		}
+		err := task.Create(tDB)
+		assert.AssertEq(t, err, nil)
+		return task
+	}
+	dueSoon := createDueTask("Due soon", now.Add(time.Hour))
+	createDueTask("Due later", now.Add(72*time.Hour))
+
+	remind := func() {
+		_, err := reminder.Remind(tDB, now)
+		assert.AssertEq(t, err, nil)
+	}
+	remind()
+	remind()
+	page := listNotifications(t, authRes, "")
+	assert.AssertEq(t, page.Total, 1)
+	assert.AssertEq(t, page.Items[0].Type, model.NotificationDueReminder)
+	assert.AssertEq(t, page.Items[0].TaskID, dueSoon.ID)
+	assert.AssertEq(t, page.Items[0].DueAt, dueSoon.DueAt)
+	assert.AssertEq(t, page.Items[0].Details, "due "+user.FormatTime(time.Unix(dueSoon.DueAt, 0)))
+	assert.AssertNotEq(t, user.FormatTime(time.Unix(dueSoon.DueAt, 0)), model.User{}.FormatTime(time.Unix(dueSoon.DueAt, 0)))
+
+	// Postponing the task reminds of it again once it is due soon.
+	dueSoon.DueAt = now.Add(2 * time.Hour).Unix()
+	dueSoon.Version = 0
+	err = dueSoon.Update(tDB)
+	assert.AssertEq(t, err, nil)
+	remind()
+	assert.AssertEq(t, listNotifications(t, authRes, "").Total, 2)
+
+	// Done tasks are not reminded of.
+	done := createDueTask("Done", now.Add(time.Hour))
+	done.Status = constants.Done
+	err = done.Update(tDB)
+	assert.AssertEq(t, err, nil)
+	remind()
+	assert.AssertEq(t, listNotifications(t, authRes, "").Total, 2)
+}
@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/oidc"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+// oidcStateCookie ties the callback to the browser that started the login,
+// so that a user cannot be logged in to an account of someone else's
+// choosing.
+const oidcStateCookie = "oidc_state"
+
+func oidcCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   config.ENV == "prod",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// HandleGetOIDCLogin sends the user to log in at the identity provider.
+func HandleGetOIDCLogin(db *sql.DB, provider *oidc.Provider) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		state, err := utils.GenerateToken(32)
+		if err != nil {
+			log.Println("err generating state: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to start login"})
+		}
+		nonce, err := utils.GenerateToken(32)
+		if err != nil {
+			log.Println("err generating nonce: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to start login"})
+		}
+		verifier, err := oidc.GenerateVerifier()
+		if err != nil {
+			log.Println("err generating code verifier: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to start login"})
+		}
+
+		authURL, err := provider.AuthCodeURL(c.Request().Context(), state, nonce, verifier)
+		if err != nil {
+			log.Println("err discovering identity provider: ", err)
+			return c.JSON(http.StatusBadGateway, schema.MessageResponse{Message: "Identity provider is unavailable"})
+		}
+		now := time.Now()
+		login := model.OIDCLogin{
+			StateHash:    utils.HashToken(state),
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			CreatedAt:    now.Unix(),
+			ExpiresAt:    now.Add(config.OIDC_LOGIN_TTL).Unix(),
+		}
+		if err := login.Create(db); err != nil {
+			log.Println("err creating oidc login: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to start login"})
+		}
+
+		c.SetCookie(oidcCookie(state, int(config.OIDC_LOGIN_TTL.Seconds())))
+		return c.Redirect(http.StatusFound, authURL)
+	})
+}
+
+// HandleGetOIDCCallback completes a login at the identity provider. The
+// user is found by their identity at the provider, or else by their email
+// address, which the provider must have verified. Users who have not
+// verified the address themselves are refused with 409. Unknown users are
+// created. If the provider is configured with group roles, the user's role
+// follows their groups.
+func HandleGetOIDCCallback(db *sql.DB, provider *oidc.Provider) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		if e := c.QueryParam("error"); e != "" {
+			return c.JSON(
+				http.StatusUnauthorized,
+				schema.MessageResponse{Message: fmt.Sprintf("Identity provider refused the login: %s", e)})
+		}
+
+		state := c.QueryParam("state")
+		cookie, err := c.Cookie(oidcStateCookie)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "Invalid login state"})
+		}
+		c.SetCookie(oidcCookie("", -1))
+
+		now := time.Now()
+		login := model.OIDCLogin{StateHash: utils.HashToken(state)}
+		if err := login.Consume(db, now.Unix()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "Invalid login state"})
+			}
+			log.Println("err consuming oidc login: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to log in"})
+		}
+
+		ctx := c.Request().Context()
+		rawIDToken, err := provider.Exchange(ctx, c.QueryParam("code"), login.CodeVerifier)
+		if err != nil {
+			log.Println("err exchanging authorization code: ", err)
+			return c.JSON(http.StatusUnauthorized, schema.MessageResponse{Message: "Unable to complete login at the identity provider"})
+		}
+		claims, err := provider.Verify(ctx, rawIDToken, login.Nonce)
+		if err != nil {
+			log.Println("err verifying id token: ", err)
+			return c.JSON(http.StatusUnauthorized, schema.MessageResponse{Message: "Invalid ID token"})
+		}
+		claims.Email = strings.TrimSpace(claims.Email)
+		if !claims.EmailVerified || !emailIsValid(claims.Email) {
+			return c.JSON(
+				http.StatusForbidden,
+				schema.MessageResponse{Message: "Identity provider has not verified the email address"})
+		}
+
+		user, err := oidcUser(db, provider.Config, claims, now)
+		if errors.Is(err, errOIDCUnverifiedUser) {
+			return c.JSON(
+				http.StatusConflict,
+				schema.MessageResponse{Message: "An account with this email address has not been verified, verify it before logging in with the identity provider"})
+		}
+		if err != nil {
+			log.Println("err provisioning oidc user: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to log in"})
+		}
+		if !user.Active() {
+			return c.JSON(
+				http.StatusForbidden,
+				schema.MessageResponse{Message: "User has been deactivated"},
+			)
+		}
+		if err := syncOIDCRole(db, provider.Config, &user, claims.Groups); err != nil {
+			log.Println("err updating role from groups: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "Unable to log in"})
+		}
+
+		if user.TwoFactorEnabled() {
+			return startTwoFactorChallenge(c, user, now)
+		}
+		return startSession(c, db, user)
+	})
+}
+
+// errOIDCUnverifiedUser is returned by oidcUser if the email address of an
+// unlinked identity belongs to a user who has not verified it.
+var errOIDCUnverifiedUser = errors.New("user with the email address has not verified it")
+
+// oidcUser returns the user linked to the identity in claims, linking or
+// creating one by email address if there is none. Users who have not
+// verified their email address are not linked, as anyone may have
+// registered the address with a password of their own.
+func oidcUser(db *sql.DB, cfg oidc.Config, claims oidc.Claims, now time.Time) (model.User, error) {
+	identity := model.UserIdentity{Issuer: claims.Issuer, Subject: claims.Subject}
+	err := identity.Read(db)
+	if err == nil {
+		if err := identity.UpdateLastLogin(db, now.Unix()); err != nil {
+			return model.User{}, err
+		}
+		user := model.User{ID: identity.UserID}
+		return user, user.ReadByID(db)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, err
+	}
+
+	user := model.User{Email: claims.Email}
+	if err := user.ReadByEmail(db); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, err
+		}
+		user, err = createOIDCUser(db, cfg, claims)
+		if err != nil {
+			return model.User{}, err
+		}
+	} else if !user.EmailVerified() {
+		return model.User{}, errOIDCUnverifiedUser
+	}
+
+	identity.UserID = user.ID
+	identity.CreatedAt = now.Unix()
+	if err := identity.Create(db); err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// createOIDCUser creates a user without a password. They can only log in
+// through the identity provider unless they reset their password.
+func createOIDCUser(db *sql.DB, cfg oidc.Config, claims oidc.Claims) (model.User, error) {
+	roleID, ok := cfg.RoleFor(claims.Groups)
+	if !ok {
+		roleID = constants.UserRoleID
+	}
+	user := model.User{Email: claims.Email, RoleID: roleID}
+	if err := user.Create(db); err != nil {
+		return model.User{}, err
+	}
+	if err := user.SetEmailVerified(db); err != nil {
+		return model.User{}, err
+	}
+	if name := strings.TrimSpace(claims.Name); name != "" {
+		user.DisplayName = name
+		user.Timezone = "UTC"
+		user.Locale = "en"
+		if err := user.UpdateProfile(db); err != nil {
+			return model.User{}, err
+		}
+	}
+	return user, user.ReadByID(db)
+}
+
+// syncOIDCRole gives user the role of their groups if cfg maps groups to
+// roles, falling back to the user role if none of their groups is mapped.
+// The last admin is never demoted.
+func syncOIDCRole(db *sql.DB, cfg oidc.Config, user *model.User, groups []string) error {
+	if len(cfg.GroupRoles) == 0 {
+		return nil
+	}
+	roleID, ok := cfg.RoleFor(groups)
+	if !ok {
+		roleID = constants.UserRoleID
+	}
+	if roleID == user.RoleID {
+		return nil
+	}
+
+	current := model.Role{ID: user.RoleID}
+	if err := current.ReadByID(db); err != nil {
+		return err
+	}
+	role := model.Role{ID: roleID}
+	if err := role.ReadByID(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("group role '%d' of user '%s' does not exist\n", roleID, user.Email)
+			return nil
+		}
+		return err
+	}
+	if current.Permissions.Has("all") && !role.Permissions.Has("all") {
+		last, err := removesLastAdmin(db, 1)
+		if err != nil {
+			return err
+		}
+		if last {
+			log.Printf("not demoting '%s', the last admin, to role '%d'\n", user.Email, roleID)
+			return nil
+		}
+	}
+
+	user.RoleID = roleID
+	if err := user.UpdateRole(db); err != nil {
+		return err
+	}
+	mw.ForgetUser(user.ID)
+	return nil
+}
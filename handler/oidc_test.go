@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/oidc"
+	"github.com/tomihaapalainen/go-task-mgmt/oidc/oidctest"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func newTestOIDC(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	idp, err := oidctest.NewServer("go-task-mgmt", "secret")
+	assert.AssertEq(t, err, nil)
+	t.Cleanup(idp.Close)
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     "go-task-mgmt",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+		GroupsClaim:  "groups",
+		GroupRoles:   []oidc.GroupRole{{Group: "managers", RoleID: constants.ProjectManagerRoleID}},
+	})
+	return idp, provider
+}
+
+// startOIDCLogin starts a login and returns the URL of the identity
+// provider and the state cookie.
+func startOIDCLogin(t *testing.T, provider *oidc.Provider) (string, *http.Cookie) {
+	rec, c := createContext("GET", "http://localhost:8080/auth/oidc/login", "")
+	err := HandleGetOIDCLogin(tDB, provider)(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusFound)
+	cookies := rec.Result().Cookies()
+	assert.AssertEq(t, len(cookies), 1)
+	return rec.Header().Get("Location"), cookies[0]
+}
+
+func oidcCallback(t *testing.T, provider *oidc.Provider, redirect string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	u, err := url.Parse(redirect)
+	assert.AssertEq(t, err, nil)
+	rec, c := createContext("GET", "http://localhost:8080/auth/oidc/callback?"+u.RawQuery, "")
+	c.Request().AddCookie(cookie)
+	err = HandleGetOIDCCallback(tDB, provider)(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func oidcLogin(t *testing.T, idp *oidctest.Server, provider *oidc.Provider, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	authURL, cookie := startOIDCLogin(t, provider)
+	redirect, err := idp.Authorize(authURL, claims)
+	assert.AssertEq(t, err, nil)
+	return oidcCallback(t, provider, redirect, cookie)
+}
+
+func TestOIDCLoginShouldProvisionUser(t *testing.T) {
+	idp, provider := newTestOIDC(t)
+	claims := jwt.MapClaims{
+		"sub":            "sso-new",
+		"email":          "sso-new@example.com",
+		"email_verified": true,
+		"name":           "SSO User",
+		"groups":         []string{"staff", "managers"},
+	}
+	rec := oidcLogin(t, idp, provider, claims)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	res := schema.AuthResponse{}
+	err := json.NewDecoder(rec.Body).Decode(&res)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, getProjectsAs(t, res), http.StatusOK)
+
+	user := model.User{Email: "sso-new@example.com"}
+	err = user.ReadByEmail(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, user.RoleID, constants.ProjectManagerRoleID)
+	assert.AssertEq(t, user.DisplayName, "SSO User")
+	assert.AssertEq(t, user.EmailVerified(), true)
+
+	// Leaving the mapped group at the provider takes the role away.
+	claims["groups"] = []string{"staff"}
+	rec = oidcLogin(t, idp, provider, claims)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	linked := model.User{Email: user.Email}
+	err = linked.ReadByEmail(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, linked.ID, user.ID)
+	assert.AssertEq(t, linked.RoleID, constants.UserRoleID)
+}
+
+func TestOIDCLoginShouldLinkUserByEmail(t *testing.T) {
+	idp, provider := newTestOIDC(t)
+	_, user := createTestUserWithRole("sso-link@example.com", "Testpass1", constants.UserRoleID)
+
+	rec := oidcLogin(t, idp, provider, jwt.MapClaims{
+		"sub":            "sso-link",
+		"email":          user.Email,
+		"email_verified": true,
+	})
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	identity := model.UserIdentity{Issuer: idp.URL, Subject: "sso-link"}
+	err := identity.Read(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, identity.UserID, user.ID)
+}
+
+func TestOIDCLoginShouldNotLinkUnverifiedUser(t *testing.T) {
+	idp, provider := newTestOIDC(t)
+	user := model.User{Email: "sso-unverified-local@example.com", RoleID: constants.UserRoleID, PasswordHash: "hash"}
+	err := user.Create(tDB)
+	assert.AssertEq(t, err, nil)
+
+	rec := oidcLogin(t, idp, provider, jwt.MapClaims{
+		"sub":            "sso-unverified-local",
+		"email":          user.Email,
+		"email_verified": true,
+	})
+	assert.AssertEq(t, rec.Code, http.StatusConflict)
+	identity := model.UserIdentity{Issuer: idp.URL, Subject: "sso-unverified-local"}
+	err = identity.Read(tDB)
+	assert.AssertEq(t, err, sql.ErrNoRows)
+	err = user.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, user.EmailVerified(), false)
+}
+
+func TestOIDCLoginWithUnverifiedEmailShouldFail(t *testing.T) {
+	idp, provider := newTestOIDC(t)
+	rec := oidcLogin(t, idp, provider, jwt.MapClaims{
+		"sub":            "sso-unverified",
+		"email":          testUserIn.Email,
+		"email_verified": false,
+	})
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+}
+
+func TestOIDCCallbackWithInvalidStateShouldFail(t *testing.T) {
+	idp, provider := newTestOIDC(t)
+	claims := jwt.MapClaims{"sub": "sso-state", "email": "sso-state@example.com", "email_verified": true}
+
+	// A callback from another browser's login is refused.
+	authURL, _ := startOIDCLogin(t, provider)
+	_, otherCookie := startOIDCLogin(t, provider)
+	redirect, err := idp.Authorize(authURL, claims)
+	assert.AssertEq(t, err, nil)
+	rec := oidcCallback(t, provider, redirect, otherCookie)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	// A state can only be used once.
+	authURL, cookie := startOIDCLogin(t, provider)
+	redirect, err = idp.Authorize(authURL, claims)
+	assert.AssertEq(t, err, nil)
+	rec = oidcCallback(t, provider, redirect, cookie)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	rec = oidcCallback(t, provider, redirect, cookie)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,9 @@
+package handler
+
+// listCursor is the position after the last item of a page. Sort is stored
+// so that a cursor cannot be reused with a different ordering.
+type listCursor struct {
+	Sort  string `json:"s"`
+	ID    int    `json:"id"`
+	Value string `json:"v,omitempty"`
+}
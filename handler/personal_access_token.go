@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+// tokenScopes resolves scope names to permissions. Every scope must be a
+// permission the user's role has. ok is false if a response was written.
+func tokenScopes(c echo.Context, db *sql.DB, user model.User, scopes []string) (model.Permissions, bool, error) {
+	if len(scopes) == 0 {
+		return nil, false, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "scopes must not be empty"})
+	}
+	all := model.Permissions{}
+	if err := all.ReadAll(db); err != nil {
+		log.Println("err reading permissions: ", err)
+		return nil, false, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read permissions"})
+	}
+	granted := model.Permissions{}
+	if err := granted.ReadRolePermissions(db, user.RoleID); err != nil {
+		log.Println("err reading role permissions: ", err)
+		return nil, false, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read role permissions"})
+	}
+
+	permissions := model.Permissions{}
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		i := 0
+		for i < len(all) && all[i].Name != scope {
+			i++
+		}
+		if i == len(all) {
+			return nil, false, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("unknown scope '%s'", scope)})
+		}
+		if !granted.Has(scope) {
+			return nil, false, c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{Message: fmt.Sprintf("role of user '%s' does not have permission '%s'", user.Email, scope)})
+		}
+		permissions = append(permissions, all[i])
+	}
+	return permissions, true, nil
+}
+
+// HandlePostPersonalAccessToken creates a personal access token for the
+// user. The token is only shown in this response.
+func HandlePostPersonalAccessToken(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		tokenIn := schema.PersonalAccessTokenIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&tokenIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		tokenIn.Name = strings.TrimSpace(tokenIn.Name)
+		if tokenIn.Name == "" || utf8.RuneCountInString(tokenIn.Name) > 100 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "token name must be 1-100 characters long"})
+		}
+		ttl := config.PERSONAL_ACCESS_TOKEN_TTL
+		if tokenIn.ExpiresInDays != 0 {
+			ttl = time.Duration(tokenIn.ExpiresInDays) * 24 * time.Hour
+		}
+		if ttl <= 0 || ttl > config.PERSONAL_ACCESS_TOKEN_MAX_TTL {
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{Message: fmt.Sprintf(
+					"expires_in_days must be between 1 and %d", int(config.PERSONAL_ACCESS_TOKEN_MAX_TTL.Hours()/24))})
+		}
+		permissions, ok, err := tokenScopes(c, db, user, tokenIn.Scopes)
+		if !ok {
+			return err
+		}
+
+		token, err := utils.GeneratePersonalAccessToken()
+		if err != nil {
+			log.Println("err generating personal access token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to create personal access token"})
+		}
+		now := time.Now()
+		pat := model.PersonalAccessToken{
+			UserID:    user.ID,
+			Name:      tokenIn.Name,
+			TokenHash: utils.HashToken(token),
+			CreatedAt: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		}
+		if err := pat.Create(db, permissions); err != nil {
+			log.Println("err creating personal access token: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+				return c.JSON(
+					http.StatusBadRequest,
+					schema.MessageResponse{Message: fmt.Sprintf("personal access token '%s' already exists", pat.Name)})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to create personal access token"})
+		}
+		return c.JSON(http.StatusOK, schema.NewPersonalAccessToken{
+			ID:        pat.ID,
+			Name:      pat.Name,
+			Scopes:    pat.Scopes,
+			CreatedAt: pat.CreatedAt,
+			ExpiresAt: pat.ExpiresAt,
+			Token:     token,
+		})
+	})
+}
+
+func HandleGetPersonalAccessTokens(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		tokens := model.PersonalAccessTokens{}
+		if err := tokens.ReadByUser(db, user.ID); err != nil {
+			log.Println("err reading personal access tokens: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read personal access tokens"})
+		}
+		return c.JSON(http.StatusOK, tokens)
+	})
+}
+
+func HandleDeletePersonalAccessToken(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		tokenID := c.Param("id")
+		tID, err := strconv.Atoi(tokenID)
+		if err != nil || tID <= 0 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid token ID '%s'", tokenID)})
+		}
+
+		pat := model.PersonalAccessToken{ID: tID, UserID: user.ID}
+		if err := pat.Delete(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("personal access token '%d' not found", tID)})
+			}
+			log.Println("err deleting personal access token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to revoke personal access token"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+func createPersonalAccessToken(t *testing.T, authRes schema.AuthResponse, jsonStr string) schema.NewPersonalAccessToken {
+	rec := meRequest(t, authRes, "POST", jsonStr, HandlePostPersonalAccessToken(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	pat := schema.NewPersonalAccessToken{}
+	err := json.NewDecoder(rec.Body).Decode(&pat)
+	assert.AssertEq(t, err, nil)
+	return pat
+}
+
+func bearer(token string) schema.AuthResponse {
+	return schema.AuthResponse{TokenType: "Bearer", AccessToken: token}
+}
+
+func TestPersonalAccessTokenShouldPass(t *testing.T) {
+	userIn, user := createTestUserWithRole("pat@example.com", "Testpass1", constants.ProjectManagerRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+
+	pat := createPersonalAccessToken(t, authRes, `{"name": "ci", "scopes": ["read project"], "expires_in_days": 7}`)
+	if !strings.HasPrefix(pat.Token, utils.PersonalAccessTokenPrefix) {
+		t.Fatalf("unexpected token '%s'", pat.Token)
+	}
+	assert.AssertEq(t, len(pat.Scopes), 1)
+	assert.AssertEq(t, pat.ExpiresAt-pat.CreatedAt, int64(7*24*time.Hour/time.Second))
+
+	assert.AssertEq(t, getProjectsAs(t, bearer(pat.Token)), http.StatusOK)
+
+	// The role allows creating projects but the token's scopes do not.
+	jsonStr := fmt.Sprintf(`{"user_id": %d, "name": "Token project", "description": ""}`, user.ID)
+	rec, c := createContext("POST", "http://localhost:8080/project/create", jsonStr)
+	c.Request().Header.Set("Authorization", "Bearer "+pat.Token)
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "create project")(HandlePostCreateProject(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+
+	rec = meRequest(t, authRes, "GET", "", HandleGetPersonalAccessTokens(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	tokens := model.PersonalAccessTokens{}
+	err = json.NewDecoder(rec.Body).Decode(&tokens)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(tokens), 1)
+	assert.AssertEq(t, tokens[0].Name, "ci")
+	assert.AssertEq(t, tokens[0].Scopes[0], "read project")
+	if tokens[0].LastUsedAt == 0 {
+		t.Fatal("expected last use to be recorded")
+	}
+
+	id := fmt.Sprintf("%d", pat.ID)
+	rec, c = createContextWithParams("DELETE", "http://localhost:8080/me/tokens/:id", "", []string{"id"}, []string{id})
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(HandleDeletePersonalAccessToken(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	assert.AssertEq(t, getProjectsAs(t, bearer(pat.Token)), http.StatusUnauthorized)
+}
+
+func TestCreatePersonalAccessTokenWithInvalidDataShouldFail(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	createPersonalAccessToken(t, authRes, `{"name": "duplicate", "scopes": ["read task"]}`)
+
+	for _, jsonStr := range []string{
+		`{"name": "", "scopes": ["read task"]}`,
+		`{"name": "no scopes", "scopes": []}`,
+		`{"name": "unknown", "scopes": ["read everything"]}`,
+		`{"name": "escalate", "scopes": ["manage users"]}`,
+		`{"name": "forever", "scopes": ["read task"], "expires_in_days": 1000}`,
+		`{"name": "duplicate", "scopes": ["read task"]}`,
+	} {
+		rec := meRequest(t, authRes, "POST", jsonStr, HandlePostPersonalAccessToken(tDB))
+		assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPersonalAccessTokenShouldNotManageAccount(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	pat := createPersonalAccessToken(t, authRes, `{"name": "account", "scopes": ["read task"]}`)
+
+	rec, c := createContext("GET", "http://localhost:8080/me", "")
+	c.Request().Header.Set("Authorization", "Bearer "+pat.Token)
+	err := mw.JwtMiddleware(tDB)(mw.SessionRequired(HandleGetMe(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+}
+
+func TestExpiredPersonalAccessTokenShouldFail(t *testing.T) {
+	token, err := utils.GeneratePersonalAccessToken()
+	assert.AssertEq(t, err, nil)
+	now := time.Now()
+	pat := model.PersonalAccessToken{
+		UserID:    testUser.ID,
+		Name:      "expired",
+		TokenHash: utils.HashToken(token),
+		CreatedAt: now.Add(-48 * time.Hour).Unix(),
+		ExpiresAt: now.Add(-24 * time.Hour).Unix(),
+	}
+	err = pat.Create(tDB, model.Permissions{{ID: int(constants.ReadProject), Name: "read project"}})
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, getProjectsAs(t, bearer(token)), http.StatusUnauthorized)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
 	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
 )
 
 func HandlePostCreateProject(db *sql.DB) echo.HandlerFunc {
@@ -43,6 +44,7 @@ func HandlePostCreateProject(db *sql.DB) echo.HandlerFunc {
 			return errors.New("unable to create project")
 		}
 
+		setETag(c, project.Version)
 		return c.JSON(http.StatusOK, project)
 	})
 }
@@ -61,6 +63,7 @@ func HandleGetProjectID(db *sql.DB) echo.HandlerFunc {
 			return errors.New("unable to read project")
 		}
 
+		setETag(c, project.Version)
 		return c.JSON(
 			http.StatusOK,
 			project,
@@ -75,22 +78,53 @@ func HandlePatchProjectID(db *sql.DB) echo.HandlerFunc {
 		if err != nil || pID <= 0 {
 			return fmt.Errorf("invalid project ID '%s'", projectID)
 		}
+		version, ok, err := ifMatchVersion(c)
+		if !ok {
+			return err
+		}
 
-		project := model.Project{}
-		if err := json.NewDecoder(c.Request().Body).Decode(&project); err != nil {
+		projectIn := schema.ProjectPatchIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&projectIn); err != nil {
 			log.Println("err decoding json: ", err)
 			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
 		}
-
-		if project.Name == "" {
+		if projectIn.Name != nil && *projectIn.Name == "" {
 			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "project name must not be empty"})
 		}
-		project.ID = pID
+
+		project := model.Project{ID: pID}
+		if err := project.ReadByID(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("project '%d' not found", pID)})
+			}
+			log.Println("err reading project by ID: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update project"})
+		}
+		if projectIn.Name != nil {
+			project.Name = *projectIn.Name
+		}
+		if projectIn.Description != nil {
+			project.Description = *projectIn.Description
+		}
+		if projectIn.Archived != nil {
+			project.Archived = *projectIn.Archived
+		}
+		project.Version = version
 		if err := project.Update(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// The project was either deleted or modified since it was read.
+				current := model.Project{ID: pID}
+				if err := current.ReadByID(db); errors.Is(err, sql.ErrNoRows) {
+					return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("project '%d' not found", pID)})
+				}
+				return c.JSON(http.StatusPreconditionFailed, schema.MessageResponse{Message: "project has been modified, read it again before updating"})
+			}
 			log.Println("err updating project: ", err)
 			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update project"})
 		}
 
+		enqueueWebhookEvent(db, pID, model.WebhookProjectUpdated, project)
+		setETag(c, project.Version)
 		return c.JSON(http.StatusOK, project)
 	})
 }
@@ -117,3 +151,87 @@ func HandleDeleteProject(db *sql.DB) echo.HandlerFunc {
 		)
 	})
 }
+
+func HandleGetProjects(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		filter := model.ProjectFilter{Sort: c.QueryParam("sort")}
+		if filter.Sort == "" {
+			filter.Sort = "id"
+		}
+		if !model.ValidProjectSort(filter.Sort) {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid sort '%s'", filter.Sort)})
+		}
+
+		if ownerID := c.QueryParam("owner_id"); ownerID != "" {
+			id, err := strconv.Atoi(ownerID)
+			if err != nil || id <= 0 {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid owner ID '%s'", ownerID)})
+			}
+			filter.UserID = id
+		}
+
+		filter.NamePrefix = strings.TrimSpace(c.QueryParam("name_prefix"))
+
+		if archived := c.QueryParam("archived"); archived != "" {
+			b, err := strconv.ParseBool(archived)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid archived flag '%s'", archived)})
+			}
+			filter.Archived = sql.NullBool{Bool: b, Valid: true}
+		}
+
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != filter.Sort {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.AfterID = lc.ID
+			filter.AfterValue = lc.Value
+		}
+
+		permissions := model.Permissions{}
+		if err := permissions.ReadRolePermissions(db, user.RoleID); err != nil {
+			log.Println("err reading role permissions: ", err)
+			return errors.New("unable to read role permissions")
+		}
+		if !permissions.Has("all") {
+			filter.MemberID = user.ID
+		}
+
+		projects := model.Projects{}
+		filter.Limit = limit + 1
+		if err := projects.List(db, filter); err != nil {
+			log.Println("err listing projects: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list projects"})
+		}
+		total, err := projects.Count(db, filter)
+		if err != nil {
+			log.Println("err counting projects: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list projects"})
+		}
+
+		page := schema.Page[model.Project]{Items: projects, Total: total}
+		if len(projects) > limit {
+			page.Items = projects[:limit]
+			last := page.Items[limit-1]
+			lc := listCursor{Sort: filter.Sort, ID: last.ID}
+			if strings.TrimPrefix(filter.Sort, "-") == "name" {
+				lc.Value = last.Name
+			}
+			page.NextCursor, err = utils.EncodeCursor(lc)
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list projects"})
+			}
+		}
+
+		return c.JSON(http.StatusOK, page)
+	})
+}
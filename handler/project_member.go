@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+// actsAsOwner reports whether the caller may manage owners of the project.
+// Users without a project role got past ProjectPermissionRequired through
+// the "all" permission and are treated as owners.
+func actsAsOwner(c echo.Context) bool {
+	role, ok := c.Get("project_role").(constants.ProjectRole)
+	return !ok || role == constants.ProjectOwner
+}
+
+func HandleGetProjectMembers(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		members := model.ProjectMembers{}
+		if err := members.ReadProjectMembers(db, pID); err != nil {
+			log.Println("err reading project members: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read project members"})
+		}
+		return c.JSON(http.StatusOK, members)
+	})
+}
+
+func HandlePostProjectMember(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		memberIn := schema.ProjectMemberIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&memberIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if memberIn.UserID <= 0 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "user ID must be a positive integer"})
+		}
+		if !memberIn.Role.Valid() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid project role '%s'", memberIn.Role)})
+		}
+		if memberIn.Role == constants.ProjectOwner && !actsAsOwner(c) {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "only project owners can add owners"})
+		}
+
+		member := model.ProjectMember{ProjectID: pID, UserID: memberIn.UserID, Role: memberIn.Role}
+		if err := member.Create(db); err != nil {
+			log.Println("err creating project member: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("user '%d' is already a member of project '%d'", member.UserID, pID)})
+			}
+			if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("user '%d' does not exist", member.UserID)})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to add project member"})
+		}
+		if err := member.Read(db); err != nil {
+			log.Println("err reading project member: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read project member"})
+		}
+		return c.JSON(http.StatusOK, member)
+	})
+}
+
+// readTargetMember reads the member named by the "userID" route parameter
+// and checks that the caller may modify them.
+func readTargetMember(c echo.Context, db *sql.DB) (model.ProjectMember, model.ProjectMembers, error) {
+	projectID := c.Param("id")
+	pID, err := strconv.Atoi(projectID)
+	if err != nil || pID <= 0 {
+		return model.ProjectMember{}, nil, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid project ID '%s'", projectID)})
+	}
+	userID := c.Param("userID")
+	uID, err := strconv.Atoi(userID)
+	if err != nil || uID <= 0 {
+		return model.ProjectMember{}, nil, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid user ID '%s'", userID)})
+	}
+
+	members := model.ProjectMembers{}
+	if err := members.ReadProjectMembers(db, pID); err != nil {
+		log.Println("err reading project members: ", err)
+		return model.ProjectMember{}, nil, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read project members"})
+	}
+	for _, m := range members {
+		if m.UserID != uID {
+			continue
+		}
+		if m.Role == constants.ProjectOwner && !actsAsOwner(c) {
+			return model.ProjectMember{}, nil, c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "only project owners can modify owners"})
+		}
+		return m, members, nil
+	}
+	return model.ProjectMember{}, nil, c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("user '%d' is not a member of project '%d'", uID, pID)})
+}
+
+func HandlePatchProjectMember(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		memberIn := schema.ProjectMemberIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&memberIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !memberIn.Role.Valid() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid project role '%s'", memberIn.Role)})
+		}
+		if memberIn.Role == constants.ProjectOwner && !actsAsOwner(c) {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "only project owners can add owners"})
+		}
+
+		member, members, err := readTargetMember(c, db)
+		if err != nil || member.UserID == 0 {
+			return err
+		}
+		if member.Role == constants.ProjectOwner && memberIn.Role != constants.ProjectOwner && members.Owners() == 1 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "project must have at least one owner"})
+		}
+
+		member.Role = memberIn.Role
+		if err := member.UpdateRole(db); err != nil {
+			log.Println("err updating project member role: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update project member"})
+		}
+		return c.JSON(http.StatusOK, member)
+	})
+}
+
+func HandleDeleteProjectMember(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		member, members, err := readTargetMember(c, db)
+		if err != nil || member.UserID == 0 {
+			return err
+		}
+		if member.Role == constants.ProjectOwner && members.Owners() == 1 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "project must have at least one owner"})
+		}
+
+		if err := member.Delete(db); err != nil {
+			log.Println("err deleting project member: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to remove project member"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
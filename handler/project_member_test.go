@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func memberRequest(
+	t *testing.T,
+	authRes schema.AuthResponse,
+	method, jsonStr, permission string,
+	projectID, userID int,
+) *httptest.ResponseRecorder {
+	names := []string{"id"}
+	values := []string{fmt.Sprintf("%d", projectID)}
+	url := "http://localhost:8080/project/:id/members"
+	if userID > 0 {
+		names = append(names, "userID")
+		values = append(values, fmt.Sprintf("%d", userID))
+		url += "/:userID"
+	}
+	rec, c := createContextWithParams(method, url, jsonStr, names, values)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+
+	h := HandleGetProjectMembers(tDB)
+	switch method {
+	case "POST":
+		h = HandlePostProjectMember(tDB)
+	case "PATCH":
+		h = HandlePatchProjectMember(tDB)
+	case "DELETE":
+		h = HandleDeleteProjectMember(tDB)
+	}
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, permission)(h))(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func TestReadProjectAsNonMemberShouldFail(t *testing.T) {
+	p := createTestProject("Membership private project", testProjectManager.ID)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	rec, c := createContextWithParams(
+		"GET",
+		"http://localhost:8080/project/:id",
+		"",
+		[]string{"id"},
+		[]string{fmt.Sprintf("%d", p.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read project")(HandleGetProjectID(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+
+	code, page := listProjects(t, authRes, "name_prefix=Membership")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 0)
+
+	adminAuthRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	code, page = listProjects(t, adminAuthRes, "name_prefix=Membership")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 1)
+}
+
+func TestProjectMembersShouldPass(t *testing.T) {
+	p := createTestProject("Membership project", testProjectManager.ID)
+	ownerAuthRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	userAuthRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	jsonStr := fmt.Sprintf(`{"user_id": %d, "role": "viewer"}`, testUser.ID)
+	rec := memberRequest(t, ownerAuthRes, "POST", jsonStr, "update project", p.ID, 0)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	m := model.ProjectMember{}
+	err := json.NewDecoder(rec.Body).Decode(&m)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, m.Email, testUser.Email)
+	assert.AssertEq(t, m.Role, constants.ProjectViewer)
+
+	rec = memberRequest(t, ownerAuthRes, "POST", jsonStr, "update project", p.ID, 0)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	rec = memberRequest(t, userAuthRes, "GET", "", "read project", p.ID, 0)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	members := model.ProjectMembers{}
+	err = json.NewDecoder(rec.Body).Decode(&members)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(members), 2)
+
+	jsonStr = `{"assignee_id": 0, "title": "Viewer task", "content": "Viewer task content", "status": "todo"}`
+	rec, c := createContextWithParams(
+		"POST",
+		"http://localhost:8080/project/:projectID/task/create",
+		jsonStr,
+		[]string{"projectID"},
+		[]string{fmt.Sprintf("%d", p.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", userAuthRes.TokenType, userAuthRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+
+	rec = memberRequest(t, ownerAuthRes, "PATCH", `{"role": "contributor"}`, "update project", p.ID, testUser.ID)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	m = model.ProjectMember{ProjectID: p.ID, UserID: testUser.ID}
+	err = m.Read(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, m.Role, constants.ProjectContributor)
+
+	rec = memberRequest(t, ownerAuthRes, "DELETE", "", "update project", p.ID, testUser.ID)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	rec = memberRequest(t, userAuthRes, "GET", "", "read project", p.ID, 0)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+}
+
+func TestProjectMembersOwnerRulesShouldHold(t *testing.T) {
+	_, maintainer := createTestUserWithRole("testmaintainer@example.com", "Testpass1", constants.ProjectManagerRoleID)
+	p := createTestProject("Membership owner rules project", testProjectManager.ID)
+	addTestProjectMember(p.ID, maintainer.ID, constants.ProjectMaintainer)
+	ownerAuthRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	maintainerAuthRes := login(t, maintainer.Email, "Testpass1")
+
+	jsonStr := fmt.Sprintf(`{"user_id": %d, "role": "owner"}`, testUser.ID)
+	rec := memberRequest(t, maintainerAuthRes, "POST", jsonStr, "update project", p.ID, 0)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+
+	rec = memberRequest(t, maintainerAuthRes, "DELETE", "", "update project", p.ID, testProjectManager.ID)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+
+	rec = memberRequest(t, ownerAuthRes, "PATCH", `{"role": "viewer"}`, "update project", p.ID, testProjectManager.ID)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	rec = memberRequest(t, ownerAuthRes, "DELETE", "", "update project", p.ID, testProjectManager.ID)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	rec = memberRequest(t, ownerAuthRes, "PATCH", `{"role": "admin"}`, "update project", p.ID, maintainer.ID)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	rec = memberRequest(t, ownerAuthRes, "PATCH", `{"role": "owner"}`, "update project", p.ID, maintainer.ID)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+
+	rec = memberRequest(t, ownerAuthRes, "DELETE", "", "update project", p.ID, testProjectManager.ID)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+
+	rec = memberRequest(t, maintainerAuthRes, "DELETE", "", "update project", p.ID, testUser.ID)
+	assert.AssertEq(t, rec.Code, http.StatusNotFound)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/tomihaapalainen/go-task-mgmt/assert"
@@ -29,7 +30,7 @@ func TestPostCreateProjectShouldPass(t *testing.T) {
 			jsonStr := fmt.Sprintf(`{"user_id": %d, "name": "%s", "description": "Test description"}`, tc.id, tc.projectName)
 			rec, c := createContext("POST", "http://localhost:8080/project/create", jsonStr)
 			c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-			err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "create project")(HandlePostCreateProject(tDB)))(c)
+			err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "create project")(HandlePostCreateProject(tDB)))(c)
 			assert.AssertEq(t, err, nil)
 			assert.AssertEq(t, rec.Code, http.StatusOK)
 			project := model.Project{}
@@ -47,7 +48,7 @@ func TestPostCreateProjectWithoutPermissionShouldFail(t *testing.T) {
 	jsonStr := fmt.Sprintf(`{"user_id": %d, "name": "Test project user", "description": "Test description"}`, testUser.ID)
 	rec, c := createContext("POST", "http://localhost:8080/project/create", jsonStr)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "create project")(HandlePostCreateProject(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "create project")(HandlePostCreateProject(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusForbidden)
 }
@@ -62,7 +63,7 @@ func TestDeleteProjectWithoutPermissionShouldFail(t *testing.T) {
 		[]string{"id"},
 		[]string{fmt.Sprintf("%d", testProject.ID)})
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "delete project")(HandleDeleteProject(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "delete project")(HandleDeleteProject(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusForbidden)
 	r := schema.MessageResponse{}
@@ -80,7 +81,7 @@ func TestDeleteProjectShouldPass(t *testing.T) {
 		[]string{"id"},
 		[]string{fmt.Sprintf("%d", testProjectForDeletion.ID)})
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "delete project")(HandleDeleteProject(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "delete project")(HandleDeleteProject(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusNoContent)
 	r := schema.MessageResponse{}
@@ -99,7 +100,7 @@ func TestReadProjectShouldPass(t *testing.T) {
 		[]string{fmt.Sprintf("%d", testProject.ID)},
 	)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "read project")(HandleGetProjectID(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read project")(HandleGetProjectID(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusOK)
 	p := model.Project{}
@@ -123,7 +124,8 @@ func TestPatchProjectShouldPass(t *testing.T) {
 		[]string{fmt.Sprintf("%d", testProject.ID)},
 	)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
+	c.Request().Header.Set("If-Match", "*")
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusOK)
 	p := model.Project{}
@@ -147,7 +149,154 @@ func TestPatchProjectWithoutPermissionShouldFail(t *testing.T) {
 		[]string{fmt.Sprintf("%d", testProject.ID)},
 	)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusForbidden)
 }
+
+func TestPatchProjectWithStaleVersionShouldFail(t *testing.T) {
+	project := createTestProject("Concurrently edited project", testAdmin.ID)
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		rec, c := createContextWithParams(
+			"PATCH",
+			"http://localhost:8080/project/:id",
+			`{"name": "Concurrently edited project", "description": "Edited"}`,
+			[]string{"id"},
+			[]string{fmt.Sprintf("%d", project.ID)},
+		)
+		c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+		if ifMatch != "" {
+			c.Request().Header.Set("If-Match", ifMatch)
+		}
+		err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
+		assert.AssertEq(t, err, nil)
+		return rec
+	}
+
+	etag := fmt.Sprintf(`"%d"`, project.Version)
+	rec := patch(etag)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	assert.AssertEq(t, rec.Header().Get("ETag"), fmt.Sprintf(`"%d"`, project.Version+1))
+	p := model.Project{}
+	err := json.NewDecoder(rec.Body).Decode(&p)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, p.Version, project.Version+1)
+	assert.AssertEq(t, p.CreatedAt, project.CreatedAt)
+
+	assert.AssertEq(t, patch(etag).Code, http.StatusPreconditionFailed)
+	assert.AssertEq(t, patch("").Code, http.StatusPreconditionRequired)
+	assert.AssertEq(t, patch("W/"+etag).Code, http.StatusPreconditionFailed)
+
+	// A deleted project is missing rather than modified.
+	err = project.Delete(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, patch("*").Code, http.StatusNotFound)
+}
+
+func TestPatchProjectShouldKeepOmittedFields(t *testing.T) {
+	project := createTestProject("Archived project", testAdmin.ID)
+	project.Archived = true
+	err := project.Update(tDB)
+	assert.AssertEq(t, err, nil)
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	rec, c := createContextWithParams(
+		"PATCH",
+		"http://localhost:8080/project/:id",
+		`{"name": "Renamed archived project"}`,
+		[]string{"id"},
+		[]string{fmt.Sprintf("%d", project.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	c.Request().Header.Set("If-Match", "*")
+	err = mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	p := model.Project{}
+	err = json.NewDecoder(rec.Body).Decode(&p)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, p.Name, "Renamed archived project")
+	assert.AssertEq(t, p.Description, project.Description)
+	assert.AssertEq(t, p.Archived, true)
+}
+
+func listProjects(t *testing.T, authRes schema.AuthResponse, query string) (int, schema.Page[model.Project]) {
+	rec, c := createContext("GET", "http://localhost:8080/project?"+query, "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "read project")(HandleGetProjects(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	page := schema.Page[model.Project]{}
+	if rec.Code == http.StatusOK {
+		err = json.NewDecoder(rec.Body).Decode(&page)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, page
+}
+
+func TestGetProjectsShouldPaginate(t *testing.T) {
+	names := []string{"Listing a", "Listing b", "Listing c", "Listing d", "Listing e"}
+	for _, name := range names {
+		createTestProject(name, testProjectManager.ID)
+	}
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	seen := []string{}
+	cursor := ""
+	for i := 0; i < len(names); i++ {
+		code, page := listProjects(t, authRes, "name_prefix=Listing&sort=-name&limit=2&cursor="+cursor)
+		assert.AssertEq(t, code, http.StatusOK)
+		assert.AssertEq(t, page.Total, len(names))
+		for _, p := range page.Items {
+			seen = append(seen, p.Name)
+		}
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.AssertEq(t, len(seen), len(names))
+	for i, name := range seen {
+		assert.AssertEq(t, name, names[len(names)-1-i])
+	}
+}
+
+func TestGetProjectsShouldFilter(t *testing.T) {
+	archived := createTestProject("Filtering archived", testProjectManager.ID)
+	archived.Archived = true
+	err := archived.Update(tDB)
+	assert.AssertEq(t, err, nil)
+	active := createTestProject("Filtering active", testProjectManager.ID)
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	code, page := listProjects(t, authRes, "name_prefix=Filtering&archived=false")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 1)
+	assert.AssertEq(t, page.Items[0].ID, active.ID)
+
+	code, page = listProjects(t, authRes, fmt.Sprintf("owner_id=%d&name_prefix=Filtering&archived=true", testProjectManager.ID))
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 1)
+	assert.AssertEq(t, page.Items[0].ID, archived.ID)
+
+	code, page = listProjects(t, authRes, fmt.Sprintf("owner_id=%d&name_prefix=Filtering", testAdmin.ID))
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 0)
+	assert.AssertEq(t, len(page.Items), 0)
+
+	code, page = listProjects(t, authRes, "name_prefix=Filtering%25")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 0)
+}
+
+func TestGetProjectsWithInvalidQueryShouldFail(t *testing.T) {
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	for _, query := range []string{"sort=owner", "owner_id=x", "archived=maybe", "limit=0", "cursor=invalid"} {
+		t.Run(query, func(t *testing.T) {
+			code, _ := listProjects(t, authRes, query)
+			assert.AssertEq(t, code, http.StatusBadRequest)
+		})
+	}
+}
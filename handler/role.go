@@ -4,11 +4,18 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
 	"github.com/tomihaapalainen/go-task-mgmt/schema"
 )
 
@@ -19,11 +26,49 @@ func HandlePatchAssignRole(db *sql.DB) echo.HandlerFunc {
 			log.Println("err decoding body:", err)
 			return errors.New("invalid request body")
 		}
-		u := model.User{ID: ar.UserID, RoleID: ar.RoleID}
+
+		u := model.User{ID: ar.UserID}
+		if err := u.ReadByID(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("user '%d' not found", ar.UserID)})
+			}
+			log.Println("err reading user by id:", err)
+			return errors.New("unable to read user by ID")
+		}
+		current := model.Role{ID: u.RoleID}
+		if err := current.ReadByID(db); err != nil {
+			log.Println("err reading role:", err)
+			return errors.New("unable to read role")
+		}
+		role := model.Role{ID: ar.RoleID}
+		if err := role.ReadByID(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("role '%d' does not exist", ar.RoleID)})
+			}
+			log.Println("err reading role:", err)
+			return errors.New("unable to read role")
+		}
+		if current.Permissions.Has("all") && !role.Permissions.Has("all") {
+			last, err := removesLastAdmin(db, 1)
+			if err != nil {
+				log.Println("err counting admins:", err)
+				return errors.New("unable to count admins")
+			}
+			if last {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "there must be at least one admin"})
+			}
+		}
+
+		u.RoleID = ar.RoleID
 		if err := u.UpdateRole(db); err != nil {
 			log.Println("err updating role:", err)
 			return errors.New("unable to update role")
 		}
+		if err := u.RevokeSessions(db); err != nil {
+			log.Println("err revoking sessions:", err)
+			return errors.New("unable to revoke sessions")
+		}
+		mw.ForgetUser(u.ID)
 		if err := u.ReadByID(db); err != nil {
 			log.Println("err reading user by id:", err)
 			return errors.New("unable to read user by ID")
@@ -31,3 +76,257 @@ func HandlePatchAssignRole(db *sql.DB) echo.HandlerFunc {
 		return c.JSON(http.StatusOK, u)
 	})
 }
+
+// removesLastAdmin reports whether a change that takes the "all" permission
+// away from removed users would leave no admins.
+func removesLastAdmin(db *sql.DB, removed int) (bool, error) {
+	admins, err := model.AdminCount(db)
+	if err != nil {
+		return false, err
+	}
+	return admins <= removed, nil
+}
+
+func HandleGetRoles(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		roles := model.Roles{}
+		if err := roles.ReadAll(db); err != nil {
+			log.Println("err reading roles: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read roles"})
+		}
+		return c.JSON(http.StatusOK, roles)
+	})
+}
+
+func HandleGetPermissions(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		permissions := model.Permissions{}
+		if err := permissions.ReadAll(db); err != nil {
+			log.Println("err reading permissions: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read permissions"})
+		}
+		return c.JSON(http.StatusOK, permissions)
+	})
+}
+
+func HandlePostCreateRole(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		roleIn := schema.RoleIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&roleIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		roleIn.Name = strings.TrimSpace(roleIn.Name)
+		if roleIn.Name == "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "role name must not be empty"})
+		}
+
+		role := model.Role{Name: roleIn.Name}
+		if err := role.Create(db, roleIn.PermissionIDs); err != nil {
+			log.Println("err creating role: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("role '%s' already exists", role.Name)})
+			}
+			if isConstraintError(err, sqlite3.ErrConstraintPrimaryKey) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "permission IDs must not contain duplicates"})
+			}
+			if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "permission does not exist"})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to create role"})
+		}
+		return c.JSON(http.StatusOK, role)
+	})
+}
+
+// readTargetRole reads the role named by the "id" route parameter.
+func readTargetRole(c echo.Context, db *sql.DB) (model.Role, error) {
+	roleID := c.Param("id")
+	rID, err := strconv.Atoi(roleID)
+	if err != nil || rID <= 0 {
+		return model.Role{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid role ID '%s'", roleID)})
+	}
+	role := model.Role{ID: constants.RoleID(rID)}
+	if err := role.ReadByID(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Role{}, c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("role '%d' not found", rID)})
+		}
+		log.Println("err reading role: ", err)
+		return model.Role{}, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read role"})
+	}
+	return role, nil
+}
+
+func HandleGetRoleID(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		role, err := readTargetRole(c, db)
+		if err != nil || role.ID == 0 {
+			return err
+		}
+		return c.JSON(http.StatusOK, role)
+	})
+}
+
+func HandlePatchRoleID(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		roleIn := schema.RoleIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&roleIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		roleIn.Name = strings.TrimSpace(roleIn.Name)
+		if roleIn.Name == "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "role name must not be empty"})
+		}
+
+		role, err := readTargetRole(c, db)
+		if err != nil || role.ID == 0 {
+			return err
+		}
+		if role.BuiltIn {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("built-in role '%s' cannot be renamed", role.Name)})
+		}
+
+		role.Name = roleIn.Name
+		if err := role.UpdateName(db); err != nil {
+			log.Println("err updating role: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintUnique) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("role '%s' already exists", role.Name)})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update role"})
+		}
+		return c.JSON(http.StatusOK, role)
+	})
+}
+
+// HandlePutRoleTwoFactor sets whether users with the role must enable
+// two-factor authentication. Until they do, routes requiring a permission
+// are closed to them.
+func HandlePutRoleTwoFactor(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		caller := c.Get("user").(model.User)
+		policyIn := schema.TwoFactorPolicyIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&policyIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+
+		role, err := readTargetRole(c, db)
+		if err != nil || role.ID == 0 {
+			return err
+		}
+		role.TwoFactorRequired = policyIn.Required
+		if err := role.UpdateTwoFactorRequired(db); err != nil {
+			log.Println("err updating role two-factor policy: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update role"})
+		}
+		audit := model.AuditEntry{
+			CreatedAt: time.Now().Unix(),
+			ActorID:   caller.ID,
+			Action:    model.AuditTwoFactorPolicy,
+			Subject:   fmt.Sprintf("role:%d", role.ID),
+			Details:   fmt.Sprintf("required=%t", role.TwoFactorRequired),
+		}
+		if err := audit.Create(db); err != nil {
+			log.Println("err creating audit entry: ", err)
+		}
+		return c.JSON(http.StatusOK, role)
+	})
+}
+
+func HandleDeleteRole(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		role, err := readTargetRole(c, db)
+		if err != nil || role.ID == 0 {
+			return err
+		}
+		if role.BuiltIn {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("built-in role '%s' cannot be deleted", role.Name)})
+		}
+		users, err := role.UserCount(db)
+		if err != nil {
+			log.Println("err counting role users: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to count role users"})
+		}
+		if users > 0 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("role '%s' is assigned to %d users", role.Name, users)})
+		}
+
+		if err := role.Delete(db); err != nil {
+			log.Println("err deleting role: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to delete role"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+func HandlePostRolePermission(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		permissionIn := schema.RolePermissionIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&permissionIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if permissionIn.PermissionID <= 0 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "permission ID must be a positive integer"})
+		}
+
+		role, err := readTargetRole(c, db)
+		if err != nil || role.ID == 0 {
+			return err
+		}
+		if err := role.AttachPermission(db, permissionIn.PermissionID); err != nil {
+			log.Println("err attaching permission: ", err)
+			if isConstraintError(err, sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("role '%s' already has permission '%d'", role.Name, permissionIn.PermissionID)})
+			}
+			if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("permission '%d' does not exist", permissionIn.PermissionID)})
+			}
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to attach permission"})
+		}
+		if err := role.ReadByID(db); err != nil {
+			log.Println("err reading role: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read role"})
+		}
+		return c.JSON(http.StatusOK, role)
+	})
+}
+
+func HandleDeleteRolePermission(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		role, err := readTargetRole(c, db)
+		if err != nil || role.ID == 0 {
+			return err
+		}
+		permissionID := c.Param("permissionID")
+		pID, err := strconv.Atoi(permissionID)
+		if err != nil || pID <= 0 {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid permission ID '%s'", permissionID)})
+		}
+		if pID == int(constants.All) && role.Permissions.Has("all") {
+			users, err := role.UserCount(db)
+			if err != nil {
+				log.Println("err counting role users: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to count role users"})
+			}
+			last, err := removesLastAdmin(db, users)
+			if err != nil {
+				log.Println("err counting admins: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to count admins"})
+			}
+			if users > 0 && last {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "there must be at least one admin"})
+			}
+		}
+
+		if err := role.DetachPermission(db, pID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("role '%s' does not have permission '%d'", role.Name, pID)})
+			}
+			log.Println("err detaching permission: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to detach permission"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
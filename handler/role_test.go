@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/labstack/echo/v4"
 	"github.com/tomihaapalainen/go-task-mgmt/assert"
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
@@ -16,11 +18,12 @@ import (
 
 func TestAssignRoleShouldPass(t *testing.T) {
 	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	userAuthRes := login(t, testUserForRoleIn.Email, testUserForRoleIn.Password)
 
 	jsonStr := fmt.Sprintf(`{"role_id": %d, "user_id": %d}`, constants.ProjectManagerRoleID, testUserForRole.ID)
 	rec, c := createContext("PATCH", "http://localhost:8080/role/assign", jsonStr)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "manage roles")(HandlePatchAssignRole(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "manage roles")(HandlePatchAssignRole(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusOK)
 	u := model.User{ID: testUserForRole.ID}
@@ -30,6 +33,12 @@ func TestAssignRoleShouldPass(t *testing.T) {
 	err = u.ReadByID(tDB)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, u.RoleID, constants.ProjectManagerRoleID)
+
+	rec, c = createContext("POST", "http://localhost:8080/auth/logout", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", userAuthRes.TokenType, userAuthRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(HandlePostLogOut(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
 }
 
 func TestAssignRoleWithoutPermissionShouldFail(t *testing.T) {
@@ -38,7 +47,7 @@ func TestAssignRoleWithoutPermissionShouldFail(t *testing.T) {
 	jsonStr := fmt.Sprintf(`{"role_id": %d, "user_id": %d}`, constants.ProjectManagerRoleID, testUserForRole.ID)
 	rec, c := createContext("PATCH", "http://localhost:8080/role/assign", jsonStr)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "manage roles")(HandlePatchAssignRole(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "manage roles")(HandlePatchAssignRole(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusForbidden)
 	m := schema.MessageResponse{}
@@ -48,3 +57,128 @@ func TestAssignRoleWithoutPermissionShouldFail(t *testing.T) {
 		t.Fatalf("'%s' did not contain 'does not have permission'", m.Message)
 	}
 }
+
+func roleRequest(t *testing.T, authRes schema.AuthResponse, method, jsonStr string, params []string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	names := []string{"id", "permissionID"}[:len(params)]
+	return authRequest(t, authRes, method, "http://localhost:8080/role", jsonStr, names, params, h, mw.PermissionRequired(tDB, "manage roles"))
+}
+
+func TestRoleLifecycleShouldPass(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	jsonStr := fmt.Sprintf(`{"name": "auditor", "permission_ids": [%d]}`, constants.ReadProject)
+	rec := roleRequest(t, authRes, "POST", jsonStr, nil, HandlePostCreateRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	role := model.Role{}
+	err := json.NewDecoder(rec.Body).Decode(&role)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, role.Name, "auditor")
+	assert.AssertEq(t, role.BuiltIn, false)
+	assert.AssertEq(t, len(role.Permissions), 1)
+	id := fmt.Sprintf("%d", role.ID)
+
+	rec = roleRequest(t, authRes, "PATCH", `{"name": "read-only auditor"}`, []string{id}, HandlePatchRoleID(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+
+	jsonStr = fmt.Sprintf(`{"permission_id": %d}`, constants.ReadTask)
+	rec = roleRequest(t, authRes, "POST", jsonStr, []string{id}, HandlePostRolePermission(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	rec = roleRequest(t, authRes, "POST", jsonStr, []string{id}, HandlePostRolePermission(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	rec = roleRequest(t, authRes, "GET", "", []string{id}, HandleGetRoleID(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	role = model.Role{}
+	err = json.NewDecoder(rec.Body).Decode(&role)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, role.Name, "read-only auditor")
+	assert.AssertEq(t, role.Permissions.Has("read task"), true)
+
+	rec = roleRequest(t, authRes, "DELETE", "", []string{id, fmt.Sprintf("%d", constants.ReadTask)}, HandleDeleteRolePermission(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	rec = roleRequest(t, authRes, "DELETE", "", []string{id, fmt.Sprintf("%d", constants.ReadTask)}, HandleDeleteRolePermission(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNotFound)
+
+	rec = roleRequest(t, authRes, "DELETE", "", []string{id}, HandleDeleteRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	rec = roleRequest(t, authRes, "GET", "", []string{id}, HandleGetRoleID(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNotFound)
+}
+
+func TestCreateDuplicateRoleShouldFail(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	rec := roleRequest(t, authRes, "POST", `{"name": "user"}`, nil, HandlePostCreateRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestCreateRoleWithoutPermissionShouldFail(t *testing.T) {
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	rec := roleRequest(t, authRes, "POST", `{"name": "sneaky"}`, nil, HandlePostCreateRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+}
+
+func TestModifyBuiltInRoleShouldFail(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	id := fmt.Sprintf("%d", constants.UserRoleID)
+
+	rec := roleRequest(t, authRes, "PATCH", `{"name": "member"}`, []string{id}, HandlePatchRoleID(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+	rec = roleRequest(t, authRes, "DELETE", "", []string{id}, HandleDeleteRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestDeleteRoleInUseShouldFail(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	role := model.Role{Name: "role in use"}
+	err := role.Create(tDB, nil)
+	assert.AssertEq(t, err, nil)
+	createTestUserWithRole("roleinuse@example.com", "Testpass1", role.ID)
+
+	rec := roleRequest(t, authRes, "DELETE", "", []string{fmt.Sprintf("%d", role.ID)}, HandleDeleteRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestRemoveLastAdminShouldFail(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	rec := roleRequest(
+		t, authRes, "DELETE", "",
+		[]string{fmt.Sprintf("%d", constants.AdminRoleID), fmt.Sprintf("%d", constants.All)},
+		HandleDeleteRolePermission(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+	permissions := model.Permissions{}
+	err := permissions.ReadRolePermissions(tDB, constants.AdminRoleID)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, permissions.Has("all"), true)
+
+	jsonStr := fmt.Sprintf(`{"role_id": %d, "user_id": %d}`, constants.UserRoleID, testAdmin.ID)
+	rec = roleRequest(t, authRes, "PATCH", jsonStr, nil, HandlePatchAssignRole(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+	m := schema.MessageResponse{}
+	err = json.NewDecoder(rec.Body).Decode(&m)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, m.Message, "there must be at least one admin")
+}
+
+func TestGetRolesAndPermissionsShouldPass(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	rec := roleRequest(t, authRes, "GET", "", nil, HandleGetRoles(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	roles := model.Roles{}
+	err := json.NewDecoder(rec.Body).Decode(&roles)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, roles[0].Name, "admin")
+	assert.AssertEq(t, roles[0].BuiltIn, true)
+
+	rec = roleRequest(t, authRes, "GET", "", nil, HandleGetPermissions(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	permissions := model.Permissions{}
+	err = json.NewDecoder(rec.Body).Decode(&permissions)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(permissions), 11)
+	assert.AssertEq(t, permissions[constants.ManageRoles-1].Name, "manage roles")
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+// searchCursor is the position of the last result of a page of search
+// results.
+type searchCursor struct {
+	Rank float64 `json:"r"`
+	Type string  `json:"t"`
+	ID   int     `json:"i"`
+}
+
+func HandleGetSearch(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		filter := model.SearchFilter{Query: strings.TrimSpace(c.QueryParam("q"))}
+		if filter.Query == "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "search query 'q' must not be empty"})
+		}
+
+		if projectID := c.QueryParam("project_id"); projectID != "" {
+			pID, err := strconv.Atoi(projectID)
+			if err != nil || pID <= 0 {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid project ID '%s'", projectID)})
+			}
+			filter.ProjectID = pID
+		}
+		filter.Status = constants.TaskStatus(strings.TrimSpace(c.QueryParam("status")))
+
+		permissions := model.Permissions{}
+		if err := permissions.ReadRolePermissions(db, user.RoleID); err != nil {
+			log.Println("err reading role permissions: ", err)
+			return errors.New("unable to read role permissions")
+		}
+		if !permissions.Has("all") {
+			filter.MemberID = user.ID
+		}
+		filter.Tasks = permissions.Has("read task") && mw.TokenAllows(c, "read task")
+		filter.Projects = permissions.Has("read project") && mw.TokenAllows(c, "read project")
+		switch c.QueryParam("type") {
+		case "":
+		case "task":
+			filter.Projects = false
+		case "project":
+			filter.Tasks = false
+		default:
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid type '%s'", c.QueryParam("type"))})
+		}
+
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			sc := searchCursor{}
+			if err := utils.DecodeCursor(cursor, &sc); err != nil || (sc.Type != "task" && sc.Type != "project") {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.AfterRank, filter.AfterType, filter.AfterID = sc.Rank, sc.Type, sc.ID
+		}
+
+		results := model.SearchResults{}
+		filter.Limit = limit + 1
+		if err := results.Search(db, filter); err != nil {
+			if errors.Is(err, model.ErrInvalidSearchQuery) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid search query '%s'", filter.Query)})
+			}
+			log.Println("err searching: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to search"})
+		}
+		total, err := results.Count(db, filter)
+		if err != nil {
+			log.Println("err counting search results: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to search"})
+		}
+
+		page := schema.Page[model.SearchResult]{Items: results, Total: total}
+		if len(results) > limit {
+			page.Items = results[:limit]
+			last := page.Items[limit-1]
+			page.NextCursor, err = utils.EncodeCursor(searchCursor{Rank: last.Rank, Type: last.Type, ID: last.ID})
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to search"})
+			}
+		}
+		return c.JSON(http.StatusOK, page)
+	})
+}
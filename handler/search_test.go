@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func search(t *testing.T, query string) (int, schema.Page[model.SearchResult]) {
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	rec, c := createContext("GET", "http://localhost:8080/search?"+query, "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(HandleGetSearch(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	page := schema.Page[model.SearchResult]{}
+	if rec.Code == http.StatusOK {
+		err = json.NewDecoder(rec.Body).Decode(&page)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, page
+}
+
+func TestGetSearchShouldFindTasksAndProjects(t *testing.T) {
+	p := createTestProject("Zebra migration", testProjectManager.ID)
+	other := createTestProject("Search other project", testProjectManager.ID)
+	for _, task := range []model.Task{
+		{ProjectID: p.ID, Title: "Zebra crossing", Content: "Paint the stripes", Status: constants.Todo},
+		{ProjectID: p.ID, Title: "Count animals", Content: "One zebra escaped from the <enclosure>", Status: constants.Doing},
+		{ProjectID: other.ID, Title: "Feed zebras", Content: "Hay twice a day", Status: constants.Todo},
+	} {
+		task.AssigneeID = testUser.ID
+		task.CreatorID = testUser.ID
+		err := task.Create(tDB)
+		assert.AssertEq(t, err, nil)
+	}
+
+	testCases := []struct {
+		query  string
+		titles []string
+	}{
+		{"q=zebra&type=task", []string{"Zebra crossing", "Count animals"}},
+		{"q=zebra*&type=task", []string{"Zebra crossing", "Feed zebras", "Count animals"}},
+		{"q=" + url.QueryEscape(`"zebra escaped"`), []string{"Count animals"}},
+		{"q=zebra&status=doing", []string{"Count animals"}},
+		{fmt.Sprintf("q=zebra*&type=task&project_id=%d", other.ID), []string{"Feed zebras"}},
+		{"q=zebra&type=project", []string{"Zebra migration"}},
+		{"q=giraffe", []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			code, page := search(t, tc.query)
+			assert.AssertEq(t, code, http.StatusOK)
+			titles := []string{}
+			for _, r := range page.Items {
+				titles = append(titles, r.Title)
+			}
+			assert.AssertEq(t, strings.Join(titles, ","), strings.Join(tc.titles, ","))
+		})
+	}
+
+	_, page := search(t, url.Values{"q": {`"zebra escaped"`}}.Encode())
+	assert.AssertEq(t, len(page.Items), 1)
+	assert.AssertEq(t, page.Items[0].Snippet, "One <mark>zebra escaped</mark> from the &lt;enclosure&gt;")
+}
+
+func TestGetSearchShouldPaginate(t *testing.T) {
+	p := createTestProject("Pagination search project", testProjectManager.ID)
+	for i := 0; i < 3; i++ {
+		task := model.Task{ProjectID: p.ID, AssigneeID: testUser.ID, CreatorID: testUser.ID, Title: fmt.Sprintf("Quokka %d", i), Content: "quokka", Status: constants.Todo}
+		err := task.Create(tDB)
+		assert.AssertEq(t, err, nil)
+	}
+
+	code, page := search(t, "q=quokka&limit=2")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 3)
+	assert.AssertEq(t, len(page.Items), 2)
+	assert.AssertNotEq(t, page.NextCursor, "")
+
+	code, page = search(t, "q=quokka&limit=2&cursor="+page.NextCursor)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, len(page.Items), 1)
+	assert.AssertEq(t, page.NextCursor, "")
+
+	// Pages of tasks and projects together follow the order of the ranks.
+	createTestProject("Quokka enclosure", testProjectManager.ID)
+	seen := map[string]bool{}
+	cursor := ""
+	rank := math.Inf(1)
+	for {
+		code, page = search(t, "q=quokka&limit=1&cursor="+cursor)
+		assert.AssertEq(t, code, http.StatusOK)
+		assert.AssertEq(t, page.Total, 4)
+		assert.AssertEq(t, len(page.Items), 1)
+		r := page.Items[0]
+		assert.AssertEq(t, r.Rank <= rank, true)
+		rank = r.Rank
+		seen[fmt.Sprintf("%s %d", r.Type, r.ID)] = true
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.AssertEq(t, len(seen), 4)
+}
+
+func TestGetSearchWithInvalidQueryShouldFail(t *testing.T) {
+	for _, query := range []string{"", "q=", "q=" + url.QueryEscape(`"unterminated`), "q=" + url.QueryEscape("a AND"), "q=a&type=user", "q=a&project_id=x"} {
+		t.Run(query, func(t *testing.T) {
+			code, _ := search(t, query)
+			assert.AssertEq(t, code, http.StatusBadRequest)
+		})
+	}
+}
@@ -9,12 +9,79 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
 	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
 )
 
+// parseTaskTime parses a task start or due time given as an RFC 3339 time or
+// a YYYY-MM-DD date in loc. A date stands for the start of the day, or its
+// last second if endOfDay is set. An empty string is parsed as 0.
+func parseTaskTime(s string, loc *time.Location, endOfDay bool) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, loc)
+	if err != nil {
+		return 0, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t.Unix(), nil
+}
+
+// taskTimes parses the start and due times of taskIn for user. msg explains
+// why they are invalid if it is not empty.
+func taskTimes(taskIn schema.TaskIn, user model.User) (startAt, dueAt int64, msg string) {
+	loc := user.Location()
+	startAt, err := parseTaskTime(strings.TrimSpace(taskIn.StartAt), loc, false)
+	if err != nil {
+		return 0, 0, fmt.Sprintf("invalid start_at '%s'", taskIn.StartAt)
+	}
+	dueAt, err = parseTaskTime(strings.TrimSpace(taskIn.DueAt), loc, true)
+	if err != nil {
+		return 0, 0, fmt.Sprintf("invalid due_at '%s'", taskIn.DueAt)
+	}
+	if startAt != 0 && dueAt != 0 && startAt > dueAt {
+		return 0, 0, "task must not start after it is due"
+	}
+	return startAt, dueAt, ""
+}
+
+// readRouteTask reads the task named by the "projectID" and "id" route
+// parameters. If it cannot be read, a response has been written and the
+// returned task has no ID.
+func readRouteTask(c echo.Context, db *sql.DB) (model.Task, error) {
+	projectID := c.Param("projectID")
+	pID, err := strconv.Atoi(projectID)
+	if err != nil || pID <= 0 {
+		return model.Task{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid project ID '%s'", projectID)})
+	}
+	taskID := c.Param("id")
+	tID, err := strconv.Atoi(taskID)
+	if err != nil || tID <= 0 {
+		return model.Task{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid task ID '%s'", taskID)})
+	}
+	task := model.Task{ID: tID, ProjectID: pID}
+	if err := task.ReadByID(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Task{}, c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("task '%d' not found in project '%d'", tID, pID)})
+		}
+		log.Println("err reading task by ID: ", err)
+		return model.Task{}, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read task"})
+	}
+	return task, nil
+}
+
 func HandlePostCreateTask(db *sql.DB) echo.HandlerFunc {
 	return echo.HandlerFunc(func(c echo.Context) error {
 		user := c.Get("user").(model.User)
@@ -41,6 +108,23 @@ func HandlePostCreateTask(db *sql.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "task content"})
 		}
 
+		startAt, dueAt, msg := taskTimes(taskIn, user)
+		if msg != "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: msg})
+		}
+
+		workflow, err := readWorkflow(c, db, pID)
+		if err != nil || len(workflow.States) == 0 {
+			return err
+		}
+		taskIn.Status = constants.TaskStatus(strings.TrimSpace(string(taskIn.Status)))
+		if taskIn.Status == "" {
+			taskIn.Status = workflow.Initial()
+		}
+		if _, ok := workflow.State(taskIn.Status); !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid status '%s'", taskIn.Status)})
+		}
+
 		task := model.Task{
 			ProjectID:  pID,
 			AssigneeID: taskIn.AssigneeID,
@@ -48,12 +132,18 @@ func HandlePostCreateTask(db *sql.DB) echo.HandlerFunc {
 			Title:      taskIn.Title,
 			Content:    taskIn.Content,
 			Status:     taskIn.Status,
+			StartAt:    startAt,
+			DueAt:      dueAt,
 		}
 		if err := task.Create(db); err != nil {
 			log.Println("err creating task: ", err)
 			return fmt.Errorf("error creating task")
 		}
+		updateMentions(db, model.MentionSource{ProjectID: pID, TaskID: task.ID, AuthorID: user.ID}, task.Content)
+		notifyTaskChange(db, user.ID, model.Task{}, task)
+		publishTaskEvent(db, model.WebhookTaskCreated, task)
 
+		setETag(c, task.Version)
 		return c.JSON(http.StatusOK, task)
 	})
 }
@@ -77,6 +167,7 @@ func HandleGetTaskID(db *sql.DB) echo.HandlerFunc {
 			return errors.New("unable to read task")
 		}
 
+		setETag(c, task.Version)
 		return c.JSON(
 			http.StatusOK,
 			task,
@@ -86,6 +177,8 @@ func HandleGetTaskID(db *sql.DB) echo.HandlerFunc {
 
 func HandlePatchTaskID(db *sql.DB) echo.HandlerFunc {
 	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
 		projectID := c.Param("projectID")
 		pID, err := strconv.Atoi(projectID)
 		if err != nil || pID <= 0 {
@@ -96,29 +189,93 @@ func HandlePatchTaskID(db *sql.DB) echo.HandlerFunc {
 		if err != nil || tID <= 0 {
 			return fmt.Errorf("invalid project ID '%s'", taskID)
 		}
+		version, ok, err := ifMatchVersion(c)
+		if !ok {
+			return err
+		}
 
-		task := model.Task{}
-		if err := json.NewDecoder(c.Request().Body).Decode(&task); err != nil {
+		taskIn := schema.TaskPatchIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&taskIn); err != nil {
 			log.Println("err decoding request body: ", err)
 			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
 		}
 
-		task.Title = strings.TrimSpace(task.Title)
-		if task.Title == "" {
-			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "task title must not be empty"})
+		current := model.Task{ID: tID, ProjectID: pID}
+		if err := current.ReadByID(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("task '%d' not found in project '%d'", tID, pID)})
+			}
+			log.Println("err reading task by ID: ", err)
+			return errors.New("unable to read task")
 		}
 
-		task.Content = strings.TrimSpace(task.Content)
-		if task.Content == "" {
-			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "task content"})
+		task := current
+		task.Version = version
+		if taskIn.AssigneeID != nil {
+			task.AssigneeID = *taskIn.AssigneeID
+		}
+		if taskIn.Title != nil {
+			task.Title = strings.TrimSpace(*taskIn.Title)
+			if task.Title == "" {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "task title must not be empty"})
+			}
+		}
+		if taskIn.Content != nil {
+			task.Content = strings.TrimSpace(*taskIn.Content)
+			if task.Content == "" {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "task content"})
+			}
+		}
+		loc := user.Location()
+		if taskIn.StartAt != nil {
+			task.StartAt, err = parseTaskTime(strings.TrimSpace(*taskIn.StartAt), loc, false)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid start_at '%s'", *taskIn.StartAt)})
+			}
+		}
+		if taskIn.DueAt != nil {
+			task.DueAt, err = parseTaskTime(strings.TrimSpace(*taskIn.DueAt), loc, true)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid due_at '%s'", *taskIn.DueAt)})
+			}
+		}
+		if task.StartAt != 0 && task.DueAt != 0 && task.StartAt > task.DueAt {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "task must not start after it is due"})
+		}
+
+		// The status may only change along the project's workflow.
+		if taskIn.Status != nil {
+			if status := constants.TaskStatus(strings.TrimSpace(string(*taskIn.Status))); status != "" {
+				task.Status = status
+			}
+		}
+		if task.Status != current.Status {
+			workflow, err := readWorkflow(c, db, pID)
+			if err != nil || len(workflow.States) == 0 {
+				return err
+			}
+			if ok, err := checkTransition(c, workflow, current.Status, task.Status); !ok {
+				return err
+			}
 		}
 
-		task.ProjectID = pID
-		task.ID = tID
 		if err := task.Update(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// The task was either deleted or modified since it was read.
+				if err := current.ReadByID(db); errors.Is(err, sql.ErrNoRows) {
+					return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("task '%d' not found in project '%d'", tID, pID)})
+				}
+				return c.JSON(http.StatusPreconditionFailed, schema.MessageResponse{Message: "task has been modified, read it again before updating"})
+			}
 			log.Println("err updating project: ", err)
 			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "error updating task"})
 		}
+		if task.Content != current.Content {
+			updateMentions(db, model.MentionSource{ProjectID: pID, TaskID: task.ID, AuthorID: user.ID}, task.Content)
+		}
+		notifyTaskChange(db, user.ID, current, task)
+		publishTaskEvent(db, model.WebhookTaskUpdated, task)
+		setETag(c, task.Version)
 		return c.JSON(http.StatusOK, task)
 	})
 }
@@ -136,10 +293,14 @@ func HandleDeleteTask(db *sql.DB) echo.HandlerFunc {
 			return fmt.Errorf("invalid project ID '%s'", taskID)
 		}
 
-		task := model.Task{ID: tID, ProjectID: pID}
+		task, ok := c.Get("task").(model.Task)
+		if !ok {
+			task = model.Task{ID: tID, ProjectID: pID}
+		}
 		if err := task.Delete(db); err != nil {
 			return fmt.Errorf("unable to delete project '%d' task '%d'", pID, tID)
 		}
+		publishTaskEvent(db, model.WebhookTaskDeleted, task)
 
 		return c.JSON(
 			http.StatusNoContent,
@@ -147,3 +308,175 @@ func HandleDeleteTask(db *sql.DB) echo.HandlerFunc {
 		)
 	})
 }
+
+func HandleGetTasks(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("projectID")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		filter := model.TaskFilter{ProjectID: pID, Sort: c.QueryParam("sort")}
+		if filter.Sort == "" {
+			filter.Sort = "id"
+		}
+		if !model.ValidTaskSort(filter.Sort) {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid sort '%s'", filter.Sort)})
+		}
+
+		filter.Status = constants.TaskStatus(strings.TrimSpace(c.QueryParam("status")))
+
+		for _, p := range []struct {
+			name string
+			id   *int
+		}{
+			{"assignee_id", &filter.AssigneeID},
+			{"creator_id", &filter.CreatorID},
+		} {
+			value := c.QueryParam(p.name)
+			if value == "" {
+				continue
+			}
+			id, err := strconv.Atoi(value)
+			if err != nil || id <= 0 {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid %s '%s'", p.name, value)})
+			}
+			*p.id = id
+		}
+
+		filter.Query = strings.TrimSpace(c.QueryParam("q"))
+
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != filter.Sort {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.AfterID = lc.ID
+			filter.AfterValue = lc.Value
+		}
+
+		tasks := model.Tasks{}
+		filter.Limit = limit + 1
+		if err := tasks.List(db, filter); err != nil {
+			log.Println("err listing tasks: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list tasks"})
+		}
+		total, err := tasks.Count(db, filter)
+		if err != nil {
+			log.Println("err counting tasks: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list tasks"})
+		}
+
+		page := schema.Page[model.Task]{Items: tasks, Total: total}
+		if len(tasks) > limit {
+			page.Items = tasks[:limit]
+			last := page.Items[limit-1]
+			lc := listCursor{Sort: filter.Sort, ID: last.ID}
+			switch strings.TrimPrefix(filter.Sort, "-") {
+			case "title":
+				lc.Value = last.Title
+			case "status":
+				lc.Value = string(last.Status)
+			}
+			page.NextCursor, err = utils.EncodeCursor(lc)
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list tasks"})
+			}
+		}
+
+		return c.JSON(http.StatusOK, page)
+	})
+}
+
+// HandleGetOverdueTasks lists the open tasks past their due time in the
+// projects the user is a member of, most overdue first.
+func HandleGetOverdueTasks(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		filter := model.DueTaskFilter{MemberID: user.ID, DueBefore: time.Now().Unix()}
+		return listDueTasks(c, db, filter)
+	})
+}
+
+// HandleGetDueSoonTasks lists the open tasks in the projects the user is a
+// member of that are due from now until the end of the day the given number
+// of days from today in the user's time zone, soonest first.
+func HandleGetDueSoonTasks(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		days := config.DUE_SOON_DAYS
+		if value := c.QueryParam("days"); value != "" {
+			d, err := strconv.Atoi(value)
+			if err != nil || d < 0 || d > config.DUE_SOON_MAX_DAYS {
+				return c.JSON(
+					http.StatusBadRequest,
+					schema.MessageResponse{Message: fmt.Sprintf("days must be between 0 and %d", config.DUE_SOON_MAX_DAYS)},
+				)
+			}
+			days = d
+		}
+
+		now := time.Now()
+		loc := user.Location()
+		year, month, day := now.In(loc).Date()
+		end := time.Date(year, month, day+days+1, 0, 0, 0, 0, loc)
+
+		filter := model.DueTaskFilter{MemberID: user.ID, DueFrom: now.Unix(), DueBefore: end.Unix()}
+		return listDueTasks(c, db, filter)
+	})
+}
+
+func listDueTasks(c echo.Context, db *sql.DB, filter model.DueTaskFilter) error {
+	limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+	}
+
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		lc := listCursor{}
+		if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != "due_at" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+		}
+		due, err := strconv.ParseInt(lc.Value, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+		}
+		filter.AfterID = lc.ID
+		filter.AfterDue = due
+	}
+
+	tasks := model.Tasks{}
+	filter.Limit = limit + 1
+	if err := tasks.ListDue(db, filter); err != nil {
+		log.Println("err listing due tasks: ", err)
+		return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list tasks"})
+	}
+	total, err := tasks.CountDue(db, filter)
+	if err != nil {
+		log.Println("err counting due tasks: ", err)
+		return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list tasks"})
+	}
+
+	page := schema.Page[model.Task]{Items: tasks, Total: total}
+	if len(tasks) > limit {
+		page.Items = tasks[:limit]
+		last := page.Items[limit-1]
+		lc := listCursor{Sort: "due_at", ID: last.ID, Value: strconv.FormatInt(last.DueAt, 10)}
+		page.NextCursor, err = utils.EncodeCursor(lc)
+		if err != nil {
+			log.Println("err encoding cursor: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list tasks"})
+		}
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
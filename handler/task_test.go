@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/labstack/echo/v4"
 	"github.com/tomihaapalainen/go-task-mgmt/assert"
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
 	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
 )
 
 func TestPostCreateTask(t *testing.T) {
@@ -38,7 +43,7 @@ func TestPostCreateTask(t *testing.T) {
 				[]string{fmt.Sprintf("%d", testProject.ID)},
 			)
 			c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-			err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+			err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
 			assert.AssertEq(t, err, nil)
 			assert.AssertEq(t, rec.Code, http.StatusOK)
 			task := model.Task{}
@@ -66,7 +71,7 @@ func TestDeleteTaskShouldPass(t *testing.T) {
 	)
 
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "delete task")(HandleDeleteTask(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "delete task")(mw.TaskPolicyRequired(tDB, "delete task")(HandleDeleteTask(tDB))))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusNoContent)
 }
@@ -82,7 +87,7 @@ func TestReadTaskShouldPass(t *testing.T) {
 		[]string{fmt.Sprintf("%d", testProject.ID), fmt.Sprintf("%d", testTask.ID)},
 	)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "read task")(HandleGetTaskID(tDB)))(c)
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read task")(HandleGetTaskID(tDB)))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusOK)
 	task := model.Task{}
@@ -111,7 +116,374 @@ func TestPatchTaskShouldPass(t *testing.T) {
 		[]string{fmt.Sprintf("%d", testProject.ID), fmt.Sprintf("%d", testTask.ID)},
 	)
 	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
-	err := mw.JwtMiddleware(mw.PermissionRequired(tDB, "update task")(HandlePatchTaskID(tDB)))(c)
+	c.Request().Header.Set("If-Match", "*")
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update task")(mw.TaskPolicyRequired(tDB, "update task")(HandlePatchTaskID(tDB))))(c)
 	assert.AssertEq(t, err, nil)
 	assert.AssertEq(t, rec.Code, http.StatusOK)
 }
+
+func patchTask(t *testing.T, authRes schema.AuthResponse, task model.Task, jsonStr string) (int, model.Task) {
+	rec, c := createContextWithParams(
+		"PATCH",
+		"http://localhost:8080/project/:projectID/task/:id",
+		jsonStr,
+		[]string{"projectID", "id"},
+		[]string{fmt.Sprintf("%d", task.ProjectID), fmt.Sprintf("%d", task.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	c.Request().Header.Set("If-Match", "*")
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update task")(mw.TaskPolicyRequired(tDB, "update task")(HandlePatchTaskID(tDB))))(c)
+	assert.AssertEq(t, err, nil)
+	updated := model.Task{}
+	if rec.Code == http.StatusOK {
+		err = json.NewDecoder(rec.Body).Decode(&updated)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, updated
+}
+
+func TestPatchTaskToDoneShouldSetCompletedAtAndKeepOmittedFields(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "Task to complete", "Content", constants.Doing)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	code, updated := patchTask(t, authRes, task, `{"start_at": "2024-03-01T09:00:00Z", "due_at": "2024-03-08T17:00:00Z"}`)
+	assert.AssertEq(t, code, http.StatusOK)
+	startAt, dueAt := updated.StartAt, updated.DueAt
+	assert.AssertNotEq(t, startAt, int64(0))
+	assert.AssertNotEq(t, dueAt, int64(0))
+
+	code, updated = patchTask(t, authRes, task, `{"status": "done"}`)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, updated.Status, constants.Done)
+	assert.AssertNotEq(t, updated.CompletedAt, int64(0))
+	assert.AssertEq(t, updated.Title, "Task to complete")
+	assert.AssertEq(t, updated.Content, "Content")
+	assert.AssertEq(t, updated.AssigneeID, testUser.ID)
+	assert.AssertEq(t, updated.StartAt, startAt)
+	assert.AssertEq(t, updated.DueAt, dueAt)
+
+	read := model.Task{ID: task.ID, ProjectID: task.ProjectID}
+	err := read.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, read.Status, constants.Done)
+	assert.AssertEq(t, read.CompletedAt, updated.CompletedAt)
+
+	// Clearing a time has to be asked for.
+	code, updated = patchTask(t, authRes, task, `{"status": "doing", "due_at": ""}`)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, updated.CompletedAt, int64(0))
+	assert.AssertEq(t, updated.StartAt, startAt)
+	assert.AssertEq(t, updated.DueAt, int64(0))
+}
+
+func listTasks(t *testing.T, projectID int, query string) (int, schema.Page[model.Task]) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	rec, c := createContextWithParams(
+		"GET",
+		"http://localhost:8080/project/:projectID/tasks?"+query,
+		"",
+		[]string{"projectID"},
+		[]string{fmt.Sprintf("%d", projectID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read task")(HandleGetTasks(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	page := schema.Page[model.Task]{}
+	if rec.Code == http.StatusOK {
+		err = json.NewDecoder(rec.Body).Decode(&page)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, page
+}
+
+func TestGetTasksShouldFilterAndPaginate(t *testing.T) {
+	p := createTestProject("Task listing project", testProjectManager.ID)
+	addTestProjectMember(p.ID, testUser.ID, constants.ProjectViewer)
+	for _, task := range []model.Task{
+		{AssigneeID: testUser.ID, CreatorID: testProjectManager.ID, Title: "Write docs", Content: "Document the API", Status: constants.Todo},
+		{AssigneeID: testUser.ID, CreatorID: testUser.ID, Title: "Fix login", Content: "50% of logins fail", Status: constants.Doing},
+		{AssigneeID: testProjectManager.ID, CreatorID: testProjectManager.ID, Title: "Plan sprint", Content: "Pick tasks", Status: constants.Todo},
+		{AssigneeID: testUser.ID, CreatorID: testProjectManager.ID, Title: "Release", Content: "Tag the release", Status: constants.Done},
+	} {
+		task.ProjectID = p.ID
+		err := task.Create(tDB)
+		assert.AssertEq(t, err, nil)
+	}
+
+	testCases := []struct {
+		query  string
+		titles []string
+	}{
+		{"", []string{"Write docs", "Fix login", "Plan sprint", "Release"}},
+		{"status=todo", []string{"Write docs", "Plan sprint"}},
+		{fmt.Sprintf("assignee_id=%d&sort=-title", testUser.ID), []string{"Write docs", "Release", "Fix login"}},
+		{fmt.Sprintf("creator_id=%d", testUser.ID), []string{"Fix login"}},
+		{"q=release", []string{"Release"}},
+		{"q=50%25", []string{"Fix login"}},
+		{"q=docs&status=done", []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			titles := []string{}
+			cursor := ""
+			for i := 0; i <= len(tc.titles); i++ {
+				code, page := listTasks(t, p.ID, tc.query+"&limit=1&cursor="+cursor)
+				assert.AssertEq(t, code, http.StatusOK)
+				assert.AssertEq(t, page.Total, len(tc.titles))
+				for _, task := range page.Items {
+					assert.AssertEq(t, task.ProjectID, p.ID)
+					titles = append(titles, task.Title)
+				}
+				cursor = page.NextCursor
+				if cursor == "" {
+					break
+				}
+			}
+			assert.AssertEq(t, strings.Join(titles, ","), strings.Join(tc.titles, ","))
+		})
+	}
+}
+
+func TestGetTasksWithInvalidQueryShouldFail(t *testing.T) {
+	for _, query := range []string{"sort=content", "assignee_id=0", "creator_id=x", "limit=-1", "cursor=invalid"} {
+		t.Run(query, func(t *testing.T) {
+			code, _ := listTasks(t, testProject.ID, query)
+			assert.AssertEq(t, code, http.StatusBadRequest)
+		})
+	}
+}
+
+func mutateTask(t *testing.T, authRes schema.AuthResponse, method string, task model.Task) *httptest.ResponseRecorder {
+	jsonStr := ""
+	h := HandleDeleteTask(tDB)
+	permission := "delete task"
+	if method == "PATCH" {
+		jsonStr = fmt.Sprintf(
+			`{"assignee_id": %d, "title": "Updated by policy test", "content": "Updated content", "status": "doing"}`,
+			task.AssigneeID,
+		)
+		h = HandlePatchTaskID(tDB)
+		permission = "update task"
+	}
+	rec, c := createContextWithParams(
+		method,
+		"http://localhost:8080/project/:projectID/task/:id",
+		jsonStr,
+		[]string{"projectID", "id"},
+		[]string{fmt.Sprintf("%d", task.ProjectID), fmt.Sprintf("%d", task.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	if method == "PATCH" {
+		c.Request().Header.Set("If-Match", fmt.Sprintf(`"%d"`, task.Version))
+	}
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, permission)(mw.TaskPolicyRequired(tDB, permission)(h)))(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func TestMutateOthersTaskShouldFail(t *testing.T) {
+	task := createTestTask(testProjectManager.ID, testProjectManager.ID, "Project manager's task", "Content", constants.Todo)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	for _, method := range []string{"PATCH", "DELETE"} {
+		t.Run(method, func(t *testing.T) {
+			rec := mutateTask(t, authRes, method, task)
+			assert.AssertEq(t, rec.Code, http.StatusForbidden)
+			m := schema.MessageResponse{}
+			err := json.NewDecoder(rec.Body).Decode(&m)
+			assert.AssertEq(t, err, nil)
+			if !strings.Contains(m.Message, "user did not create the task") {
+				t.Fatalf("'%s' did not contain the reason", m.Message)
+			}
+		})
+	}
+}
+
+func TestMutateAssignedTaskShouldPass(t *testing.T) {
+	task := createTestTask(testUser.ID, testProjectManager.ID, "Task assigned to user", "Content", constants.Todo)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	rec := mutateTask(t, authRes, "PATCH", task)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	rec = mutateTask(t, authRes, "DELETE", task)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+}
+
+func TestMutateTaskAsProjectManagerShouldPass(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "User's task", "Content", constants.Todo)
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	rec := mutateTask(t, authRes, "PATCH", task)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	rec = mutateTask(t, authRes, "DELETE", task)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+}
+
+func TestMutateMissingTaskShouldFail(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	rec := mutateTask(t, authRes, "DELETE", model.Task{ID: 1000000, ProjectID: testProject.ID})
+	assert.AssertEq(t, rec.Code, http.StatusNotFound)
+}
+
+func createDueTestTask(t *testing.T, projectID, userID int, title string, status constants.TaskStatus, due time.Time) model.Task {
+	task := model.Task{
+		ProjectID:  projectID,
+		AssigneeID: userID,
+		CreatorID:  userID,
+		Title:      title,
+		Content:    "Content",
+		Status:     status,
+		DueAt:      due.Unix(),
+	}
+	err := task.Create(tDB)
+	assert.AssertEq(t, err, nil)
+	return task
+}
+
+func listDueTasksAs(t *testing.T, authRes schema.AuthResponse, h echo.HandlerFunc, query string) (int, schema.Page[model.Task]) {
+	rec, c := createContext("GET", "http://localhost:8080/tasks/due?"+query, "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "read task")(h))(c)
+	assert.AssertEq(t, err, nil)
+	page := schema.Page[model.Task]{}
+	if rec.Code == http.StatusOK {
+		err = json.NewDecoder(rec.Body).Decode(&page)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, page
+}
+
+func taskTitles(tasks []model.Task) []string {
+	titles := []string{}
+	for _, task := range tasks {
+		titles = append(titles, task.Title)
+	}
+	return titles
+}
+
+func TestDueTasksShouldUseUserTimezone(t *testing.T) {
+	userIn, user := createTestUserWithRole("duedates@example.com", "Testpass1", constants.UserRoleID)
+	user.Timezone = "Pacific/Kiritimati"
+	err := user.UpdateProfile(tDB)
+	assert.AssertEq(t, err, nil)
+	project := createTestProject("Due dates", testAdmin.ID)
+	addTestProjectMember(project.ID, user.ID, constants.ProjectContributor)
+	other := createTestProject("Not a member", testAdmin.ID)
+
+	now := time.Now()
+	year, month, day := now.In(user.Location()).Date()
+	tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, user.Location())
+	createDueTestTask(t, project.ID, user.ID, "Late", constants.Todo, now.Add(-2*time.Hour))
+	createDueTestTask(t, project.ID, user.ID, "Later", constants.Doing, now.Add(-time.Hour))
+	createDueTestTask(t, project.ID, user.ID, "Finished", constants.Done, now.Add(-time.Hour))
+	createDueTestTask(t, other.ID, testAdmin.ID, "Someone else's", constants.Todo, now.Add(-time.Hour))
+	createDueTestTask(t, project.ID, user.ID, "Tonight", constants.Todo, tomorrow.Add(-time.Minute))
+	createDueTestTask(t, project.ID, user.ID, "Tomorrow", constants.Todo, tomorrow.Add(time.Minute))
+	createDueTestTask(t, project.ID, user.ID, "Next week", constants.Todo, tomorrow.AddDate(0, 0, 7))
+
+	authRes := login(t, userIn.Email, userIn.Password)
+
+	code, page := listDueTasksAs(t, authRes, HandleGetOverdueTasks(tDB), "limit=1")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, page.Total, 2)
+	assert.AssertEq(t, strings.Join(taskTitles(page.Items), ","), "Late")
+	code, page = listDueTasksAs(t, authRes, HandleGetOverdueTasks(tDB), "limit=1&cursor="+page.NextCursor)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, strings.Join(taskTitles(page.Items), ","), "Later")
+	assert.AssertEq(t, page.NextCursor, "")
+
+	for _, tc := range []struct {
+		query  string
+		titles string
+	}{
+		{"days=0", "Tonight"},
+		{"", "Tonight,Tomorrow"},
+		{"days=8", "Tonight,Tomorrow,Next week"},
+	} {
+		code, page = listDueTasksAs(t, authRes, HandleGetDueSoonTasks(tDB), tc.query)
+		assert.AssertEq(t, code, http.StatusOK)
+		assert.AssertEq(t, strings.Join(taskTitles(page.Items), ","), tc.titles)
+	}
+	code, _ = listDueTasksAs(t, authRes, HandleGetDueSoonTasks(tDB), "days=-1")
+	assert.AssertEq(t, code, http.StatusBadRequest)
+}
+
+func TestCreateTaskWithDatesShouldPass(t *testing.T) {
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+	create := func(fields string) (int, model.Task) {
+		rec, c := createContextWithParams(
+			"POST",
+			"http://localhost:8080/project/:projectID/task/create",
+			fmt.Sprintf(`{"assignee_id": %d, %s}`, testUser.ID, fields),
+			[]string{"projectID"},
+			[]string{fmt.Sprintf("%d", testProject.ID)},
+		)
+		c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+		err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+		assert.AssertEq(t, err, nil)
+		task := model.Task{}
+		if rec.Code == http.StatusOK {
+			err = json.NewDecoder(rec.Body).Decode(&task)
+			assert.AssertEq(t, err, nil)
+		}
+		return rec.Code, task
+	}
+
+	// Dates are taken in the user's time zone, UTC for the test user.
+	code, task := create(`"title": "Dated", "content": "Content", "status": "todo", "start_at": "2024-03-01", "due_at": "2024-03-02"`)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, task.StartAt, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix())
+	assert.AssertEq(t, task.DueAt, time.Date(2024, 3, 2, 23, 59, 59, 0, time.UTC).Unix())
+	assert.AssertEq(t, task.CompletedAt, int64(0))
+
+	code, task = create(`"title": "Done", "content": "Content", "status": "done", "due_at": "2024-03-02T12:00:00+02:00"`)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, task.DueAt, time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC).Unix())
+	assert.AssertNotEq(t, task.CompletedAt, int64(0))
+
+	code, _ = create(`"title": "Backwards", "content": "Content", "status": "todo", "start_at": "2024-03-03", "due_at": "2024-03-02"`)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+	code, _ = create(`"title": "Invalid", "content": "Content", "status": "todo", "due_at": "tomorrow"`)
+	assert.AssertEq(t, code, http.StatusBadRequest)
+}
+
+func TestPatchTaskWithStaleVersionShouldFail(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "Concurrently edited task", "Content", constants.Todo)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	rec, c := createContextWithParams(
+		"GET",
+		"http://localhost:8080/project/:projectID/task/:id",
+		"",
+		[]string{"projectID", "id"},
+		[]string{fmt.Sprintf("%d", task.ProjectID), fmt.Sprintf("%d", task.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read task")(HandleGetTaskID(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	etag := rec.Header().Get("ETag")
+	assert.AssertEq(t, etag, `"1"`)
+
+	rec = mutateTask(t, authRes, "PATCH", task)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	assert.AssertEq(t, rec.Header().Get("ETag"), `"2"`)
+	updated := model.Task{}
+	err = json.NewDecoder(rec.Body).Decode(&updated)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, updated.Version, 2)
+	assert.AssertEq(t, updated.CreatedAt, task.CreatedAt)
+
+	// A second writer still holding the first version is refused.
+	rec = mutateTask(t, authRes, "PATCH", task)
+	assert.AssertEq(t, rec.Code, http.StatusPreconditionFailed)
+	task.Version = 0
+	rec = mutateTask(t, authRes, "PATCH", task)
+	assert.AssertEq(t, rec.Code, http.StatusPreconditionFailed)
+
+	// A deleted task is missing rather than modified.
+	err = task.Delete(tDB)
+	assert.AssertEq(t, err, nil)
+	code, _ := patchTask(t, authRes, task, `{"title": "Too late"}`)
+	assert.AssertEq(t, code, http.StatusNotFound)
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/totp"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns n random recovery codes formatted for
+// display, and their hashes for storing.
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes = append(codes, s[0:4]+"-"+s[4:8]+"-"+s[8:12]+"-"+s[12:16])
+		hashes = append(hashes, utils.HashToken(s))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code as entered by the user, ignoring
+// case, dashes and spaces.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return utils.HashToken(code)
+}
+
+// checkTOTP reports whether code is a valid TOTP code of the user, set up
+// or enabled, and records its time step so that it cannot be used again.
+func checkTOTP(db *sql.DB, userID int, code string, now time.Time) (bool, error) {
+	t := model.UserTOTP{UserID: userID}
+	if err := t.Read(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	counter, ok := totp.Validate(t.Secret, code, now, config.TOTP_SKEW)
+	if !ok {
+		return false, nil
+	}
+	if err := t.UseCounter(db, counter); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// checkSecondFactor checks a TOTP code, or a recovery code if code is
+// empty, and uses it up.
+func checkSecondFactor(db *sql.DB, user model.User, code, recoveryCode string, now time.Time) (bool, error) {
+	if code != "" {
+		return checkTOTP(db, user.ID, code, now)
+	}
+	if recoveryCode == "" {
+		return false, nil
+	}
+	if err := user.UseRecoveryCode(db, hashRecoveryCode(recoveryCode), now.Unix()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func auditTwoFactor(db *sql.DB, actorID int, action string, user model.User) {
+	audit := model.AuditEntry{
+		CreatedAt: time.Now().Unix(),
+		ActorID:   actorID,
+		Action:    action,
+		Subject:   accountThrottleKey(user.Email),
+	}
+	if err := audit.Create(db); err != nil {
+		log.Println("err creating audit entry: ", err)
+	}
+}
+
+// HandlePostTwoFactorSetup generates a new TOTP secret for the user. Two-factor
+// authentication is enabled once the user confirms the secret with a code
+// through HandlePostTwoFactorEnable.
+func HandlePostTwoFactorSetup(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		passwordIn := schema.PasswordIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&passwordIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !utils.CheckPassword(user.PasswordHash, passwordIn.Password) {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "current password is incorrect"})
+		}
+		if user.TwoFactorEnabled() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "two-factor authentication is already enabled"})
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			log.Println("err generating totp secret: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to set up two-factor authentication"})
+		}
+		t := model.UserTOTP{UserID: user.ID, Secret: secret, CreatedAt: time.Now().Unix()}
+		if err := t.Create(db); err != nil {
+			log.Println("err creating totp secret: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to set up two-factor authentication"})
+		}
+		return c.JSON(http.StatusOK, schema.TwoFactorSetup{
+			Secret: secret,
+			URI:    totp.URI(config.TOTP_ISSUER, user.Email, secret),
+		})
+	})
+}
+
+// HandlePostTwoFactorEnable enables two-factor authentication once the user
+// proves they have set up the secret, and responds with the recovery codes.
+// The user's other sessions are logged out.
+func HandlePostTwoFactorEnable(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		codeIn := schema.TwoFactorCodeIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&codeIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if user.TwoFactorEnabled() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "two-factor authentication is already enabled"})
+		}
+
+		now := time.Now()
+		ok, err := checkTOTP(db, user.ID, codeIn.Code, now)
+		if err != nil {
+			log.Println("err checking totp code: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to enable two-factor authentication"})
+		}
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid two-factor code"})
+		}
+
+		codes, hashes, err := generateRecoveryCodes(config.RECOVERY_CODE_COUNT)
+		if err != nil {
+			log.Println("err generating recovery codes: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to enable two-factor authentication"})
+		}
+		if err := user.EnableTwoFactor(db, hashes, now.Unix()); err != nil {
+			log.Println("err enabling two-factor authentication: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to enable two-factor authentication"})
+		}
+		mw.ForgetUser(user.ID)
+		if err := user.RevokeOtherSessions(db, c.Get("session_id").(string)); err != nil {
+			log.Println("err revoking sessions: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to revoke sessions"})
+		}
+		auditTwoFactor(db, user.ID, model.AuditTwoFactorEnable, user)
+		return c.JSON(http.StatusOK, schema.RecoveryCodes{Codes: codes})
+	})
+}
+
+// HandlePostTwoFactorRecoveryCodes replaces the user's recovery codes.
+func HandlePostTwoFactorRecoveryCodes(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		codeIn := schema.TwoFactorCodeIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&codeIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !user.TwoFactorEnabled() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "two-factor authentication is not enabled"})
+		}
+		ok, err := checkTOTP(db, user.ID, codeIn.Code, time.Now())
+		if err != nil {
+			log.Println("err checking totp code: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to replace recovery codes"})
+		}
+		if !ok {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "invalid two-factor code"})
+		}
+
+		codes, hashes, err := generateRecoveryCodes(config.RECOVERY_CODE_COUNT)
+		if err != nil {
+			log.Println("err generating recovery codes: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to replace recovery codes"})
+		}
+		if err := user.ReplaceRecoveryCodes(db, hashes); err != nil {
+			log.Println("err replacing recovery codes: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to replace recovery codes"})
+		}
+		return c.JSON(http.StatusOK, schema.RecoveryCodes{Codes: codes})
+	})
+}
+
+// HandlePostTwoFactorDisable turns off two-factor authentication with the
+// user's password and a current code. Users whose role requires two-factor
+// authentication cannot turn it off.
+func HandlePostTwoFactorDisable(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		disableIn := schema.TwoFactorDisableIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&disableIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !user.TwoFactorEnabled() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "two-factor authentication is not enabled"})
+		}
+		if !utils.CheckPassword(user.PasswordHash, disableIn.Password) {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "current password is incorrect"})
+		}
+		required, err := model.TwoFactorRequired(db, user.RoleID)
+		if err != nil {
+			log.Println("err reading role two-factor policy: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to disable two-factor authentication"})
+		}
+		if required {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "two-factor authentication is required for the role of the user"})
+		}
+		ok, err := checkTOTP(db, user.ID, disableIn.Code, time.Now())
+		if err != nil {
+			log.Println("err checking totp code: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to disable two-factor authentication"})
+		}
+		if !ok {
+			return c.JSON(http.StatusForbidden, schema.MessageResponse{Message: "invalid two-factor code"})
+		}
+
+		if err := user.DisableTwoFactor(db); err != nil {
+			log.Println("err disabling two-factor authentication: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to disable two-factor authentication"})
+		}
+		mw.ForgetUser(user.ID)
+		auditTwoFactor(db, user.ID, model.AuditTwoFactorDisable, user)
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// HandleDeleteUserTwoFactor turns off two-factor authentication of a user
+// who has lost both their device and their recovery codes. If their role
+// requires it, they must enable it again before using the API.
+func HandleDeleteUserTwoFactor(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		caller := c.Get("user").(model.User)
+		user, err := readTargetUser(c, db)
+		if err != nil || user.ID == 0 {
+			return err
+		}
+		if !user.TwoFactorEnabled() {
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{Message: fmt.Sprintf("user '%d' does not have two-factor authentication enabled", user.ID)})
+		}
+
+		if err := user.DisableTwoFactor(db); err != nil {
+			log.Println("err disabling two-factor authentication: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to reset two-factor authentication"})
+		}
+		mw.ForgetUser(user.ID)
+		auditTwoFactor(db, caller.ID, model.AuditTwoFactorReset, user)
+		return c.NoContent(http.StatusNoContent)
+	})
+}
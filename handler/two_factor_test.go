@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/totp"
+)
+
+// totpCode returns the code of secret offset time steps from now. Tests
+// widen config.TOTP_SKEW so that codes of consecutive steps can be used
+// without waiting for them.
+func totpCode(t *testing.T, secret string, offset int64) string {
+	code, err := totp.Code(secret, totp.Counter(time.Now())+offset)
+	assert.AssertEq(t, err, nil)
+	return code
+}
+
+// enrollTwoFactor enables two-factor authentication for the user with the
+// code of the previous time step and returns the secret and recovery codes.
+func enrollTwoFactor(t *testing.T, authRes schema.AuthResponse, password string) (string, []string) {
+	rec := meRequest(t, authRes, "POST", fmt.Sprintf(`{"password": "%s"}`, password), HandlePostTwoFactorSetup(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	setup := schema.TwoFactorSetup{}
+	err := json.NewDecoder(rec.Body).Decode(&setup)
+	assert.AssertEq(t, err, nil)
+	if !strings.HasPrefix(setup.URI, "otpauth://totp/") {
+		t.Fatalf("unexpected otpauth URI '%s'", setup.URI)
+	}
+
+	jsonStr := fmt.Sprintf(`{"code": "%s"}`, totpCode(t, setup.Secret, -1))
+	rec = meRequest(t, authRes, "POST", jsonStr, HandlePostTwoFactorEnable(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	codes := schema.RecoveryCodes{}
+	err = json.NewDecoder(rec.Body).Decode(&codes)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(codes.Codes), config.RECOVERY_CODE_COUNT)
+	return setup.Secret, codes.Codes
+}
+
+func loginTwoFactor(t *testing.T, ip, token, code, recoveryCode string) *httptest.ResponseRecorder {
+	jsonStr := fmt.Sprintf(`{"token": "%s", "code": "%s", "recovery_code": "%s"}`, token, code, recoveryCode)
+	rec, c := createContext("POST", "http://localhost:8080/auth/login/two-factor", jsonStr)
+	c.Request().Header.Set("X-Real-IP", ip)
+	err := HandlePostLogInTwoFactor(tDB)(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func twoFactorChallenge(t *testing.T, ip, email, password string) schema.TwoFactorChallenge {
+	rec := loginFrom(t, ip, email, password)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	challenge := schema.TwoFactorChallenge{}
+	err := json.NewDecoder(rec.Body).Decode(&challenge)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, challenge.TwoFactorRequired, true)
+	return challenge
+}
+
+func TestTwoFactorLoginShouldPass(t *testing.T) {
+	skew := config.TOTP_SKEW
+	config.TOTP_SKEW = 2
+	defer func() { config.TOTP_SKEW = skew }()
+
+	userIn, _ := createTestUserWithRole("totp@example.com", "Testpass1", constants.UserRoleID)
+	ip := "198.51.100.10"
+	authRes := login(t, userIn.Email, userIn.Password)
+
+	rec := meRequest(t, authRes, "POST", `{"password": "Wrongpass1"}`, HandlePostTwoFactorSetup(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+	rec = meRequest(t, authRes, "POST", `{"code": "000000"}`, HandlePostTwoFactorEnable(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	secret, recoveryCodes := enrollTwoFactor(t, authRes, userIn.Password)
+
+	challenge := twoFactorChallenge(t, ip, userIn.Email, userIn.Password)
+	// The code used to enable two-factor authentication cannot be reused.
+	rec = loginTwoFactor(t, ip, challenge.TwoFactorToken, totpCode(t, secret, -1), "")
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+	rec = loginTwoFactor(t, ip, challenge.TwoFactorToken, totpCode(t, secret, 0), "")
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	res := schema.AuthResponse{}
+	err := json.NewDecoder(rec.Body).Decode(&res)
+	assert.AssertEq(t, err, nil)
+	if res.AccessToken == "" {
+		t.Fatal("expected an access token")
+	}
+
+	challenge = twoFactorChallenge(t, ip, userIn.Email, userIn.Password)
+	recoveryCode := strings.ToUpper(recoveryCodes[0])
+	rec = loginTwoFactor(t, ip, challenge.TwoFactorToken, "", recoveryCode)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	rec = loginTwoFactor(t, ip, challenge.TwoFactorToken, "", recoveryCode)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+
+	jsonStr := fmt.Sprintf(`{"password": "%s", "code": "%s"}`, userIn.Password, totpCode(t, secret, 1))
+	rec = meRequest(t, authRes, "POST", jsonStr, HandlePostTwoFactorDisable(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	rec = loginFrom(t, ip, userIn.Email, userIn.Password)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	res = schema.AuthResponse{}
+	err = json.NewDecoder(rec.Body).Decode(&res)
+	assert.AssertEq(t, err, nil)
+	if res.AccessToken == "" {
+		t.Fatal("expected an access token")
+	}
+}
+
+func TestTwoFactorTokenShouldNotBeAccessToken(t *testing.T) {
+	skew := config.TOTP_SKEW
+	config.TOTP_SKEW = 2
+	defer func() { config.TOTP_SKEW = skew }()
+
+	userIn, _ := createTestUserWithRole("totp-token@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	enrollTwoFactor(t, authRes, userIn.Password)
+
+	challenge := twoFactorChallenge(t, "198.51.100.11", userIn.Email, userIn.Password)
+	code := getProjectsAs(t, schema.AuthResponse{TokenType: "Bearer", AccessToken: challenge.TwoFactorToken})
+	assert.AssertEq(t, code, http.StatusUnauthorized)
+}
+
+func TestRoleTwoFactorPolicyShouldRequireEnrollment(t *testing.T) {
+	skew := config.TOTP_SKEW
+	config.TOTP_SKEW = 2
+	defer func() { config.TOTP_SKEW = skew }()
+
+	role := model.Role{Name: "two-factor reader"}
+	err := role.Create(tDB, []int{int(constants.ReadProject)})
+	assert.AssertEq(t, err, nil)
+	userIn, user := createTestUserWithRole("totp-policy@example.com", "Testpass1", role.ID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	assert.AssertEq(t, getProjectsAs(t, authRes), http.StatusOK)
+
+	adminRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	roleID := fmt.Sprintf("%d", role.ID)
+	rec := roleRequest(t, adminRes, "PUT", `{"required": true}`, []string{roleID}, HandlePutRoleTwoFactor(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	err = json.NewDecoder(rec.Body).Decode(&role)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, role.TwoFactorRequired, true)
+	assert.AssertEq(t, getProjectsAs(t, authRes), http.StatusForbidden)
+
+	secret, _ := enrollTwoFactor(t, authRes, userIn.Password)
+	assert.AssertEq(t, getProjectsAs(t, authRes), http.StatusOK)
+
+	jsonStr := fmt.Sprintf(`{"password": "%s", "code": "%s"}`, userIn.Password, totpCode(t, secret, 0))
+	rec = meRequest(t, authRes, "POST", jsonStr, HandlePostTwoFactorDisable(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	rec = userRequest(
+		t, adminRes, "DELETE", "http://localhost:8080/user/:id/two-factor",
+		[]string{fmt.Sprintf("%d", user.ID)}, HandleDeleteUserTwoFactor(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+	assert.AssertEq(t, getProjectsAs(t, authRes), http.StatusForbidden)
+
+	entries := model.AuditEntries{}
+	err = entries.List(tDB, model.AuditFilter{Action: model.AuditTwoFactorReset, Subject: accountThrottleKey(userIn.Email), Limit: 10})
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(entries), 1)
+	assert.AssertEq(t, entries[0].ActorID, testAdmin.ID)
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+func HandleGetUsers(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		filter := model.UserFilter{Sort: c.QueryParam("sort")}
+		if filter.Sort == "" {
+			filter.Sort = "id"
+		}
+		if !model.ValidUserSort(filter.Sort) {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid sort '%s'", filter.Sort)})
+		}
+
+		filter.Query = strings.TrimSpace(c.QueryParam("q"))
+
+		if roleID := c.QueryParam("role_id"); roleID != "" {
+			id, err := strconv.Atoi(roleID)
+			if err != nil || id <= 0 {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid role ID '%s'", roleID)})
+			}
+			filter.RoleID = constants.RoleID(id)
+		}
+
+		if deactivated := c.QueryParam("deactivated"); deactivated != "" {
+			b, err := strconv.ParseBool(deactivated)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid deactivated flag '%s'", deactivated)})
+			}
+			filter.Deactivated = sql.NullBool{Bool: b, Valid: true}
+		}
+
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != filter.Sort {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.AfterID = lc.ID
+			filter.AfterValue = lc.Value
+		}
+
+		users := model.Users{}
+		filter.Limit = limit + 1
+		if err := users.List(db, filter); err != nil {
+			log.Println("err listing users: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list users"})
+		}
+		total, err := users.Count(db, filter)
+		if err != nil {
+			log.Println("err counting users: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list users"})
+		}
+
+		page := schema.Page[model.User]{Items: users, Total: total}
+		if len(users) > limit {
+			page.Items = users[:limit]
+			last := page.Items[limit-1]
+			lc := listCursor{Sort: filter.Sort, ID: last.ID}
+			if strings.TrimPrefix(filter.Sort, "-") == "email" {
+				lc.Value = last.Email
+			}
+			page.NextCursor, err = utils.EncodeCursor(lc)
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list users"})
+			}
+		}
+
+		return c.JSON(http.StatusOK, page)
+	})
+}
+
+// readTargetUser reads the user named by the "id" route parameter.
+func readTargetUser(c echo.Context, db *sql.DB) (model.User, error) {
+	userID := c.Param("id")
+	uID, err := strconv.Atoi(userID)
+	if err != nil || uID <= 0 {
+		return model.User{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid user ID '%s'", userID)})
+	}
+	user := model.User{ID: uID}
+	if err := user.ReadByID(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("user '%d' not found", uID)})
+		}
+		log.Println("err reading user by id: ", err)
+		return model.User{}, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read user"})
+	}
+	return user, nil
+}
+
+// checkUserRemoval responds with an error if the caller may not deactivate
+// or delete target: users cannot remove themselves and the last admin
+// cannot be removed. ok is false if a response was written.
+func checkUserRemoval(c echo.Context, db *sql.DB, target model.User) (bool, error) {
+	caller := c.Get("user").(model.User)
+	if caller.ID == target.ID {
+		return false, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "users cannot deactivate or delete themselves"})
+	}
+	if !target.Active() {
+		return true, nil
+	}
+	permissions := model.Permissions{}
+	if err := permissions.ReadRolePermissions(db, target.RoleID); err != nil {
+		log.Println("err reading role permissions: ", err)
+		return false, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read role permissions"})
+	}
+	if !permissions.Has("all") {
+		return true, nil
+	}
+	last, err := removesLastAdmin(db, 1)
+	if err != nil {
+		log.Println("err counting admins: ", err)
+		return false, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to count admins"})
+	}
+	if last {
+		return false, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "there must be at least one admin"})
+	}
+	return true, nil
+}
+
+func HandleGetUserID(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user, err := readTargetUser(c, db)
+		if err != nil || user.ID == 0 {
+			return err
+		}
+		setETag(c, user.Version)
+		return c.JSON(http.StatusOK, user)
+	})
+}
+
+// HandlePostDeactivateUser blocks the user from logging in and revokes
+// their sessions and access tokens.
+func HandlePostDeactivateUser(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user, err := readTargetUser(c, db)
+		if err != nil || user.ID == 0 {
+			return err
+		}
+		if !user.Active() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("user '%d' is already deactivated", user.ID)})
+		}
+		if ok, err := checkUserRemoval(c, db, user); !ok {
+			return err
+		}
+
+		if err := user.SetDeactivated(db, true); err != nil {
+			log.Println("err deactivating user: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to deactivate user"})
+		}
+		if err := user.RevokeSessions(db); err != nil {
+			log.Println("err revoking sessions: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to revoke sessions"})
+		}
+		mw.ForgetUser(user.ID)
+		return c.JSON(http.StatusOK, user)
+	})
+}
+
+func HandlePostReactivateUser(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user, err := readTargetUser(c, db)
+		if err != nil || user.ID == 0 {
+			return err
+		}
+		if user.Active() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("user '%d' is not deactivated", user.ID)})
+		}
+
+		if err := user.SetDeactivated(db, false); err != nil {
+			log.Println("err reactivating user: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to reactivate user"})
+		}
+		mw.ForgetUser(user.ID)
+		return c.JSON(http.StatusOK, user)
+	})
+}
+
+// HandleDeleteUser deletes the user. Projects that would be left without an
+// owner and the user's tasks, both as creator and as assignee, are handed
+// over to the active user given in the "transfer_to" query parameter. The
+// parameter is required if the user has any such projects or tasks.
+func HandleDeleteUser(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user, err := readTargetUser(c, db)
+		if err != nil || user.ID == 0 {
+			return err
+		}
+		if ok, err := checkUserRemoval(c, db, user); !ok {
+			return err
+		}
+
+		transferTo := 0
+		if param := c.QueryParam("transfer_to"); param != "" {
+			transferTo, err = strconv.Atoi(param)
+			if err != nil || transferTo <= 0 {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid transfer user ID '%s'", param)})
+			}
+			if transferTo == user.ID {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "cannot transfer to the deleted user"})
+			}
+			target := model.User{ID: transferTo}
+			if err := target.ReadByID(db); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("user '%d' does not exist", transferTo)})
+				}
+				log.Println("err reading user by id: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read user"})
+			}
+			if !target.Active() {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("user '%d' is deactivated", transferTo)})
+			}
+		}
+
+		projectIDs, tasks, err := user.Orphans(db)
+		if err != nil {
+			log.Println("err reading user projects and tasks: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to delete user"})
+		}
+		if transferTo == 0 && (len(projectIDs) > 0 || tasks > 0) {
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{
+					Message: fmt.Sprintf(
+						"user '%d' owns %d projects and has %d tasks, 'transfer_to' is required",
+						user.ID, len(projectIDs), tasks)})
+		}
+
+		if err := user.Delete(db, projectIDs, transferTo); err != nil {
+			log.Println("err deleting user: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to delete user"})
+		}
+		mw.ForgetUser(user.ID)
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// HandlePostUnlockUser lifts the login backoff and lockout of the user's
+// account.
+func HandlePostUnlockUser(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		caller := c.Get("user").(model.User)
+		user, err := readTargetUser(c, db)
+		if err != nil || user.ID == 0 {
+			return err
+		}
+
+		throttle := model.LoginThrottle{Key: accountThrottleKey(user.Email)}
+		if err := throttle.Delete(db); err != nil {
+			log.Println("err resetting login throttle: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to unlock user"})
+		}
+		audit := model.AuditEntry{
+			CreatedAt: time.Now().Unix(),
+			ActorID:   caller.ID,
+			Action:    model.AuditLoginUnlock,
+			Subject:   throttle.Key,
+		}
+		if err := audit.Create(db); err != nil {
+			log.Println("err creating audit entry: ", err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
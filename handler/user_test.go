@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func userRequest(t *testing.T, authRes schema.AuthResponse, method, url string, params []string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	names := []string{"id"}[:len(params)]
+	return authRequest(t, authRes, method, url, "", names, params, h, mw.PermissionRequired(tDB, "manage users"))
+}
+
+func TestGetUsersShouldPass(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	for i := 0; i < 3; i++ {
+		createTestUserWithRole(fmt.Sprintf("listed%d@example.org", i), "Testpass1", constants.UserRoleID)
+	}
+
+	rec := userRequest(t, authRes, "GET", "http://localhost:8080/user?q=example.org&sort=-email&limit=2", nil, HandleGetUsers(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	page := schema.Page[model.User]{}
+	err := json.NewDecoder(rec.Body).Decode(&page)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, page.Total, 3)
+	assert.AssertEq(t, len(page.Items), 2)
+	assert.AssertEq(t, page.Items[0].Email, "listed2@example.org")
+	assert.AssertNotEq(t, page.NextCursor, "")
+
+	rec = userRequest(
+		t, authRes, "GET",
+		"http://localhost:8080/user?q=example.org&sort=-email&limit=2&cursor="+page.NextCursor,
+		nil, HandleGetUsers(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	page = schema.Page[model.User]{}
+	err = json.NewDecoder(rec.Body).Decode(&page)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(page.Items), 1)
+	assert.AssertEq(t, page.Items[0].Email, "listed0@example.org")
+	assert.AssertEq(t, page.NextCursor, "")
+}
+
+func TestGetUsersWithoutPermissionShouldFail(t *testing.T) {
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	rec := userRequest(t, authRes, "GET", "http://localhost:8080/user", nil, HandleGetUsers(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+}
+
+func TestDeactivateUserShouldPass(t *testing.T) {
+	userIn, user := createTestUserWithRole("deactivated@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	userAuthRes := login(t, userIn.Email, userIn.Password)
+	id := fmt.Sprintf("%d", user.ID)
+
+	rec := userRequest(t, authRes, "POST", "http://localhost:8080/user/:id/deactivate", []string{id}, HandlePostDeactivateUser(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	u := model.User{}
+	err := json.NewDecoder(rec.Body).Decode(&u)
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, u.DeactivatedAt, int64(0))
+
+	rec, c := createContext("POST", "http://localhost:8080/auth/logout/all", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", userAuthRes.TokenType, userAuthRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(HandlePostLogOutAll(tDB))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusUnauthorized)
+
+	jsonStr := fmt.Sprintf(`{"email": "%s", "password": "%s"}`, userIn.Email, userIn.Password)
+	rec, c = createContext("POST", "http://localhost:8080/auth/login", jsonStr)
+	err = HandlePostLogIn(tDB)(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusForbidden)
+
+	rec = userRequest(t, authRes, "POST", "http://localhost:8080/user/:id/reactivate", []string{id}, HandlePostReactivateUser(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	login(t, userIn.Email, userIn.Password)
+}
+
+func TestDeactivateSelfShouldFail(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+
+	rec := userRequest(
+		t, authRes, "POST", "http://localhost:8080/user/:id/deactivate",
+		[]string{fmt.Sprintf("%d", testAdmin.ID)}, HandlePostDeactivateUser(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestDeleteUserShouldTransferProjectsAndTasks(t *testing.T) {
+	_, user := createTestUserWithRole("deleted@example.com", "Testpass1", constants.ProjectManagerRoleID)
+	project := createTestProject("Project of deleted user", user.ID)
+	task := createTestTask(user.ID, user.ID, "Task of deleted user", "Content", constants.Todo)
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	id := fmt.Sprintf("%d", user.ID)
+
+	rec := userRequest(t, authRes, "DELETE", "http://localhost:8080/user/:id", []string{id}, HandleDeleteUser(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+
+	url := fmt.Sprintf("http://localhost:8080/user/:id?transfer_to=%d", testProjectManager.ID)
+	rec = userRequest(t, authRes, "DELETE", url, []string{id}, HandleDeleteUser(tDB))
+	assert.AssertEq(t, rec.Code, http.StatusNoContent)
+
+	err := user.ReadByID(tDB)
+	assert.AssertNotEq(t, err, nil)
+	err = project.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, project.UserID, testProjectManager.ID)
+	member := model.ProjectMember{ProjectID: project.ID, UserID: testProjectManager.ID}
+	err = member.Read(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, member.Role, constants.ProjectOwner)
+	err = task.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, task.AssigneeID, testProjectManager.ID)
+	assert.AssertEq(t, task.CreatorID, testProjectManager.ID)
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/email"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// issueUserToken signs a token for purpose and records it so that it can be
+// consumed once by consumeUserToken. Issuing a token invalidates the user's
+// earlier unused tokens for the same purpose.
+func issueUserToken(db *sql.DB, userID int, purpose, data string, ttl time.Duration) (string, error) {
+	token, err := utils.SignUserToken(userID, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	ut := model.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(token),
+		Data:      data,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	if err := ut.Create(db); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken verifies the signature and expiry of token and marks it
+// used. It returns errInvalidUserToken if the token is not valid for
+// purpose or has already been used.
+func consumeUserToken(db *sql.DB, token, purpose string) (model.UserToken, error) {
+	userID, err := utils.ParseUserToken(token, purpose)
+	if err != nil {
+		return model.UserToken{}, errInvalidUserToken
+	}
+	ut := model.UserToken{TokenHash: utils.HashToken(token), Purpose: purpose}
+	if err := ut.Consume(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.UserToken{}, errInvalidUserToken
+		}
+		return model.UserToken{}, err
+	}
+	if ut.UserID != userID {
+		return model.UserToken{}, errInvalidUserToken
+	}
+	return ut, nil
+}
+
+// sendVerification mails a token that verifies user's email address.
+func sendVerification(db *sql.DB, mailer email.Mailer, user model.User) error {
+	token, err := issueUserToken(db, user.ID, model.TokenEmailVerification, "", config.EMAIL_VERIFICATION_TOKEN_TTL)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(email.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Verify your email address with this token: %s\n\nThe token expires in %s.\n",
+			token, config.EMAIL_VERIFICATION_TOKEN_TTL),
+	})
+}
+
+func HandlePostRequestVerification(db *sql.DB, mailer email.Mailer) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		if user.EmailVerified() {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "email address is already verified"})
+		}
+		if err := sendVerification(db, mailer, user); err != nil {
+			log.Println("err sending verification: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to send verification email"})
+		}
+		return c.JSON(
+			http.StatusAccepted,
+			schema.MessageResponse{Message: fmt.Sprintf("verification sent to '%s'", user.Email)})
+	})
+}
+
+func HandlePostConfirmVerification(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		tokenIn := schema.TokenIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&tokenIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+
+		ut, err := consumeUserToken(db, tokenIn.Token, model.TokenEmailVerification)
+		if err != nil {
+			if errors.Is(err, errInvalidUserToken) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: err.Error()})
+			}
+			log.Println("err consuming verification token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to verify email address"})
+		}
+
+		user := model.User{ID: ut.UserID}
+		if err := user.SetEmailVerified(db); err != nil {
+			log.Println("err verifying email: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to verify email address"})
+		}
+		mw.ForgetUser(user.ID)
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// HandlePostForgotPassword mails a password reset token to the address if
+// it belongs to an active user. It responds the same way whether or not the
+// address is known.
+func HandlePostForgotPassword(db *sql.DB, mailer email.Mailer) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		emailIn := schema.EmailIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&emailIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+
+		response := schema.MessageResponse{Message: "if the address belongs to an account, a reset token has been sent to it"}
+		user := model.User{Email: strings.TrimSpace(emailIn.Email)}
+		if err := user.ReadByEmail(db); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Println("err reading user by email: ", err)
+			}
+			return c.JSON(http.StatusAccepted, response)
+		}
+		if !user.Active() {
+			return c.JSON(http.StatusAccepted, response)
+		}
+
+		token, err := issueUserToken(db, user.ID, model.TokenPasswordReset, "", config.PASSWORD_RESET_TOKEN_TTL)
+		if err != nil {
+			log.Println("err issuing password reset token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to send reset token"})
+		}
+		err = mailer.Send(email.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf(
+				"Reset your password with this token: %s\n\nThe token expires in %s. "+
+					"If you did not ask for a reset, you can ignore this message.\n",
+				token, config.PASSWORD_RESET_TOKEN_TTL),
+		})
+		if err != nil {
+			log.Println("err sending password reset token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to send reset token"})
+		}
+		return c.JSON(http.StatusAccepted, response)
+	})
+}
+
+// HandlePostResetPassword sets a new password with a token from
+// HandlePostForgotPassword and logs the user out everywhere. Since the
+// token was delivered by email, it also verifies the user's address.
+func HandlePostResetPassword(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		resetIn := schema.PasswordResetIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&resetIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if !passwordIsValid(resetIn.Password) {
+			return c.JSON(
+				http.StatusBadRequest,
+				schema.MessageResponse{
+					Message: `'password' must be at least 8 characters long,
+						contain 1 upper case character, 1 lower case character and 1 digit`,
+				},
+			)
+		}
+
+		ut, err := consumeUserToken(db, resetIn.Token, model.TokenPasswordReset)
+		if err != nil {
+			if errors.Is(err, errInvalidUserToken) {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: err.Error()})
+			}
+			log.Println("err consuming password reset token: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to reset password"})
+		}
+
+		user := model.User{ID: ut.UserID}
+		if err := user.ReadByID(db); err != nil || !user.Active() {
+			if err != nil {
+				log.Println("err reading user by id: ", err)
+			}
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: errInvalidUserToken.Error()})
+		}
+		user.PasswordHash, err = utils.HashPassword(resetIn.Password)
+		if err != nil {
+			log.Println("err generating password hash: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to reset password"})
+		}
+		if err := user.ChangePassword(db); err != nil {
+			log.Println("err changing password: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to reset password"})
+		}
+		if !user.EmailVerified() {
+			if err := user.SetEmailVerified(db); err != nil {
+				log.Println("err verifying email: ", err)
+			}
+		}
+		mw.ForgetUser(user.ID)
+		return c.NoContent(http.StatusNoContent)
+	})
+}
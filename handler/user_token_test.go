@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+func getProjectsAs(t *testing.T, authRes schema.AuthResponse) int {
+	rec, c := createContext("GET", "http://localhost:8080/project", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.PermissionRequired(tDB, "read project")(HandleGetProjects(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	return rec.Code
+}
+
+func TestVerifyEmailShouldLiftRestriction(t *testing.T) {
+	address := "unverified@example.com"
+	jsonStr := fmt.Sprintf(`{"email": "%s", "password": "Testpass1"}`, address)
+	rec, c := createContext("POST", "http://localhost:8080/auth/register", jsonStr)
+	err := HandlePostRegister(tDB, tMailer)(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	registrationToken := tMailer.lastToken(t, address)
+
+	authRes := login(t, address, "Testpass1")
+	assert.AssertEq(t, getProjectsAs(t, authRes), http.StatusForbidden)
+
+	rec, c = createContext("POST", "http://localhost:8080/auth/verify/request", "")
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err = mw.JwtMiddleware(tDB)(HandlePostRequestVerification(tDB, tMailer))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusAccepted)
+	token := tMailer.lastToken(t, address)
+
+	confirm := func(token string) int {
+		rec, c := createContext("POST", "http://localhost:8080/auth/verify/confirm", fmt.Sprintf(`{"token": "%s"}`, token))
+		err := HandlePostConfirmVerification(tDB)(c)
+		assert.AssertEq(t, err, nil)
+		return rec.Code
+	}
+	// Requesting a new token invalidates the one sent at registration.
+	assert.AssertEq(t, confirm(registrationToken), http.StatusBadRequest)
+	assert.AssertEq(t, confirm(token), http.StatusNoContent)
+	assert.AssertEq(t, confirm(token), http.StatusBadRequest)
+
+	assert.AssertEq(t, getProjectsAs(t, login(t, address, "Testpass1")), http.StatusOK)
+}
+
+func TestUserTokenShouldNotBeAccessToken(t *testing.T) {
+	address := "tokenconfusion@example.com"
+	jsonStr := fmt.Sprintf(`{"email": "%s", "password": "Testpass1"}`, address)
+	_, c := createContext("POST", "http://localhost:8080/auth/register", jsonStr)
+	err := HandlePostRegister(tDB, tMailer)(c)
+	assert.AssertEq(t, err, nil)
+	token := tMailer.lastToken(t, address)
+
+	assert.AssertEq(t, getProjectsAs(t, schema.AuthResponse{TokenType: "Bearer", AccessToken: token}), http.StatusUnauthorized)
+
+	jsonStr = fmt.Sprintf(`{"token": "%s", "password": "Newpass12"}`, token)
+	rec, c := createContext("POST", "http://localhost:8080/auth/password/reset", jsonStr)
+	err = HandlePostResetPassword(tDB)(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+}
+
+func TestResetPasswordShouldPass(t *testing.T) {
+	userIn, _ := createTestUserWithRole("forgetful@example.com", "Testpass1", constants.UserRoleID)
+	authRes := login(t, userIn.Email, userIn.Password)
+	sent := len(tMailer.messages)
+
+	forgot := func(address string) int {
+		rec, c := createContext("POST", "http://localhost:8080/auth/password/forgot", fmt.Sprintf(`{"email": "%s"}`, address))
+		err := HandlePostForgotPassword(tDB, tMailer)(c)
+		assert.AssertEq(t, err, nil)
+		return rec.Code
+	}
+	assert.AssertEq(t, forgot("nobody@example.com"), http.StatusAccepted)
+	assert.AssertEq(t, len(tMailer.messages), sent)
+	assert.AssertEq(t, forgot(userIn.Email), http.StatusAccepted)
+	token := tMailer.lastToken(t, userIn.Email)
+
+	reset := func(password string) int {
+		jsonStr := fmt.Sprintf(`{"token": "%s", "password": "%s"}`, token, password)
+		rec, c := createContext("POST", "http://localhost:8080/auth/password/reset", jsonStr)
+		err := HandlePostResetPassword(tDB)(c)
+		assert.AssertEq(t, err, nil)
+		return rec.Code
+	}
+	assert.AssertEq(t, reset("weak"), http.StatusBadRequest)
+	assert.AssertEq(t, reset("Newpass12"), http.StatusNoContent)
+	assert.AssertEq(t, reset("Otherpass12"), http.StatusBadRequest)
+
+	assert.AssertEq(t, getProjectsAs(t, authRes), http.StatusUnauthorized)
+	newAuthRes := login(t, userIn.Email, "Newpass12")
+	assert.AssertNotEq(t, newAuthRes.AccessToken, "")
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+	"github.com/tomihaapalainen/go-task-mgmt/webhook"
+)
+
+// enqueueWebhookEvent queues the event for the webhooks of the project,
+// with data as the data of the payload.
+func enqueueWebhookEvent(db *sql.DB, projectID int, event string, data interface{}) {
+	now := time.Now().Unix()
+	payload, err := json.Marshal(schema.WebhookPayload{Event: event, ProjectID: projectID, CreatedAt: now, Data: data})
+	if err != nil {
+		log.Println("err encoding webhook payload: ", err)
+		return
+	}
+	if _, err := model.EnqueueWebhookEvent(db, projectID, event, payload, now); err != nil {
+		log.Println("err enqueuing webhook event: ", err)
+	}
+}
+
+// applyWebhookIn sets the fields of webhookIn that are not nil on w. msg
+// explains why they are invalid if it is not empty.
+func applyWebhookIn(ctx context.Context, webhookIn schema.WebhookIn, w *model.Webhook) (msg string) {
+	if webhookIn.URL != nil {
+		u, err := url.Parse(strings.TrimSpace(*webhookIn.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+			return fmt.Sprintf("invalid webhook URL '%s'", *webhookIn.URL)
+		}
+		if err := webhook.CheckURL(ctx, u); err != nil {
+			if errors.Is(err, webhook.ErrAddressNotPublic) {
+				return fmt.Sprintf("webhook host '%s' is not a public address", u.Hostname())
+			}
+			return fmt.Sprintf("webhook host '%s' cannot be resolved", u.Hostname())
+		}
+		w.URL = u.String()
+	}
+	if webhookIn.Secret != nil {
+		if len(*webhookIn.Secret) < config.WEBHOOK_SECRET_MIN_LENGTH {
+			return fmt.Sprintf("webhook secret must be at least %d characters long", config.WEBHOOK_SECRET_MIN_LENGTH)
+		}
+		w.Secret = *webhookIn.Secret
+	}
+	if webhookIn.Events != nil {
+		if len(webhookIn.Events) == 0 {
+			return "webhook must subscribe to at least one event"
+		}
+		events := []string{}
+		for _, e := range webhookIn.Events {
+			if !model.ValidWebhookEvent(e) {
+				return fmt.Sprintf("invalid webhook event '%s'", e)
+			}
+			if !slices.Contains(events, e) {
+				events = append(events, e)
+			}
+		}
+		w.Events = events
+	}
+	if webhookIn.Active != nil {
+		if *webhookIn.Active {
+			w.DisabledAt = 0
+			w.Failures = 0
+		} else if w.DisabledAt == 0 {
+			w.DisabledAt = time.Now().Unix()
+		}
+	}
+	return ""
+}
+
+// readRouteWebhook reads the webhook named by the "webhookID" route
+// parameter of the project named by "id". If it cannot be read, a response
+// has been written and the returned webhook has no ID.
+func readRouteWebhook(c echo.Context, db *sql.DB) (model.Webhook, error) {
+	projectID := c.Param("id")
+	pID, err := strconv.Atoi(projectID)
+	if err != nil || pID <= 0 {
+		return model.Webhook{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid project ID '%s'", projectID)})
+	}
+	webhookID := c.Param("webhookID")
+	wID, err := strconv.Atoi(webhookID)
+	if err != nil || wID <= 0 {
+		return model.Webhook{}, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid webhook ID '%s'", webhookID)})
+	}
+	webhook := model.Webhook{ID: wID, ProjectID: pID}
+	if err := webhook.Read(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Webhook{}, c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("webhook '%d' not found in project '%d'", wID, pID)})
+		}
+		log.Println("err reading webhook: ", err)
+		return model.Webhook{}, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read webhook"})
+	}
+	return webhook, nil
+}
+
+func HandleGetWebhooks(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		webhooks := model.Webhooks{}
+		if err := webhooks.ReadByProject(db, pID); err != nil {
+			log.Println("err reading webhooks: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read webhooks"})
+		}
+		return c.JSON(http.StatusOK, webhooks)
+	})
+}
+
+// HandlePostWebhook subscribes a URL to events of the project. Deliveries
+// are signed with the secret, which is never returned.
+func HandlePostWebhook(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		webhookIn := schema.WebhookIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&webhookIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if webhookIn.URL == nil || webhookIn.Secret == nil || webhookIn.Events == nil {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "webhook url, secret and events are required"})
+		}
+		webhook := model.Webhook{ProjectID: pID}
+		if msg := applyWebhookIn(c.Request().Context(), webhookIn, &webhook); msg != "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: msg})
+		}
+
+		if err := webhook.Create(db, time.Now().Unix()); err != nil {
+			log.Println("err creating webhook: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to create webhook"})
+		}
+		return c.JSON(http.StatusOK, webhook)
+	})
+}
+
+func HandleGetWebhook(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		webhook, err := readRouteWebhook(c, db)
+		if err != nil || webhook.ID == 0 {
+			return err
+		}
+		return c.JSON(http.StatusOK, webhook)
+	})
+}
+
+// HandlePatchWebhook updates the fields of the webhook given in the body.
+// Re-enabling a disabled webhook resets its failure count, and deliveries
+// queued before it was disabled are attempted again.
+func HandlePatchWebhook(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		webhook, err := readRouteWebhook(c, db)
+		if err != nil || webhook.ID == 0 {
+			return err
+		}
+
+		webhookIn := schema.WebhookIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&webhookIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if msg := applyWebhookIn(c.Request().Context(), webhookIn, &webhook); msg != "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: msg})
+		}
+
+		if err := webhook.Update(db, time.Now().Unix()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("webhook '%d' not found in project '%d'", webhook.ID, webhook.ProjectID)})
+			}
+			log.Println("err updating webhook: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update webhook"})
+		}
+		return c.JSON(http.StatusOK, webhook)
+	})
+}
+
+func HandleDeleteWebhook(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		webhook, err := readRouteWebhook(c, db)
+		if err != nil || webhook.ID == 0 {
+			return err
+		}
+		if err := webhook.Delete(db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("webhook '%d' not found in project '%d'", webhook.ID, webhook.ProjectID)})
+			}
+			log.Println("err deleting webhook: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to delete webhook"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// HandleGetWebhookDeliveries lists the deliveries of the webhook newest
+// first, each with the log of its attempts, optionally filtered by status.
+func HandleGetWebhookDeliveries(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		webhook, err := readRouteWebhook(c, db)
+		if err != nil || webhook.ID == 0 {
+			return err
+		}
+
+		filter := model.WebhookDeliveryFilter{WebhookID: webhook.ID, Status: c.QueryParam("status")}
+		switch filter.Status {
+		case "", model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed:
+		default:
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid delivery status '%s'", filter.Status)})
+		}
+		limit, ok := utils.ParseLimit(c.QueryParam("limit"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "limit must be a positive integer"})
+		}
+		if cursor := c.QueryParam("cursor"); cursor != "" {
+			lc := listCursor{}
+			if err := utils.DecodeCursor(cursor, &lc); err != nil || lc.Sort != "-id" {
+				return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid cursor"})
+			}
+			filter.BeforeID = lc.ID
+		}
+
+		deliveries := model.WebhookDeliveries{}
+		filter.Limit = limit + 1
+		if err := deliveries.List(db, filter); err != nil {
+			log.Println("err listing webhook deliveries: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list webhook deliveries"})
+		}
+		total, err := deliveries.Count(db, filter)
+		if err != nil {
+			log.Println("err counting webhook deliveries: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list webhook deliveries"})
+		}
+
+		page := schema.Page[model.WebhookDelivery]{Items: deliveries, Total: total}
+		if len(deliveries) > limit {
+			page.Items = deliveries[:limit]
+			page.NextCursor, err = utils.EncodeCursor(listCursor{Sort: "-id", ID: page.Items[limit-1].ID})
+			if err != nil {
+				log.Println("err encoding cursor: ", err)
+				return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to list webhook deliveries"})
+			}
+		}
+		return c.JSON(http.StatusOK, page)
+	})
+}
@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/webhook"
+)
+
+const testWebhookSecret = "0123456789abcdef0123"
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver returns a server responding with the status in status
+// and passing the requests it receives to the returned channel. Webhooks
+// may be delivered to loopback addresses until the test ends.
+func newWebhookReceiver(t *testing.T, status *atomic.Int32) (*httptest.Server, chan receivedWebhook) {
+	config.WEBHOOK_ALLOW_LOOPBACK = true
+	t.Cleanup(func() { config.WEBHOOK_ALLOW_LOOPBACK = false })
+	received := make(chan receivedWebhook, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func webhookRequest(t *testing.T, authRes schema.AuthResponse, method, path, jsonStr string, names, values []string, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	return authRequest(
+		t, authRes, method, "http://localhost:8080/project/:id/webhooks"+path, jsonStr, names, values,
+		h, mw.ProjectPermissionRequired(tDB, "update project"),
+	)
+}
+
+func postWebhook(t *testing.T, authRes schema.AuthResponse, projectID int, jsonStr string) (int, model.Webhook) {
+	rec := webhookRequest(t, authRes, "POST", "", jsonStr, []string{"id"}, []string{fmt.Sprintf("%d", projectID)}, HandlePostWebhook(tDB))
+	w := model.Webhook{}
+	if rec.Code == http.StatusOK {
+		assert.AssertEq(t, strings.Contains(rec.Body.String(), testWebhookSecret), false)
+		err := json.NewDecoder(rec.Body).Decode(&w)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, w
+}
+
+func patchWebhook(t *testing.T, authRes schema.AuthResponse, w model.Webhook, jsonStr string) (int, model.Webhook) {
+	rec := webhookRequest(
+		t, authRes, "PATCH", "/:webhookID", jsonStr,
+		[]string{"id", "webhookID"}, []string{fmt.Sprintf("%d", w.ProjectID), fmt.Sprintf("%d", w.ID)},
+		HandlePatchWebhook(tDB),
+	)
+	if rec.Code == http.StatusOK {
+		w = model.Webhook{}
+		err := json.NewDecoder(rec.Body).Decode(&w)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, w
+}
+
+func listWebhookDeliveries(t *testing.T, authRes schema.AuthResponse, w model.Webhook, query string) schema.Page[model.WebhookDelivery] {
+	rec := webhookRequest(
+		t, authRes, "GET", "/:webhookID/deliveries?"+query, "",
+		[]string{"id", "webhookID"}, []string{fmt.Sprintf("%d", w.ProjectID), fmt.Sprintf("%d", w.ID)},
+		HandleGetWebhookDeliveries(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	page := schema.Page[model.WebhookDelivery]{}
+	err := json.NewDecoder(rec.Body).Decode(&page)
+	assert.AssertEq(t, err, nil)
+	return page
+}
+
+func deliverWebhooks(t *testing.T, now time.Time) int {
+	n, err := webhook.NewDispatcher(tDB).DeliverPending(context.Background(), now)
+	assert.AssertEq(t, err, nil)
+	return n
+}
+
+func TestWebhookShouldDeliverSignedEvents(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusNoContent)
+	srv, received := newWebhookReceiver(t, status)
+	project := createTestProject("Webhook project", testProjectManager.ID)
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	code, w := postWebhook(t, authRes, project.ID, fmt.Sprintf(
+		`{"url": "%s/hooks", "secret": "%s", "events": ["task.created", "task.deleted", "task.created"]}`,
+		srv.URL, testWebhookSecret,
+	))
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, w.URL, srv.URL+"/hooks")
+	assert.AssertEq(t, len(w.Events), 2)
+	assert.AssertEq(t, w.DisabledAt, int64(0))
+
+	rec, c := createContextWithParams(
+		"POST",
+		"http://localhost:8080/project/:projectID/task/create",
+		fmt.Sprintf(`{"assignee_id": %d, "title": "Hooked", "content": "Content"}`, testProjectManager.ID),
+		[]string{"projectID"},
+		[]string{fmt.Sprintf("%d", project.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "create task")(HandlePostCreateTask(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	task := model.Task{}
+	err = json.NewDecoder(rec.Body).Decode(&task)
+	assert.AssertEq(t, err, nil)
+	// The webhook is not subscribed to updates.
+	code, _ = transitionTask(t, authRes, task, "doing")
+	assert.AssertEq(t, code, http.StatusOK)
+
+	assert.AssertEq(t, deliverWebhooks(t, time.Now()), 1)
+	r := <-received
+	assert.AssertEq(t, r.header.Get("Content-Type"), "application/json")
+	assert.AssertEq(t, r.header.Get("X-Webhook-Event"), model.WebhookTaskCreated)
+	assert.AssertEq(t, webhook.Verify(testWebhookSecret, r.body, r.header.Get("X-Webhook-Signature")), true)
+	payload := struct {
+		schema.WebhookPayload
+		Data model.Task `json:"data"`
+	}{}
+	err = json.Unmarshal(r.body, &payload)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, payload.Event, model.WebhookTaskCreated)
+	assert.AssertEq(t, payload.ProjectID, project.ID)
+	assert.AssertEq(t, payload.Data.ID, task.ID)
+	assert.AssertEq(t, payload.Data.Title, "Hooked")
+
+	page := listWebhookDeliveries(t, authRes, w, "")
+	assert.AssertEq(t, page.Total, 1)
+	d := page.Items[0]
+	assert.AssertEq(t, r.header.Get("X-Webhook-Delivery"), fmt.Sprintf("%d", d.ID))
+	assert.AssertEq(t, d.Status, model.DeliveryDelivered)
+	assert.AssertEq(t, d.Attempts, 1)
+	assert.AssertNotEq(t, d.DeliveredAt, int64(0))
+	assert.AssertEq(t, len(d.Log), 1)
+	assert.AssertEq(t, d.Log[0].StatusCode, http.StatusNoContent)
+	assert.AssertEq(t, d.Log[0].Error, "")
+
+	// Delivered events are not sent again.
+	assert.AssertEq(t, deliverWebhooks(t, time.Now().Add(time.Hour)), 0)
+}
+
+func TestFailingWebhookShouldBeRetriedAndDisabled(t *testing.T) {
+	disableAfter := config.WEBHOOK_DISABLE_AFTER
+	config.WEBHOOK_DISABLE_AFTER = 3
+	defer func() { config.WEBHOOK_DISABLE_AFTER = disableAfter }()
+
+	status := &atomic.Int32{}
+	status.Store(http.StatusInternalServerError)
+	srv, received := newWebhookReceiver(t, status)
+	project := createTestProject("Failing webhook project", testProjectManager.ID)
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+
+	code, w := postWebhook(t, authRes, project.ID, fmt.Sprintf(
+		`{"url": "%s", "secret": "%s", "events": ["project.updated"]}`, srv.URL, testWebhookSecret,
+	))
+	assert.AssertEq(t, code, http.StatusOK)
+
+	patchProject := func(name string) {
+		rec, c := createContextWithParams(
+			"PATCH",
+			"http://localhost:8080/project/:id",
+			fmt.Sprintf(`{"name": "%s", "description": "Description"}`, name),
+			[]string{"id"},
+			[]string{fmt.Sprintf("%d", project.ID)},
+		)
+		c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+		c.Request().Header.Set("If-Match", "*")
+		err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update project")(HandlePatchProjectID(tDB)))(c)
+		assert.AssertEq(t, err, nil)
+		assert.AssertEq(t, rec.Code, http.StatusOK)
+	}
+	patchProject("Renamed webhook project")
+
+	now := time.Now()
+	assert.AssertEq(t, deliverWebhooks(t, now), 1)
+	<-received
+	d := listWebhookDeliveries(t, authRes, w, "").Items[0]
+	assert.AssertEq(t, d.Status, model.DeliveryPending)
+	assert.AssertEq(t, d.Attempts, 1)
+	assert.AssertEq(t, d.NextAttemptAt, now.Add(webhook.Backoff(1)).Unix())
+	assert.AssertEq(t, d.Log[0].StatusCode, http.StatusInternalServerError)
+	assert.AssertEq(t, d.Log[0].Error, "unexpected response status 500")
+
+	// The delivery is not retried before its backoff has passed.
+	assert.AssertEq(t, deliverWebhooks(t, now), 0)
+	now = now.Add(webhook.Backoff(1))
+	assert.AssertEq(t, deliverWebhooks(t, now), 1)
+	<-received
+	now = now.Add(webhook.Backoff(2))
+	assert.AssertEq(t, deliverWebhooks(t, now), 1)
+	<-received
+
+	rec := webhookRequest(
+		t, authRes, "GET", "/:webhookID", "",
+		[]string{"id", "webhookID"}, []string{fmt.Sprintf("%d", project.ID), fmt.Sprintf("%d", w.ID)},
+		HandleGetWebhook(tDB),
+	)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	err := json.NewDecoder(rec.Body).Decode(&w)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, w.Failures, 3)
+	assert.AssertEq(t, w.DisabledAt, now.Unix())
+
+	// Disabled webhooks are neither sent their queued deliveries nor queued
+	// new ones.
+	now = now.Add(config.WEBHOOK_BACKOFF_MAX)
+	assert.AssertEq(t, deliverWebhooks(t, now), 0)
+	patchProject("Renamed again")
+	assert.AssertEq(t, listWebhookDeliveries(t, authRes, w, "").Total, 1)
+
+	status.Store(http.StatusOK)
+	code, w = patchWebhook(t, authRes, w, `{"active": true}`)
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertEq(t, w.Failures, 0)
+	assert.AssertEq(t, w.DisabledAt, int64(0))
+	assert.AssertEq(t, deliverWebhooks(t, now), 1)
+	r := <-received
+	assert.AssertEq(t, strings.Contains(string(r.body), `"Name":"Renamed webhook project"`), true)
+
+	page := listWebhookDeliveries(t, authRes, w, "status=delivered")
+	assert.AssertEq(t, page.Total, 1)
+	assert.AssertEq(t, page.Items[0].Attempts, 4)
+	assert.AssertEq(t, len(page.Items[0].Log), 4)
+	assert.AssertEq(t, page.Items[0].Log[3].StatusCode, http.StatusOK)
+}
+
+func TestPostWebhookWithInvalidDataShouldFail(t *testing.T) {
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	for _, jsonStr := range []string{
+		`{"secret": "` + testWebhookSecret + `", "events": ["task.created"]}`,
+		`{"url": "ftp://203.0.113.10/hooks", "secret": "` + testWebhookSecret + `", "events": ["task.created"]}`,
+		`{"url": "/hooks", "secret": "` + testWebhookSecret + `", "events": ["task.created"]}`,
+		`{"url": "https://203.0.113.10/hooks", "secret": "short", "events": ["task.created"]}`,
+		`{"url": "https://203.0.113.10/hooks", "secret": "` + testWebhookSecret + `", "events": []}`,
+		`{"url": "https://203.0.113.10/hooks", "secret": "` + testWebhookSecret + `", "events": ["task.archived"]}`,
+	} {
+		code, _ := postWebhook(t, authRes, testProject.ID, jsonStr)
+		assert.AssertEq(t, code, http.StatusBadRequest)
+	}
+
+	// Webhooks may not reach the server's own network.
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hooks",
+		"http://localhost/hooks",
+		"http://[::1]/hooks",
+		"http://0.0.0.0/hooks",
+		"http://10.0.0.1/hooks",
+		"http://192.168.1.1/hooks",
+		"http://100.64.0.1/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fd00:ec2::254]/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+	} {
+		code, _ := postWebhook(t, authRes, testProject.ID, fmt.Sprintf(
+			`{"url": "%s", "secret": "%s", "events": ["task.created"]}`, u, testWebhookSecret,
+		))
+		assert.AssertEq(t, code, http.StatusBadRequest)
+	}
+
+	// Contributors may not manage webhooks.
+	userAuth := login(t, testUserIn.Email, testUserIn.Password)
+	code, _ := postWebhook(t, userAuth, testProject.ID, fmt.Sprintf(
+		`{"url": "https://203.0.113.10/hooks", "secret": "%s", "events": ["task.created"]}`, testWebhookSecret,
+	))
+	assert.AssertEq(t, code, http.StatusForbidden)
+}
+
+func TestDispatcherShouldRefuseNonPublicAddresses(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusOK)
+	srv, received := newWebhookReceiver(t, status)
+	project := createTestProject("Rebound webhook project", testProjectManager.ID)
+	authRes := login(t, testProjectManagerIn.Email, testProjectManagerIn.Password)
+	code, w := postWebhook(t, authRes, project.ID, fmt.Sprintf(
+		`{"url": "%s", "secret": "%s", "events": ["task.created"]}`, srv.URL, testWebhookSecret,
+	))
+	assert.AssertEq(t, code, http.StatusOK)
+	_, err := model.EnqueueWebhookEvent(tDB, project.ID, model.WebhookTaskCreated, []byte(`{}`), time.Now().Unix())
+	assert.AssertEq(t, err, nil)
+
+	// The address is checked again when connecting, as a host may resolve
+	// to another address than when the webhook was created.
+	config.WEBHOOK_ALLOW_LOOPBACK = false
+	assert.AssertEq(t, deliverWebhooks(t, time.Now()), 1)
+	assert.AssertEq(t, len(received), 0)
+	d := listWebhookDeliveries(t, authRes, w, "").Items[0]
+	assert.AssertEq(t, d.Status, model.DeliveryPending)
+	assert.AssertEq(t, d.Log[0].StatusCode, 0)
+	assert.AssertEq(t, strings.Contains(d.Log[0].Error, webhook.ErrAddressNotPublic.Error()), true)
+}
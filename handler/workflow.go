@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+var stateNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// validateWorkflow returns why w is not a valid workflow, or an empty string
+// if it is.
+func validateWorkflow(w model.Workflow) string {
+	if len(w.States) == 0 {
+		return "workflow must have at least one state"
+	}
+	seen := map[constants.TaskStatus]bool{}
+	done := false
+	for _, s := range w.States {
+		if !stateNamePattern.MatchString(string(s.Name)) {
+			return fmt.Sprintf("invalid state name '%s'", s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Sprintf("duplicate state '%s'", s.Name)
+		}
+		seen[s.Name] = true
+		done = done || s.Done
+	}
+	if !done {
+		return "workflow must have at least one done state"
+	}
+	moves := map[model.WorkflowTransition]bool{}
+	for _, t := range w.Transitions {
+		if !seen[t.From] {
+			return fmt.Sprintf("transition from unknown state '%s'", t.From)
+		}
+		if !seen[t.To] {
+			return fmt.Sprintf("transition to unknown state '%s'", t.To)
+		}
+		if t.From == t.To {
+			return fmt.Sprintf("transition from '%s' to itself", t.From)
+		}
+		if moves[t] {
+			return fmt.Sprintf("duplicate transition from '%s' to '%s'", t.From, t.To)
+		}
+		moves[t] = true
+	}
+	return ""
+}
+
+// readWorkflow reads the workflow of the project. If it cannot be read, a
+// response has been written and the returned workflow has no states.
+func readWorkflow(c echo.Context, db *sql.DB, projectID int) (model.Workflow, error) {
+	workflow := model.Workflow{ProjectID: projectID}
+	if err := workflow.Read(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Workflow{}, c.JSON(
+				http.StatusNotFound,
+				schema.MessageResponse{Message: fmt.Sprintf("project '%d' not found", projectID)})
+		}
+		log.Println("err reading workflow: ", err)
+		return model.Workflow{}, c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to read workflow"})
+	}
+	return workflow, nil
+}
+
+func HandleGetProjectWorkflow(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		workflow, err := readWorkflow(c, db, pID)
+		if err != nil || len(workflow.States) == 0 {
+			return err
+		}
+		return c.JSON(http.StatusOK, workflow)
+	})
+}
+
+// HandlePutProjectWorkflow replaces the workflow of the project. States that
+// tasks of the project are in cannot be removed.
+func HandlePutProjectWorkflow(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		projectID := c.Param("id")
+		pID, err := strconv.Atoi(projectID)
+		if err != nil || pID <= 0 {
+			return fmt.Errorf("invalid project ID '%s'", projectID)
+		}
+
+		workflow := model.Workflow{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&workflow); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+		if msg := validateWorkflow(workflow); msg != "" {
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: msg})
+		}
+		if workflow.Transitions == nil {
+			workflow.Transitions = []model.WorkflowTransition{}
+		}
+
+		workflow.ProjectID = pID
+		if err := workflow.Replace(db); err != nil {
+			if errors.Is(err, model.ErrStateInUse) {
+				return c.JSON(http.StatusConflict, schema.MessageResponse{Message: "tasks are in a state the workflow does not have"})
+			}
+			if isConstraintError(err, sqlite3.ErrConstraintForeignKey) {
+				return c.JSON(http.StatusNotFound, schema.MessageResponse{Message: fmt.Sprintf("project '%d' not found", pID)})
+			}
+			log.Println("err replacing workflow: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to update workflow"})
+		}
+		return c.JSON(http.StatusOK, workflow)
+	})
+}
+
+// HandlePostTaskTransition moves the task to another state if the workflow
+// of its project allows it.
+func HandlePostTaskTransition(db *sql.DB) echo.HandlerFunc {
+	return echo.HandlerFunc(func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+
+		transitionIn := schema.TaskTransitionIn{}
+		if err := json.NewDecoder(c.Request().Body).Decode(&transitionIn); err != nil {
+			log.Println("err decoding body: ", err)
+			return c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: "invalid request body"})
+		}
+
+		task, err := readRouteTask(c, db)
+		if err != nil || task.ID == 0 {
+			return err
+		}
+		workflow, err := readWorkflow(c, db, task.ProjectID)
+		if err != nil || len(workflow.States) == 0 {
+			return err
+		}
+		if ok, err := checkTransition(c, workflow, task.Status, transitionIn.Status); !ok {
+			return err
+		}
+
+		previous := task
+		task.Status = transitionIn.Status
+		if err := task.Transition(db, previous.Status); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c.JSON(http.StatusConflict, schema.MessageResponse{Message: "task status has changed, read it again before moving it"})
+			}
+			log.Println("err moving task: ", err)
+			return c.JSON(http.StatusInternalServerError, schema.MessageResponse{Message: "unable to move task"})
+		}
+		notifyTaskChange(db, user.ID, previous, task)
+		publishTaskEvent(db, model.WebhookTaskUpdated, task)
+		setETag(c, task.Version)
+		return c.JSON(http.StatusOK, task)
+	})
+}
+
+// checkTransition checks that a task may move from one state to the other.
+// If it may not, a response has been written and ok is false.
+func checkTransition(c echo.Context, workflow model.Workflow, from, to constants.TaskStatus) (ok bool, err error) {
+	if _, ok := workflow.State(to); !ok {
+		return false, c.JSON(http.StatusBadRequest, schema.MessageResponse{Message: fmt.Sprintf("invalid status '%s'", to)})
+	}
+	if !workflow.Allows(from, to) {
+		return false, c.JSON(
+			http.StatusConflict,
+			schema.MessageResponse{Message: fmt.Sprintf("task cannot move from '%s' to '%s'", from, to)})
+	}
+	return true, nil
+}
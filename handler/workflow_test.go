@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+const reviewWorkflow = `{
+	"states": [
+		{"name": "todo"},
+		{"name": "doing"},
+		{"name": "review"},
+		{"name": "blocked"},
+		{"name": "done", "done": true}
+	],
+	"transitions": [
+		{"from": "todo", "to": "doing"},
+		{"from": "doing", "to": "review"},
+		{"from": "doing", "to": "blocked"},
+		{"from": "blocked", "to": "doing"},
+		{"from": "review", "to": "doing"},
+		{"from": "review", "to": "done"}
+	]
+}`
+
+func putWorkflow(t *testing.T, authRes schema.AuthResponse, projectID int, jsonStr string) *httptest.ResponseRecorder {
+	rec, c := createContextWithParams(
+		"PUT",
+		"http://localhost:8080/project/:id/workflow",
+		jsonStr,
+		[]string{"id"},
+		[]string{fmt.Sprintf("%d", projectID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update project")(HandlePutProjectWorkflow(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	return rec
+}
+
+func transitionTask(t *testing.T, authRes schema.AuthResponse, task model.Task, status string) (int, model.Task) {
+	rec, c := createContextWithParams(
+		"POST",
+		"http://localhost:8080/project/:projectID/task/:id/transition",
+		fmt.Sprintf(`{"status": "%s"}`, status),
+		[]string{"projectID", "id"},
+		[]string{fmt.Sprintf("%d", task.ProjectID), fmt.Sprintf("%d", task.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update task")(mw.TaskPolicyRequired(tDB, "update task")(HandlePostTaskTransition(tDB))))(c)
+	assert.AssertEq(t, err, nil)
+	moved := model.Task{}
+	if rec.Code == http.StatusOK {
+		err = json.NewDecoder(rec.Body).Decode(&moved)
+		assert.AssertEq(t, err, nil)
+	}
+	return rec.Code, moved
+}
+
+func TestWorkflowShouldRestrictTransitions(t *testing.T) {
+	project := createTestProject("Reviewed project", testAdmin.ID)
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	assert.AssertEq(t, putWorkflow(t, authRes, project.ID, reviewWorkflow).Code, http.StatusOK)
+
+	rec, c := createContextWithParams(
+		"GET",
+		"http://localhost:8080/project/:id/workflow",
+		"",
+		[]string{"id"},
+		[]string{fmt.Sprintf("%d", project.ID)},
+	)
+	c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+	err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "read project")(HandleGetProjectWorkflow(tDB)))(c)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	workflow := model.Workflow{}
+	err = json.NewDecoder(rec.Body).Decode(&workflow)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, len(workflow.States), 5)
+	assert.AssertEq(t, workflow.States[2].Name, constants.TaskStatus("review"))
+	assert.AssertEq(t, len(workflow.Transitions), 6)
+
+	task := model.Task{ProjectID: project.ID, AssigneeID: testAdmin.ID, CreatorID: testAdmin.ID, Title: "Reviewed", Content: "Content", Status: constants.Todo}
+	err = task.Create(tDB)
+	assert.AssertEq(t, err, nil)
+
+	code, _ := transitionTask(t, authRes, task, "done")
+	assert.AssertEq(t, code, http.StatusConflict)
+	code, _ = transitionTask(t, authRes, task, "shipped")
+	assert.AssertEq(t, code, http.StatusBadRequest)
+	for _, status := range []string{"doing", "review"} {
+		code, task = transitionTask(t, authRes, task, status)
+		assert.AssertEq(t, code, http.StatusOK)
+		assert.AssertEq(t, task.Status, constants.TaskStatus(status))
+		assert.AssertEq(t, task.CompletedAt, int64(0))
+	}
+	code, task = transitionTask(t, authRes, task, "done")
+	assert.AssertEq(t, code, http.StatusOK)
+	assert.AssertNotEq(t, task.CompletedAt, int64(0))
+
+	// The done state cannot be removed while a task is in it.
+	rec = putWorkflow(t, authRes, project.ID, `{"states": [{"name": "open"}, {"name": "closed", "done": true}]}`)
+	assert.AssertEq(t, rec.Code, http.StatusConflict)
+}
+
+func TestPutInvalidWorkflowShouldFail(t *testing.T) {
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	for _, jsonStr := range []string{
+		`{"states": []}`,
+		`{"states": [{"name": "todo"}]}`,
+		`{"states": [{"name": "Todo", "done": true}]}`,
+		`{"states": [{"name": "todo"}, {"name": "todo", "done": true}]}`,
+		`{"states": [{"name": "todo"}, {"name": "done", "done": true}], "transitions": [{"from": "todo", "to": "gone"}]}`,
+		`{"states": [{"name": "todo"}, {"name": "done", "done": true}], "transitions": [{"from": "todo", "to": "todo"}]}`,
+	} {
+		t.Run(jsonStr, func(t *testing.T) {
+			rec := putWorkflow(t, authRes, testProjectForDeletion.ID, jsonStr)
+			assert.AssertEq(t, rec.Code, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestPatchTaskShouldFollowWorkflow(t *testing.T) {
+	task := createTestTask(testUser.ID, testUser.ID, "Workflow task", "Content", constants.Todo)
+	authRes := login(t, testUserIn.Email, testUserIn.Password)
+
+	patch := func(status string) *httptest.ResponseRecorder {
+		rec, c := createContextWithParams(
+			"PATCH",
+			"http://localhost:8080/project/:projectID/task/:id",
+			fmt.Sprintf(`{"assignee_id": %d, "title": "Workflow task", "content": "Content", "status": "%s"}`, testUser.ID, status),
+			[]string{"projectID", "id"},
+			[]string{fmt.Sprintf("%d", task.ProjectID), fmt.Sprintf("%d", task.ID)},
+		)
+		c.Request().Header.Set("Authorization", fmt.Sprintf("%s %s", authRes.TokenType, authRes.AccessToken))
+		c.Request().Header.Set("If-Match", "*")
+		err := mw.JwtMiddleware(tDB)(mw.ProjectPermissionRequired(tDB, "update task")(mw.TaskPolicyRequired(tDB, "update task")(HandlePatchTaskID(tDB))))(c)
+		assert.AssertEq(t, err, nil)
+		return rec
+	}
+
+	assert.AssertEq(t, patch("blocked").Code, http.StatusBadRequest)
+	rec := patch("done")
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	updated := model.Task{}
+	err := json.NewDecoder(rec.Body).Decode(&updated)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, updated.Status, constants.Done)
+	assert.AssertEq(t, updated.Title, "Workflow task")
+	assert.AssertNotEq(t, updated.CompletedAt, int64(0))
+
+	// An empty status leaves the status as it is.
+	rec = patch("")
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	err = task.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, task.Status, constants.Done)
+	assert.AssertEq(t, task.CompletedAt, updated.CompletedAt)
+
+	rec = patch("todo")
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	err = task.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, task.CompletedAt, int64(0))
+}
+
+func TestPutWorkflowShouldRecomputeCompletedAt(t *testing.T) {
+	project := createTestProject("Recompleted project", testAdmin.ID)
+	authRes := login(t, testAdminIn.Email, testAdminIn.Password)
+	assert.AssertEq(t, putWorkflow(t, authRes, project.ID, reviewWorkflow).Code, http.StatusOK)
+	create := func(status constants.TaskStatus) model.Task {
+		task := model.Task{ProjectID: project.ID, AssigneeID: testUser.ID, CreatorID: testUser.ID, Title: string(status), Content: "Content", Status: status}
+		err := task.Create(tDB)
+		assert.AssertEq(t, err, nil)
+		return task
+	}
+	review, done := create("review"), create("done")
+	assert.AssertEq(t, review.CompletedAt, int64(0))
+	assert.AssertNotEq(t, done.CompletedAt, int64(0))
+
+	// Reviewed tasks count as completed and done ones no longer do.
+	rec := putWorkflow(t, authRes, project.ID, `{
+		"states": [{"name": "review", "done": true}, {"name": "done"}],
+		"transitions": [{"from": "review", "to": "done"}]
+	}`)
+	assert.AssertEq(t, rec.Code, http.StatusOK)
+	err := review.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, review.CompletedAt, int64(0))
+	err = done.ReadByID(tDB)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, done.CompletedAt, int64(0))
+}
@@ -0,0 +1,70 @@
+package keys
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var defaultKeySet struct {
+	sync.Mutex
+	ks *KeySet
+}
+
+// Default returns the key set used by the application. Unless SetDefault has
+// been called it is built from the environment by FromEnv.
+func Default() *KeySet {
+	defaultKeySet.Lock()
+	defer defaultKeySet.Unlock()
+	if defaultKeySet.ks == nil {
+		ks, err := FromEnv(time.Hour)
+		if err != nil {
+			log.Fatal("err loading signing keys: ", err)
+		}
+		defaultKeySet.ks = ks
+	}
+	return defaultKeySet.ks
+}
+
+func SetDefault(ks *KeySet) {
+	defaultKeySet.Lock()
+	defer defaultKeySet.Unlock()
+	defaultKeySet.ks = ks
+}
+
+// FromEnv builds a key set from GO_TASK_MGMT_SIGNING_ALG (HS256, RS256 or
+// EdDSA, default HS256). HS256 uses GO_TASK_MGMT_SIGNING_SECRET. The
+// asymmetric algorithms load keys from GO_TASK_MGMT_SIGNING_KEY_DIR and
+// generate one there if the directory holds none.
+func FromEnv(overlap time.Duration) (*KeySet, error) {
+	alg := os.Getenv("GO_TASK_MGMT_SIGNING_ALG")
+	if alg == "" || alg == HS256 {
+		return NewKeySet(NewHMAC("default", []byte(os.Getenv("GO_TASK_MGMT_SIGNING_SECRET")))), nil
+	}
+	if alg != RS256 && alg != EdDSA {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	dir := os.Getenv("GO_TASK_MGMT_SIGNING_KEY_DIR")
+	if dir == "" {
+		k, err := Generate(alg)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("GO_TASK_MGMT_SIGNING_KEY_DIR is not set, signing key will not survive a restart")
+		return NewKeySet(k), nil
+	}
+
+	ks, err := LoadDir(dir, overlap)
+	if err != nil {
+		return nil, err
+	}
+	if current, err := ks.Current(); err == nil && current.Algorithm == alg {
+		return ks, nil
+	}
+	if _, err := ks.RotateTo(alg, overlap); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
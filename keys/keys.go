@@ -0,0 +1,153 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// Key is a single signing key. HS256 keys hold a shared secret, RS256 and
+// EdDSA keys hold a private key whose public half is published in the JWKS.
+type Key struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// NotAfter is the time after which tokens signed with the key are no
+	// longer accepted. The zero value means the key does not expire.
+	NotAfter time.Time
+
+	secret  []byte
+	private crypto.Signer
+}
+
+func NewHMAC(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Algorithm: HS256, CreatedAt: time.Now(), secret: secret}
+}
+
+// Generate creates a new random key for alg with a random key ID.
+func Generate(alg string) (*Key, error) {
+	kid, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	k := &Key{ID: kid, Algorithm: alg, CreatedAt: time.Now()}
+	switch alg {
+	case HS256:
+		k.secret = make([]byte, 32)
+		if _, err := rand.Read(k.secret); err != nil {
+			return nil, err
+		}
+	case RS256:
+		k.private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case EdDSA:
+		_, k.private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParsePEM reads an RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8) private key.
+func ParsePEM(kid string, b []byte) (*Key, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	k := &Key{ID: kid, CreatedAt: time.Now()}
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		k.Algorithm, k.private = RS256, private
+		return k, nil
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		k.Algorithm, k.private = RS256, private
+	case ed25519.PrivateKey:
+		k.Algorithm, k.private = EdDSA, private
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, private)
+	}
+	return k, nil
+}
+
+// MarshalPEM encodes the private key of an asymmetric key as PKCS #8.
+func (k *Key) MarshalPEM() ([]byte, error) {
+	if k.private == nil {
+		return nil, fmt.Errorf("%w: %s keys cannot be exported", ErrUnsupportedAlgorithm, k.Algorithm)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), nil
+}
+
+func (k *Key) Method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case HS256:
+		return jwt.SigningMethodHS256
+	case RS256:
+		return jwt.SigningMethodRS256
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	}
+	return nil
+}
+
+// Public reports whether the key can be published in a JWKS.
+func (k *Key) Public() bool {
+	return k.private != nil
+}
+
+func (k *Key) signingKey() interface{} {
+	if k.private != nil {
+		return k.private
+	}
+	return k.secret
+}
+
+func (k *Key) verificationKey() interface{} {
+	if k.private != nil {
+		return k.private.Public()
+	}
+	return k.secret
+}
+
+func (k *Key) expired(now time.Time) bool {
+	return !k.NotAfter.IsZero() && !now.Before(k.NotAfter)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
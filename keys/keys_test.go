@@ -0,0 +1,111 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+)
+
+func sign(t *testing.T, ks *KeySet) string {
+	s, err := ks.Sign(jwt.RegisteredClaims{Subject: "1"})
+	assert.AssertEq(t, err, nil)
+	return s
+}
+
+func parse(ks *KeySet, s string) error {
+	_, err := ks.Parse(s, &jwt.RegisteredClaims{})
+	return err
+}
+
+func TestSignAndParseShouldPass(t *testing.T) {
+	for _, alg := range []string{HS256, RS256, EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			k, err := Generate(alg)
+			assert.AssertEq(t, err, nil)
+			ks := NewKeySet(k)
+			assert.AssertEq(t, parse(ks, sign(t, ks)), nil)
+		})
+	}
+}
+
+func TestRotateShouldKeepOldKeyDuringOverlap(t *testing.T) {
+	k, err := Generate(EdDSA)
+	assert.AssertEq(t, err, nil)
+	ks := NewKeySet(k)
+	before := sign(t, ks)
+
+	next, err := ks.Rotate(time.Hour)
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, next.ID, k.ID)
+	assert.AssertEq(t, parse(ks, before), nil)
+
+	current, err := ks.Current()
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, current.ID, next.ID)
+	assert.AssertEq(t, len(ks.JWKS().Keys), 2)
+
+	_, err = ks.Rotate(0)
+	assert.AssertEq(t, err, nil)
+	assert.AssertNotEq(t, parse(ks, before), nil)
+	assert.AssertEq(t, len(ks.Keys()), 1)
+}
+
+func TestParseShouldRejectAlgorithmConfusion(t *testing.T) {
+	k, err := Generate(RS256)
+	assert.AssertEq(t, err, nil)
+	ks := NewKeySet(k)
+
+	// Sign an HS256 token using the published public key as the secret.
+	public, err := x509.MarshalPKIXPublicKey(k.private.Public())
+	assert.AssertEq(t, err, nil)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	token.Header["kid"] = k.ID
+	s, err := token.SignedString(public)
+	assert.AssertEq(t, err, nil)
+
+	assert.AssertNotEq(t, parse(ks, s), nil)
+}
+
+func TestParseShouldRejectUnknownKeyID(t *testing.T) {
+	k, err := Generate(HS256)
+	assert.AssertEq(t, err, nil)
+	other, err := Generate(HS256)
+	assert.AssertEq(t, err, nil)
+
+	assert.AssertNotEq(t, parse(NewKeySet(other), sign(t, NewKeySet(k))), nil)
+}
+
+func TestLoadDirShouldRestoreRotatedKeys(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keys")
+	assert.AssertEq(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	ks := &KeySet{Dir: dir}
+	_, err = ks.RotateTo(RS256, time.Hour)
+	assert.AssertEq(t, err, nil)
+	s := sign(t, ks)
+
+	loaded, err := LoadDir(dir, time.Hour)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, parse(loaded, s), nil)
+}
+
+func TestJWKPublicKeyShouldRoundTrip(t *testing.T) {
+	for _, alg := range []string{RS256, EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			k, err := Generate(alg)
+			assert.AssertEq(t, err, nil)
+			jwks := NewKeySet(k).JWKS()
+			assert.AssertEq(t, len(jwks.Keys), 1)
+			public, err := jwks.Keys[0].PublicKey()
+			assert.AssertEq(t, err, nil)
+			equal := public.(interface{ Equal(crypto.PublicKey) bool }).Equal(k.private.Public())
+			assert.AssertEq(t, equal, true)
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no signing key")
+
+// KeySet holds every key that tokens may currently be verified with. The
+// most recently added key is used for signing; older keys stay valid for
+// verification until their NotAfter time so that tokens signed just before a
+// rotation keep working.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*Key
+	// Dir, when set, is where Rotate stores new asymmetric keys and where
+	// Prune removes expired ones from.
+	Dir string
+}
+
+func NewKeySet(keys ...*Key) *KeySet {
+	ks := &KeySet{}
+	for _, k := range keys {
+		ks.Add(k)
+	}
+	return ks
+}
+
+// LoadDir reads every *.pem file in dir as a key, using the file name without
+// its extension as the key ID. The most recently modified key signs; the
+// others are kept for verification until overlap after it was written.
+func LoadDir(dir string, overlap time.Duration) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	ks := &KeySet{Dir: dir}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		k, err := ParsePEM(strings.TrimSuffix(filepath.Base(path), ".pem"), b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		k.CreatedAt = info.ModTime()
+		ks.keys = append(ks.keys, k)
+	}
+	sort.Slice(ks.keys, func(i, j int) bool { return ks.keys[i].CreatedAt.Before(ks.keys[j].CreatedAt) })
+	if len(ks.keys) > 0 {
+		current := ks.keys[len(ks.keys)-1]
+		for _, k := range ks.keys[:len(ks.keys)-1] {
+			k.NotAfter = current.CreatedAt.Add(overlap)
+		}
+	}
+	ks.Prune()
+	return ks, nil
+}
+
+// Add makes k the signing key.
+func (ks *KeySet) Add(k *Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = append(ks.keys, k)
+}
+
+// Current returns the key new tokens are signed with.
+func (ks *KeySet) Current() (*Key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if len(ks.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return ks.keys[len(ks.keys)-1], nil
+}
+
+// Lookup returns the unexpired key with the given ID.
+func (ks *KeySet) Lookup(kid string) (*Key, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := time.Now()
+	for _, k := range ks.keys {
+		if k.ID == kid && !k.expired(now) {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Keys returns the unexpired keys, oldest first.
+func (ks *KeySet) Keys() []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := time.Now()
+	keys := []*Key{}
+	for _, k := range ks.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Rotate generates a new key with the same algorithm as the current one and
+// makes it the signing key. The previous keys remain valid for verification
+// for overlap, which must be at least the lifetime of an access token.
+func (ks *KeySet) Rotate(overlap time.Duration) (*Key, error) {
+	current, err := ks.Current()
+	if err != nil {
+		return nil, err
+	}
+	return ks.RotateTo(current.Algorithm, overlap)
+}
+
+// RotateTo is like Rotate but generates the new key for alg.
+func (ks *KeySet) RotateTo(alg string, overlap time.Duration) (*Key, error) {
+	k, err := Generate(alg)
+	if err != nil {
+		return nil, err
+	}
+	if ks.Dir != "" && k.Public() {
+		b, err := k.MarshalPEM()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(ks.Dir, k.ID+".pem"), b, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	ks.mu.Lock()
+	notAfter := time.Now().Add(overlap)
+	for _, old := range ks.keys {
+		if old.NotAfter.IsZero() || old.NotAfter.After(notAfter) {
+			old.NotAfter = notAfter
+		}
+	}
+	ks.keys = append(ks.keys, k)
+	ks.mu.Unlock()
+
+	ks.Prune()
+	return k, nil
+}
+
+// Prune drops expired keys, deleting their files if Dir is set.
+func (ks *KeySet) Prune() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	now := time.Now()
+	keys := ks.keys[:0]
+	for _, k := range ks.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+			continue
+		}
+		if ks.Dir != "" && k.Public() {
+			if err := os.Remove(filepath.Join(ks.Dir, k.ID+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Println("err removing expired key: ", err)
+			}
+		}
+	}
+	ks.keys = keys
+}
+
+// RotateEvery rotates the signing key every interval until ctx is done.
+func (ks *KeySet) RotateEvery(ctx context.Context, interval, overlap time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k, err := ks.Rotate(overlap)
+			if err != nil {
+				log.Println("err rotating signing key: ", err)
+				continue
+			}
+			log.Printf("rotated signing key, new key ID '%s'\n", k.ID)
+		}
+	}
+}
+
+// Sign signs claims with the current key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k, err := ks.Current()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(k.Method(), claims)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.signingKey())
+}
+
+// Parse verifies tokenString and decodes it into claims. The token must name
+// a known key in its kid header and must be signed with exactly that key's
+// algorithm, so a token cannot pick a weaker algorithm than the key it
+// claims to use.
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	algs := []string{}
+	for _, k := range ks.Keys() {
+		algs = append(algs, k.Algorithm)
+	}
+	options = append(options, jwt.WithValidMethods(algs))
+	return jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := ks.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID '%s'", kid)
+		}
+		if t.Method.Alg() != k.Algorithm {
+			return nil, fmt.Errorf("algorithm '%s' does not match key '%s'", t.Method.Alg(), kid)
+		}
+		return k.verificationKey(), nil
+	}, options...)
+}
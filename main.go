@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"log"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/tomihaapalainen/go-task-mgmt/config"
 	"github.com/tomihaapalainen/go-task-mgmt/dotenv"
+	"github.com/tomihaapalainen/go-task-mgmt/email"
 	"github.com/tomihaapalainen/go-task-mgmt/handler"
+	"github.com/tomihaapalainen/go-task-mgmt/keys"
 	"github.com/tomihaapalainen/go-task-mgmt/mw"
+	"github.com/tomihaapalainen/go-task-mgmt/oidc"
+	"github.com/tomihaapalainen/go-task-mgmt/reminder"
+	"github.com/tomihaapalainen/go-task-mgmt/webhook"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,43 +27,187 @@ func main() {
 
 	env := flag.String("env", "dev", "run environment dev|test|prod")
 	port := flag.String("port", ":8080", "application port, e.g. ':8080'")
+	bcryptCost := flag.Int("bcrypt-cost", config.BCRYPT_COST, "bcrypt cost used for password hashes")
+	keyRotation := flag.Duration("key-rotation", 0, "signing key rotation interval, e.g. '720h', 0 disables rotation")
+	keyOverlap := flag.Duration("key-overlap", time.Hour, "how long a rotated out signing key is still accepted")
+	avatarDir := flag.String("avatar-dir", config.AVATAR_DIR, "directory uploaded avatars are stored in")
+	reminderInterval := flag.Duration("reminder-interval", 15*time.Minute, "how often to remind assignees of tasks due soon, 0 disables reminders")
+	webhookInterval := flag.Duration("webhook-interval", 5*time.Second, "how often to deliver queued webhook events, 0 disables delivery")
+	flag.Parse()
 
 	config.ENV = *env
 	config.PORT = *port
+	config.BCRYPT_COST = *bcryptCost
+	config.AVATAR_DIR = *avatarDir
+
+	ks, err := keys.FromEnv(*keyOverlap)
+	if err != nil {
+		log.Fatal("err loading signing keys: ", err)
+	}
+	keys.SetDefault(ks)
+	if *keyRotation > 0 {
+		go ks.RotateEvery(context.Background(), *keyRotation, *keyOverlap)
+	}
 
 	db, err := sql.Open("sqlite3", "file:.///db.sqlite3?_fk=ON&_journal=WAL")
 	if err != nil {
 		log.Fatal("err opening database", err)
 	}
 
+	if *reminderInterval > 0 {
+		go reminder.RemindEvery(context.Background(), db, *reminderInterval)
+	}
+	if *webhookInterval > 0 {
+		go webhook.NewDispatcher(db).RunEvery(context.Background(), *webhookInterval)
+	}
+
+	mailer, err := email.FromEnv()
+	if err != nil {
+		log.Fatal("err configuring mailer: ", err)
+	}
+
+	oidcConfig, oidcEnabled, err := oidc.FromEnv()
+	if err != nil {
+		log.Fatal("err configuring oidc: ", err)
+	}
+
 	e := echo.New()
+	// Use the connection address for login throttling rather than trusting
+	// forwarding headers sent by clients.
+	e.IPExtractor = echo.ExtractIPDirect()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"http://localhost"},
-		AllowHeaders: []string{"Accept", "Content-Type", "Origin"},
-		AllowMethods: []string{"DELETE", "GET", "OPTIONS", "PATCH", "POST"},
+		AllowOrigins:  []string{"http://localhost"},
+		AllowHeaders:  []string{"Accept", "Content-Type", "If-Match", "Last-Event-ID", "Origin"},
+		AllowMethods:  []string{"DELETE", "GET", "OPTIONS", "PATCH", "POST", "PUT"},
+		ExposeHeaders: []string{"ETag"},
 	}))
 
-	e.Use(mw.ContentTypeApplicationJSONOnly)
+	e.Use(mw.ContentTypeApplicationJSONOnlyExcept("/me/avatar"))
+
+	e.GET("/.well-known/jwks.json", handler.HandleGetJWKS(ks))
 
 	authGroup := e.Group("/auth")
-	authGroup.POST("/register", handler.HandlePostRegister(db))
+	authGroup.POST("/register", handler.HandlePostRegister(db, mailer))
 	authGroup.POST("/login", handler.HandlePostLogIn(db))
+	authGroup.POST("/login/two-factor", handler.HandlePostLogInTwoFactor(db))
+	authGroup.POST("/refresh", handler.HandlePostRefresh(db))
+	authGroup.POST("/logout", handler.HandlePostLogOut(db), mw.JwtMiddleware(db), mw.SessionRequired)
+	authGroup.POST("/logout/all", handler.HandlePostLogOutAll(db), mw.JwtMiddleware(db), mw.SessionRequired)
+	authGroup.POST("/email/confirm", handler.HandlePostConfirmEmail(db))
+	authGroup.POST("/verify/request", handler.HandlePostRequestVerification(db, mailer), mw.JwtMiddleware(db), mw.SessionRequired)
+	authGroup.POST("/verify/confirm", handler.HandlePostConfirmVerification(db))
+	authGroup.POST("/password/forgot", handler.HandlePostForgotPassword(db, mailer))
+	authGroup.POST("/password/reset", handler.HandlePostResetPassword(db))
+	if oidcEnabled {
+		provider := oidc.NewProvider(oidcConfig)
+		authGroup.GET("/oidc/login", handler.HandleGetOIDCLogin(db, provider))
+		authGroup.GET("/oidc/callback", handler.HandleGetOIDCCallback(db, provider))
+	}
+
+	meGroup := e.Group("/me", mw.JwtMiddleware(db), mw.SessionRequired)
+	meGroup.GET("", handler.HandleGetMe(db))
+	meGroup.PATCH("", handler.HandlePatchMe(db))
+	meGroup.PUT("/avatar", handler.HandlePutMeAvatar(db))
+	meGroup.DELETE("/avatar", handler.HandleDeleteMeAvatar(db))
+	meGroup.POST("/password", handler.HandlePostMePassword(db))
+	meGroup.POST("/email", handler.HandlePostMeEmail(db, mailer))
+	meGroup.POST("/two-factor/setup", handler.HandlePostTwoFactorSetup(db))
+	meGroup.POST("/two-factor/enable", handler.HandlePostTwoFactorEnable(db))
+	meGroup.POST("/two-factor/recovery-codes", handler.HandlePostTwoFactorRecoveryCodes(db))
+	meGroup.POST("/two-factor/disable", handler.HandlePostTwoFactorDisable(db))
+	meGroup.GET("/tokens", handler.HandleGetPersonalAccessTokens(db))
+	meGroup.POST("/tokens", handler.HandlePostPersonalAccessToken(db))
+	meGroup.DELETE("/tokens/:id", handler.HandleDeletePersonalAccessToken(db))
+	meGroup.GET("/notifications", handler.HandleGetNotifications(db))
+	meGroup.POST("/notifications/read", handler.HandlePostNotificationsRead(db))
+	meGroup.POST("/notifications/:id/read", handler.HandlePostNotificationRead(db))
+	meGroup.GET("/notifications/preferences", handler.HandleGetNotificationPreferences(db))
+	meGroup.PATCH("/notifications/preferences", handler.HandlePatchNotificationPreferences(db))
+
+	e.Static("/avatars", config.AVATAR_DIR)
 
-	roleGroup := e.Group("/role", mw.JwtMiddleware)
-	roleGroup.PATCH("/assign", handler.HandlePatchAssignRole(db), mw.PermissionRequired(db, "manage roles"))
+	roleGroup := e.Group("/role", mw.JwtMiddleware(db), mw.PermissionRequired(db, "manage roles"))
+	roleGroup.GET("", handler.HandleGetRoles(db))
+	roleGroup.POST("/create", handler.HandlePostCreateRole(db))
+	roleGroup.PATCH("/assign", handler.HandlePatchAssignRole(db))
+	roleGroup.GET("/:id", handler.HandleGetRoleID(db))
+	roleGroup.PATCH("/:id", handler.HandlePatchRoleID(db))
+	roleGroup.DELETE("/:id", handler.HandleDeleteRole(db))
+	roleGroup.PUT("/:id/two-factor", handler.HandlePutRoleTwoFactor(db))
+	roleGroup.POST("/:id/permissions", handler.HandlePostRolePermission(db))
+	roleGroup.DELETE("/:id/permissions/:permissionID", handler.HandleDeleteRolePermission(db))
 
-	projectGroup := e.Group("/project", mw.JwtMiddleware)
+	e.GET("/permission", handler.HandleGetPermissions(db), mw.JwtMiddleware(db), mw.PermissionRequired(db, "manage roles"))
+
+	userGroup := e.Group("/user", mw.JwtMiddleware(db), mw.PermissionRequired(db, "manage users"))
+	userGroup.GET("", handler.HandleGetUsers(db))
+	userGroup.GET("/:id", handler.HandleGetUserID(db))
+	userGroup.POST("/:id/deactivate", handler.HandlePostDeactivateUser(db))
+	userGroup.POST("/:id/reactivate", handler.HandlePostReactivateUser(db))
+	userGroup.POST("/:id/unlock", handler.HandlePostUnlockUser(db))
+	userGroup.DELETE("/:id/two-factor", handler.HandleDeleteUserTwoFactor(db))
+	userGroup.DELETE("/:id", handler.HandleDeleteUser(db))
+
+	e.GET("/audit", handler.HandleGetAudit(db), mw.JwtMiddleware(db), mw.PermissionRequired(db, "manage users"))
+
+	e.GET("/search", handler.HandleGetSearch(db), mw.JwtMiddleware(db), mw.VerifiedEmailRequired, mw.TwoFactorPolicyRequired(db))
+
+	projectGroup := e.Group("/project", mw.JwtMiddleware(db))
+	projectGroup.GET("", handler.HandleGetProjects(db), mw.PermissionRequired(db, "read project"))
 	projectGroup.POST("/create", handler.HandlePostCreateProject(db), mw.PermissionRequired(db, "create project"))
-	projectGroup.GET("/:id", handler.HandleGetProjectID(db), mw.PermissionRequired(db, "read project"))
-	projectGroup.PATCH("/:id", handler.HandlePatchProjectID(db), mw.PermissionRequired(db, "update project"))
-	projectGroup.DELETE("/:id", handler.HandleDeleteProject(db), mw.PermissionRequired(db, "delete project"))
+	projectGroup.GET("/:id", handler.HandleGetProjectID(db), mw.ProjectPermissionRequired(db, "read project"))
+	projectGroup.PATCH("/:id", handler.HandlePatchProjectID(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.DELETE("/:id", handler.HandleDeleteProject(db), mw.ProjectPermissionRequired(db, "delete project"))
+	projectGroup.GET("/:id/events", handler.HandleGetProjectEvents(db), mw.ProjectPermissionRequired(db, "read task"))
+	projectGroup.GET("/:id/workflow", handler.HandleGetProjectWorkflow(db), mw.ProjectPermissionRequired(db, "read project"))
+	projectGroup.PUT("/:id/workflow", handler.HandlePutProjectWorkflow(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.GET("/:id/members", handler.HandleGetProjectMembers(db), mw.ProjectPermissionRequired(db, "read project"))
+	projectGroup.POST("/:id/members", handler.HandlePostProjectMember(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.PATCH("/:id/members/:userID", handler.HandlePatchProjectMember(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.DELETE("/:id/members/:userID", handler.HandleDeleteProjectMember(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.GET("/:id/webhooks", handler.HandleGetWebhooks(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.POST("/:id/webhooks", handler.HandlePostWebhook(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.GET("/:id/webhooks/:webhookID", handler.HandleGetWebhook(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.PATCH("/:id/webhooks/:webhookID", handler.HandlePatchWebhook(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.DELETE("/:id/webhooks/:webhookID", handler.HandleDeleteWebhook(db), mw.ProjectPermissionRequired(db, "update project"))
+	projectGroup.GET(
+		"/:id/webhooks/:webhookID/deliveries",
+		handler.HandleGetWebhookDeliveries(db),
+		mw.ProjectPermissionRequired(db, "update project"),
+	)
+
+	dueGroup := e.Group("/tasks", mw.JwtMiddleware(db), mw.PermissionRequired(db, "read task"))
+	dueGroup.GET("/overdue", handler.HandleGetOverdueTasks(db))
+	dueGroup.GET("/due-soon", handler.HandleGetDueSoonTasks(db))
 
 	taskGroup := projectGroup.Group("/:projectID")
-	taskGroup.POST("/task/create", handler.HandlePostCreateTask(db), mw.PermissionRequired(db, "create task"))
-	taskGroup.GET("/task/:id", handler.HandleGetTaskID(db), mw.PermissionRequired(db, "read task"))
-	taskGroup.PATCH("/task/:id", handler.HandlePatchTaskID(db), mw.PermissionRequired(db, "update task"))
-	taskGroup.POST("/task/:id", handler.HandleDeleteTask(db), mw.PermissionRequired(db, "delete task"))
+	taskGroup.GET("/tasks", handler.HandleGetTasks(db), mw.ProjectPermissionRequired(db, "read task"))
+	taskGroup.POST("/task/create", handler.HandlePostCreateTask(db), mw.ProjectPermissionRequired(db, "create task"))
+	taskGroup.GET("/task/:id", handler.HandleGetTaskID(db), mw.ProjectPermissionRequired(db, "read task"))
+	taskGroup.PATCH(
+		"/task/:id",
+		handler.HandlePatchTaskID(db),
+		mw.ProjectPermissionRequired(db, "update task"),
+		mw.TaskPolicyRequired(db, "update task"),
+	)
+	taskGroup.POST(
+		"/task/:id/transition",
+		handler.HandlePostTaskTransition(db),
+		mw.ProjectPermissionRequired(db, "update task"),
+		mw.TaskPolicyRequired(db, "update task"),
+	)
+	taskGroup.GET("/task/:id/comments", handler.HandleGetComments(db), mw.ProjectPermissionRequired(db, "read task"))
+	taskGroup.POST("/task/:id/comments", handler.HandlePostComment(db), mw.ProjectPermissionRequired(db, "update task"))
+	taskGroup.PATCH("/task/:id/comments/:commentID", handler.HandlePatchComment(db), mw.ProjectPermissionRequired(db, "update task"))
+	taskGroup.DELETE("/task/:id/comments/:commentID", handler.HandleDeleteComment(db), mw.ProjectPermissionRequired(db, "update task"))
+	taskGroup.GET("/task/:id/comments/:commentID/history", handler.HandleGetCommentHistory(db), mw.ProjectPermissionRequired(db, "read task"))
+	taskGroup.POST(
+		"/task/:id",
+		handler.HandleDeleteTask(db),
+		mw.ProjectPermissionRequired(db, "delete task"),
+		mw.TaskPolicyRequired(db, "delete task"),
+	)
 
 	e.Start(config.PORT)
 }
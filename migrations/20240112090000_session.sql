@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS session (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    family_id TEXT,
+    user_id INTEGER,
+    token_hash TEXT,
+    created_at INTEGER,
+    expires_at INTEGER,
+    rotated_at INTEGER,
+    revoked_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS session_family_id_ix ON session (family_id);
+CREATE INDEX IF NOT EXISTS session_user_id_ix ON session (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX session_user_id_ix;
+DROP INDEX session_family_id_ix;
+DROP TABLE session;
+-- +goose StatementEnd
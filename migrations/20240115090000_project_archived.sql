@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE project ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS project_user_id_ix ON project (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX project_user_id_ix;
+ALTER TABLE project DROP COLUMN archived;
+-- +goose StatementEnd
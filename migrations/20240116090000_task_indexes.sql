@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS task_project_id_status_ix ON task (project_id, status);
+CREATE INDEX IF NOT EXISTS task_assignee_id_ix ON task (assignee_id);
+CREATE INDEX IF NOT EXISTS task_creator_id_ix ON task (creator_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX task_creator_id_ix;
+DROP INDEX task_assignee_id_ix;
+DROP INDEX task_project_id_status_ix;
+-- +goose StatementEnd
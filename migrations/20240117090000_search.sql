@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE VIRTUAL TABLE IF NOT EXISTS task_fts USING fts5(title, content, content="task", content_rowid="id", tokenize="unicode61");
+
+CREATE TRIGGER IF NOT EXISTS task_fts_ai AFTER INSERT ON task BEGIN
+    INSERT INTO task_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS task_fts_ad AFTER DELETE ON task BEGIN
+    INSERT INTO task_fts (task_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS task_fts_au AFTER UPDATE ON task BEGIN
+    INSERT INTO task_fts (task_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+    INSERT INTO task_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+INSERT INTO task_fts (task_fts) VALUES ('rebuild');
+
+CREATE VIRTUAL TABLE IF NOT EXISTS project_fts USING fts5(name, description, content="project", content_rowid="id", tokenize="unicode61");
+
+CREATE TRIGGER IF NOT EXISTS project_fts_ai AFTER INSERT ON project BEGIN
+    INSERT INTO project_fts (rowid, name, description) VALUES (new.id, new.name, new.description);
+END;
+CREATE TRIGGER IF NOT EXISTS project_fts_ad AFTER DELETE ON project BEGIN
+    INSERT INTO project_fts (project_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+END;
+CREATE TRIGGER IF NOT EXISTS project_fts_au AFTER UPDATE ON project BEGIN
+    INSERT INTO project_fts (project_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+    INSERT INTO project_fts (rowid, name, description) VALUES (new.id, new.name, new.description);
+END;
+
+INSERT INTO project_fts (project_fts) VALUES ('rebuild');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER project_fts_au;
+DROP TRIGGER project_fts_ad;
+DROP TRIGGER project_fts_ai;
+DROP TABLE project_fts;
+DROP TRIGGER task_fts_au;
+DROP TRIGGER task_fts_ad;
+DROP TRIGGER task_fts_ai;
+DROP TABLE task_fts;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS project_member (
+    project_id INTEGER,
+    user_id INTEGER,
+    role TEXT,
+    PRIMARY KEY (project_id, user_id),
+    FOREIGN KEY (project_id) REFERENCES project(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS project_member_user_id_ix ON project_member (user_id);
+
+INSERT INTO project_member (project_id, user_id, role)
+SELECT id, user_id, 'owner' FROM project WHERE user_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX project_member_user_id_ix;
+DROP TABLE project_member;
+-- +goose StatementEnd
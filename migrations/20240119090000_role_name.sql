@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS role_name_ux ON role (name);
+CREATE UNIQUE INDEX IF NOT EXISTS permission_name_ux ON permission (name);
+CREATE INDEX IF NOT EXISTS role_permission_permission_id_ix ON role_permission (permission_id);
+CREATE INDEX IF NOT EXISTS user_role_id_ix ON user (role_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX user_role_id_ix;
+DROP INDEX role_permission_permission_id_ix;
+DROP INDEX permission_name_ux;
+DROP INDEX role_name_ux;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE user ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE user ADD COLUMN locale TEXT NOT NULL DEFAULT 'en';
+ALTER TABLE user ADD COLUMN avatar TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS user_token (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    purpose TEXT,
+    token_hash TEXT,
+    data TEXT NOT NULL DEFAULT '',
+    created_at INTEGER,
+    expires_at INTEGER,
+    used_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS user_token_user_id_ix ON user_token (user_id, purpose);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX user_token_user_id_ix;
+DROP TABLE user_token;
+ALTER TABLE user DROP COLUMN avatar;
+ALTER TABLE user DROP COLUMN locale;
+ALTER TABLE user DROP COLUMN timezone;
+ALTER TABLE user DROP COLUMN display_name;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user ADD COLUMN email_verified_at INTEGER;
+
+-- Accounts created before verification existed are trusted.
+UPDATE user SET email_verified_at = CAST(strftime('%s', 'now') AS INTEGER);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user DROP COLUMN email_verified_at;
+-- +goose StatementEnd
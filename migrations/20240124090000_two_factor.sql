@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user ADD COLUMN two_factor_enabled_at INTEGER;
+ALTER TABLE role ADD COLUMN two_factor_required INTEGER NOT NULL DEFAULT 0;
+
+-- The TOTP secret of a user. A row without enabled_at is an enrollment the
+-- user has not yet confirmed with a code. last_counter is the time step of
+-- the last accepted code, so that a code cannot be used twice.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    last_counter INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL UNIQUE,
+    used_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS recovery_code_user_id_ix ON recovery_code (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX recovery_code_user_id_ix;
+DROP TABLE recovery_code;
+DROP TABLE user_totp;
+ALTER TABLE role DROP COLUMN two_factor_required;
+ALTER TABLE user DROP COLUMN two_factor_enabled_at;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS personal_access_token (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    last_used_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    UNIQUE (token_hash),
+    UNIQUE (user_id, name)
+);
+
+-- The scopes of a token are permissions. A token can only be used for
+-- permissions that are both in its scopes and in the user's role.
+CREATE TABLE IF NOT EXISTS personal_access_token_permission (
+    token_id INTEGER NOT NULL,
+    permission_id INTEGER NOT NULL,
+    FOREIGN KEY (token_id) REFERENCES personal_access_token(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permission(id),
+    PRIMARY KEY (token_id, permission_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE personal_access_token_permission;
+DROP TABLE personal_access_token;
+-- +goose StatementEnd
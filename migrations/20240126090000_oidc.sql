@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Logins started at the identity provider and not yet completed, keyed by
+-- the hash of their state parameter.
+CREATE TABLE IF NOT EXISTS oidc_login (
+    state_hash TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+
+-- Links a user to an account at an identity provider.
+CREATE TABLE IF NOT EXISTS user_identity (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    last_login_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identity_user_id_ix ON user_identity (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX user_identity_user_id_ix;
+DROP TABLE user_identity;
+DROP TABLE oidc_login;
+-- +goose StatementEnd
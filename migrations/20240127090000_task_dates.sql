@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE task ADD COLUMN start_at INTEGER;
+ALTER TABLE task ADD COLUMN due_at INTEGER;
+ALTER TABLE task ADD COLUMN completed_at INTEGER;
+
+CREATE INDEX IF NOT EXISTS task_due_at_ix ON task (due_at) WHERE completed_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX task_due_at_ix;
+ALTER TABLE task DROP COLUMN completed_at;
+ALTER TABLE task DROP COLUMN due_at;
+ALTER TABLE task DROP COLUMN start_at;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+UPDATE user SET created_at = CAST(strftime('%s', 'now') AS INTEGER), updated_at = CAST(strftime('%s', 'now') AS INTEGER);
+
+ALTER TABLE project ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE project ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE project ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+UPDATE project SET created_at = CAST(strftime('%s', 'now') AS INTEGER), updated_at = CAST(strftime('%s', 'now') AS INTEGER);
+
+ALTER TABLE task ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE task ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE task ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+UPDATE task SET created_at = CAST(strftime('%s', 'now') AS INTEGER), updated_at = CAST(strftime('%s', 'now') AS INTEGER);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE task DROP COLUMN version;
+ALTER TABLE task DROP COLUMN updated_at;
+ALTER TABLE task DROP COLUMN created_at;
+ALTER TABLE project DROP COLUMN version;
+ALTER TABLE project DROP COLUMN updated_at;
+ALTER TABLE project DROP COLUMN created_at;
+ALTER TABLE user DROP COLUMN version;
+ALTER TABLE user DROP COLUMN updated_at;
+ALTER TABLE user DROP COLUMN created_at;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS task_state (
+    project_id INTEGER,
+    name TEXT,
+    position INTEGER NOT NULL,
+    done INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (project_id, name),
+    FOREIGN KEY (project_id) REFERENCES project(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS task_transition (
+    project_id INTEGER,
+    from_state TEXT,
+    to_state TEXT,
+    PRIMARY KEY (project_id, from_state, to_state),
+    FOREIGN KEY (project_id, from_state) REFERENCES task_state(project_id, name) ON DELETE CASCADE,
+    FOREIGN KEY (project_id, to_state) REFERENCES task_state(project_id, name) ON DELETE CASCADE
+);
+
+INSERT INTO task_state (project_id, name, position, done)
+SELECT id, 'todo', 0, 0 FROM project
+UNION ALL SELECT id, 'doing', 1, 0 FROM project
+UNION ALL SELECT id, 'done', 2, 1 FROM project;
+
+INSERT INTO task_transition (project_id, from_state, to_state)
+SELECT f.project_id, f.name, t.name
+FROM task_state f
+INNER JOIN task_state t
+ON t.project_id = f.project_id AND t.name != f.name;
+
+-- Task.Update used to write the title into the status column.
+UPDATE task SET status = 'todo' WHERE status IS NULL OR status NOT IN ('todo', 'doing', 'done');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE task_transition;
+DROP TABLE task_state;
+-- +goose StatementEnd
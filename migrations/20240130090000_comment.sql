@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS comment (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER NOT NULL,
+    user_id INTEGER,
+    parent_id INTEGER,
+    content TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    edited_at INTEGER,
+    deleted_at INTEGER,
+    FOREIGN KEY (task_id) REFERENCES task(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE SET NULL,
+    FOREIGN KEY (parent_id) REFERENCES comment(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comment_task_id_ix ON comment (task_id, parent_id, id);
+CREATE INDEX IF NOT EXISTS comment_parent_id_ix ON comment (parent_id);
+
+CREATE TABLE IF NOT EXISTS comment_revision (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    comment_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (comment_id) REFERENCES comment(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comment_revision_comment_id_ix ON comment_revision (comment_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX comment_revision_comment_id_ix;
+DROP TABLE comment_revision;
+DROP INDEX comment_parent_id_ix;
+DROP INDEX comment_task_id_ix;
+DROP TABLE comment;
+-- +goose StatementEnd
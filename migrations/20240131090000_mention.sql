@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE user ADD COLUMN handle TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_handle_ix ON user (handle COLLATE NOCASE) WHERE handle IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS mention (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER NOT NULL,
+    comment_id INTEGER,
+    user_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (task_id) REFERENCES task(id) ON DELETE CASCADE,
+    FOREIGN KEY (comment_id) REFERENCES comment(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS mention_task_id_ix ON mention (task_id, COALESCE(comment_id, 0), user_id);
+CREATE INDEX IF NOT EXISTS mention_user_id_ix ON mention (user_id);
+
+CREATE TABLE IF NOT EXISTS notification (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    actor_id INTEGER,
+    task_id INTEGER,
+    comment_id INTEGER,
+    created_at INTEGER NOT NULL,
+    read_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES user(id) ON DELETE SET NULL,
+    FOREIGN KEY (task_id) REFERENCES task(id) ON DELETE CASCADE,
+    FOREIGN KEY (comment_id) REFERENCES comment(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS notification_user_id_ix ON notification (user_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX notification_user_id_ix;
+DROP TABLE notification;
+DROP INDEX mention_user_id_ix;
+DROP INDEX mention_task_id_ix;
+DROP TABLE mention;
+DROP INDEX user_handle_ix;
+ALTER TABLE user DROP COLUMN handle;
+-- +goose StatementEnd
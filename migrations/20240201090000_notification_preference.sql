@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE notification ADD COLUMN details TEXT NOT NULL DEFAULT '';
+ALTER TABLE notification ADD COLUMN due_at INTEGER;
+
+CREATE INDEX IF NOT EXISTS notification_unread_ix ON notification (user_id, id) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS notification_task_id_ix ON notification (task_id, type);
+
+CREATE TABLE IF NOT EXISTS notification_preference (
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    enabled INTEGER NOT NULL,
+    PRIMARY KEY (user_id, type),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE notification_preference;
+DROP INDEX notification_task_id_ix;
+DROP INDEX notification_unread_ix;
+ALTER TABLE notification DROP COLUMN due_at;
+ALTER TABLE notification DROP COLUMN details;
+-- +goose StatementEnd
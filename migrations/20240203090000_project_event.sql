@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS project_event (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    task_id INTEGER,
+    data TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (project_id) REFERENCES project(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS project_event_project_id_ix ON project_event (project_id, id);
+CREATE INDEX IF NOT EXISTS project_event_created_at_ix ON project_event (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX project_event_created_at_ix;
+DROP INDEX project_event_project_id_ix;
+DROP TABLE project_event;
+-- +goose StatementEnd
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Audit actions.
+const (
+	AuditLoginLockout     = "login.lockout"
+	AuditLoginUnlock      = "login.unlock"
+	AuditTwoFactorEnable  = "two_factor.enable"
+	AuditTwoFactorDisable = "two_factor.disable"
+	AuditTwoFactorReset   = "two_factor.reset"
+	AuditTwoFactorPolicy  = "two_factor.policy"
+)
+
+type AuditEntry struct {
+	ID        int   `json:"id"`
+	CreatedAt int64 `json:"created_at"`
+	// ActorID is the user who performed the action, or 0 for actions taken
+	// by the application itself.
+	ActorID int    `json:"actor_id,omitempty"`
+	Action  string `json:"action"`
+	// Subject identifies what the action concerns, e.g. "account:<email>".
+	Subject string `json:"subject"`
+	Details string `json:"details,omitempty"`
+}
+
+type AuditEntries []AuditEntry
+
+// AuditFilter selects audit entries for AuditEntries.List, newest first.
+type AuditFilter struct {
+	Action  string
+	Subject string
+	// BeforeID is the ID of the last entry of the previous page.
+	BeforeID int
+	Limit    int
+}
+
+func (a *AuditEntry) Create(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO audit_log (created_at, actor_id, action, subject, details)
+		values ($1, $2, $3, $4, $5)
+		RETURNING id
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	actorID := sql.NullInt64{Int64: int64(a.ActorID), Valid: a.ActorID > 0}
+	return stmt.QueryRow(a.CreatedAt, actorID, a.Action, a.Subject, a.Details).Scan(&a.ID)
+}
+
+func (f AuditFilter) query(paginate bool) query {
+	q := query{}
+	if f.Action != "" {
+		q.and("action = " + q.arg(f.Action))
+	}
+	if f.Subject != "" {
+		q.and("subject = " + q.arg(f.Subject))
+	}
+	if paginate && f.BeforeID > 0 {
+		q.after("id", true, nil, f.BeforeID)
+	}
+	return q
+}
+
+func (as *AuditEntries) List(db *sql.DB, f AuditFilter) error {
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT id, created_at, COALESCE(actor_id, 0), action, subject, details
+			FROM audit_log
+			%s
+			ORDER BY id DESC
+			LIMIT %s
+			`,
+			q.whereClause(), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := AuditEntry{}
+		if err := rows.Scan(&a.ID, &a.CreatedAt, &a.ActorID, &a.Action, &a.Subject, &a.Details); err != nil {
+			return err
+		}
+		*as = append(*as, a)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of entries matching f, ignoring pagination.
+func (as *AuditEntries) Count(db *sql.DB, f AuditFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM audit_log
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,322 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidParent is returned by Comment.Create if the comment replies to a
+// comment that does not exist on the same task, is deleted or is itself a
+// reply.
+var ErrInvalidParent = errors.New("invalid parent comment")
+
+type Comment struct {
+	ID     int `json:"id"`
+	TaskID int `json:"task_id"`
+	// UserID is the author of the comment, or 0 if they have been deleted.
+	UserID int `json:"user_id"`
+	// ParentID is the comment this one replies to, or 0 if it is not a
+	// reply. Replies cannot be replied to.
+	ParentID int `json:"parent_id,omitempty"`
+	// Content is empty once the comment has been deleted.
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	// EditedAt is the Unix time the content was last edited, or 0 if it has
+	// not been.
+	EditedAt  int64 `json:"edited_at,omitempty"`
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+	// Replies are only read by Comments.List.
+	Replies []Comment `json:"replies,omitempty"`
+}
+
+type Comments []Comment
+
+// CommentRevision is the content of a comment before an edit.
+type CommentRevision struct {
+	ID        int    `json:"id"`
+	CommentID int    `json:"comment_id"`
+	Content   string `json:"content"`
+	// CreatedAt is the Unix time the content was written.
+	CreatedAt int64 `json:"created_at"`
+}
+
+type CommentRevisions []CommentRevision
+
+// commentColumns are scanned by scanComment. The content of deleted
+// comments is not returned.
+const commentColumns = `id, task_id, COALESCE(user_id, 0), COALESCE(parent_id, 0),
+	CASE WHEN deleted_at IS NULL THEN content ELSE '' END,
+	created_at, updated_at, COALESCE(edited_at, 0), COALESCE(deleted_at, 0)`
+
+func scanComment(row interface{ Scan(...interface{}) error }, cm *Comment) error {
+	return row.Scan(
+		&cm.ID, &cm.TaskID, &cm.UserID, &cm.ParentID, &cm.Content,
+		&cm.CreatedAt, &cm.UpdatedAt, &cm.EditedAt, &cm.DeletedAt,
+	)
+}
+
+func (cm *Comment) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if cm.ParentID > 0 {
+		grandparentID, deleted := 0, false
+		err := tx.QueryRow(
+			`
+			SELECT COALESCE(parent_id, 0), deleted_at IS NOT NULL
+			FROM comment
+			WHERE id = $1 AND task_id = $2
+			`,
+			cm.ParentID, cm.TaskID,
+		).Scan(&grandparentID, &deleted)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidParent
+		}
+		if err != nil {
+			return err
+		}
+		if grandparentID != 0 || deleted {
+			return ErrInvalidParent
+		}
+	}
+
+	err = scanComment(
+		tx.QueryRow(
+			`
+			INSERT INTO comment (task_id, user_id, parent_id, content, created_at, updated_at)
+			values ($1, $2, NULLIF($3, 0), $4, $5, $5)
+			RETURNING `+commentColumns,
+			cm.TaskID, cm.UserID, cm.ParentID, cm.Content, time.Now().Unix(),
+		),
+		cm,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Read reads the comment with cm.ID on task cm.TaskID.
+func (cm *Comment) Read(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT ` + commentColumns + `
+		FROM comment
+		WHERE id = $1 AND task_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return scanComment(stmt.QueryRow(cm.ID, cm.TaskID), cm)
+}
+
+// Update replaces the content of the comment, keeping the previous content
+// as a revision. It returns sql.ErrNoRows if the comment does not exist or
+// has been deleted.
+func (cm *Comment) Update(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`
+		INSERT INTO comment_revision (comment_id, content, created_at)
+		SELECT id, content, COALESCE(edited_at, created_at)
+		FROM comment
+		WHERE id = $1 AND task_id = $2 AND deleted_at IS NULL
+		`,
+		cm.ID, cm.TaskID,
+	)
+	if err != nil {
+		return err
+	}
+	err = scanComment(
+		tx.QueryRow(
+			`
+			UPDATE comment
+			SET content = $1,
+				edited_at = $2,
+				updated_at = $2
+			WHERE id = $3 AND task_id = $4 AND deleted_at IS NULL
+			RETURNING `+commentColumns,
+			cm.Content, time.Now().Unix(), cm.ID, cm.TaskID,
+		),
+		cm,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete marks the comment as deleted. Replies to it are kept. It returns
+// sql.ErrNoRows if the comment does not exist or has already been deleted.
+func (cm *Comment) Delete(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE comment
+		SET deleted_at = $1,
+			updated_at = $1
+		WHERE id = $2 AND task_id = $3 AND deleted_at IS NULL
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(time.Now().Unix(), cm.ID, cm.TaskID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// CommentFilter selects a page of the top-level comments of a task for
+// Comments.List, oldest first.
+type CommentFilter struct {
+	TaskID int
+	// AfterID is the ID of the last comment of the previous page.
+	AfterID int
+	Limit   int
+}
+
+func (f CommentFilter) query(paginate bool) query {
+	q := query{}
+	q.and("task_id = " + q.arg(f.TaskID))
+	q.and("parent_id IS NULL")
+	if paginate && f.AfterID > 0 {
+		q.after("id", false, nil, f.AfterID)
+	}
+	return q
+}
+
+// List reads the top-level comments matching f together with their
+// replies.
+func (cms *Comments) List(db *sql.DB, f CommentFilter) error {
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT %s
+			FROM comment
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			commentColumns, q.whereClause(), orderBy("id", false), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	if err := cms.scan(rows); err != nil {
+		return err
+	}
+	if len(*cms) == 0 {
+		return nil
+	}
+
+	parents := map[int]int{}
+	q = query{}
+	ids := []string{}
+	for i, cm := range *cms {
+		parents[cm.ID] = i
+		ids = append(ids, q.arg(cm.ID))
+	}
+	rows, err = db.Query(
+		fmt.Sprintf(
+			`
+			SELECT %s
+			FROM comment
+			WHERE parent_id IN (%s)
+			ORDER BY id
+			`,
+			commentColumns, strings.Join(ids, ", "),
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	replies := Comments{}
+	if err := replies.scan(rows); err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		parent := &(*cms)[parents[reply.ParentID]]
+		parent.Replies = append(parent.Replies, reply)
+	}
+	return nil
+}
+
+func (cms *Comments) scan(rows *sql.Rows) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		cm := Comment{}
+		if err := scanComment(rows, &cm); err != nil {
+			return err
+		}
+		*cms = append(*cms, cm)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of top-level comments matching f, ignoring
+// pagination.
+func (cms *Comments) Count(db *sql.DB, f CommentFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM comment
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
+
+// ReadByComment reads the earlier contents of the comment, oldest first.
+// The history of deleted comments is not returned.
+func (rs *CommentRevisions) ReadByComment(db *sql.DB, commentID int) error {
+	rows, err := db.Query(
+		`
+		SELECT r.id, r.comment_id, r.content, r.created_at
+		FROM comment_revision r
+		INNER JOIN comment c
+		ON c.id = r.comment_id
+		WHERE r.comment_id = $1 AND c.deleted_at IS NULL
+		ORDER BY r.id
+		`,
+		commentID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := CommentRevision{}
+		if err := rows.Scan(&r.ID, &r.CommentID, &r.Content, &r.CreatedAt); err != nil {
+			return err
+		}
+		*rs = append(*rs, r)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// LoginThrottle counts failed logins for a key, such as an account or a
+// client IP. Times are Unix seconds, 0 meaning unset.
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt int64
+	BlockedUntil  int64
+	LockedUntil   int64
+}
+
+// Read reads the throttle for t.Key. A key without failures reads as the
+// zero throttle.
+func (t *LoginThrottle) Read(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT failures, last_failure_at, blocked_until, locked_until
+		FROM login_throttle
+		WHERE key = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	err = stmt.QueryRow(t.Key).Scan(&t.Failures, &t.LastFailureAt, &t.BlockedUntil, &t.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// Attempt counts a login attempt for t.Key at now as a failure before the
+// credentials are checked, so that concurrent attempts cannot get past the
+// limits. Failures before resetBefore are forgotten first. The attempt is
+// refused if the key is blocked or locked out at now or already has limit
+// failures, in which case nothing is counted and t is only read. An
+// attempt that succeeds is uncounted with Forgive.
+func (t *LoginThrottle) Attempt(db *sql.DB, now, resetBefore int64, limit int) (bool, error) {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO login_throttle (key, failures, last_failure_at) values ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN last_failure_at < $3 THEN 1 ELSE failures + 1 END,
+			last_failure_at = $2
+		WHERE blocked_until <= $2 AND locked_until <= $2 AND (last_failure_at < $3 OR failures < $4)
+		RETURNING failures, last_failure_at, blocked_until, locked_until
+		`,
+	)
+	if err != nil {
+		return false, err
+	}
+	err = stmt.QueryRow(t.Key, now, resetBefore, limit).Scan(&t.Failures, &t.LastFailureAt, &t.BlockedUntil, &t.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, t.Read(db)
+	}
+	return err == nil, err
+}
+
+// Forgive uncounts an attempt counted by Attempt.
+func (t *LoginThrottle) Forgive(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE login_throttle
+		SET failures = MAX(failures - 1, 0)
+		WHERE key = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(t.Key)
+	return err
+}
+
+// UpdateBlock blocks or locks out t.Key until t.BlockedUntil and
+// t.LockedUntil, unless it already is for longer.
+func (t *LoginThrottle) UpdateBlock(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE login_throttle
+		SET blocked_until = MAX(blocked_until, $1),
+			locked_until = MAX(locked_until, $2)
+		WHERE key = $3
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(t.BlockedUntil, t.LockedUntil, t.Key)
+	return err
+}
+
+// Delete forgets the failures of t.Key, lifting any block or lockout.
+func (t *LoginThrottle) Delete(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM login_throttle
+		WHERE key = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(t.Key)
+	return err
+}
+
+// DeleteExpiredLoginThrottles removes the throttles whose failures were
+// all before resetBefore and that are not blocked or locked out at now.
+func DeleteExpiredLoginThrottles(db *sql.DB, now, resetBefore int64) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM login_throttle
+		WHERE last_failure_at < $1 AND blocked_until <= $2 AND locked_until <= $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(resetBefore, now)
+	return err
+}
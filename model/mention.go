@@ -0,0 +1,223 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// mentionPattern matches "@" followed by an email address or a handle.
+	// The "@" must not follow a word character so that email addresses
+	// written without one are not taken for mentions of their domain.
+	mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)+|\w(?:[\w.-]*\w)?)`)
+	handlePattern  = regexp.MustCompile(`^\w(?:[\w.-]{0,30}\w)?$`)
+)
+
+// ValidHandle reports whether s can be used as User.Handle. Handles are at
+// most 32 characters long and cannot end in punctuation so that mentions
+// at the end of a sentence are recognized.
+func ValidHandle(s string) bool {
+	return handlePattern.MatchString(s)
+}
+
+// Mentions returns the lower cased email addresses and handles mentioned in
+// content, each once, in the order they first appear.
+func Mentions(content string) []string {
+	seen := map[string]bool{}
+	mentions := []string{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		mention := strings.ToLower(m[1])
+		if !seen[mention] {
+			seen[mention] = true
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions
+}
+
+// MentionSource is the content mentions are made in: the content of a
+// task, or one of its comments if CommentID is not 0.
+type MentionSource struct {
+	ProjectID int
+	TaskID    int
+	CommentID int
+	// AuthorID is the user who wrote the content. They are not notified of
+	// mentioning themselves.
+	AuthorID int
+}
+
+// Update replaces the mentions of the source with the users
+// mentioned in content and notifies the users who were not mentioned in it
+// before. Only active members of the project can be mentioned; other
+// mentions are left as plain text. The unread notifications of users who
+// are no longer mentioned are removed. It returns the IDs of the users who
+// were added.
+func (s MentionSource) Update(db *sql.DB, content string) ([]int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	mentioned, err := s.resolve(tx, Mentions(content))
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.read(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, userID := range current {
+		if slices.Contains(mentioned, userID) {
+			continue
+		}
+		_, err := tx.Exec(
+			`
+			DELETE FROM mention
+			WHERE task_id = $1 AND COALESCE(comment_id, 0) = $2 AND user_id = $3
+			`,
+			s.TaskID, s.CommentID, userID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(
+			`
+			DELETE FROM notification
+			WHERE task_id = $1 AND COALESCE(comment_id, 0) = $2 AND user_id = $3 AND type = $4 AND read_at IS NULL
+			`,
+			s.TaskID, s.CommentID, userID, NotificationMention,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().Unix()
+	added := []int{}
+	for _, userID := range mentioned {
+		if slices.Contains(current, userID) {
+			continue
+		}
+		_, err := tx.Exec(
+			`
+			INSERT INTO mention (task_id, comment_id, user_id, created_at)
+			values ($1, NULLIF($2, 0), $3, $4)
+			`,
+			s.TaskID, s.CommentID, userID, now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, userID)
+		if userID == s.AuthorID {
+			continue
+		}
+		n := Notification{
+			UserID:    userID,
+			Type:      NotificationMention,
+			ActorID:   s.AuthorID,
+			TaskID:    s.TaskID,
+			CommentID: s.CommentID,
+			CreatedAt: now,
+		}
+		if err := createNotification(tx, &n); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// resolve returns the IDs of the active project members with the email
+// addresses or handles in mentions in ascending order.
+func (s MentionSource) resolve(tx *sql.Tx, mentions []string) ([]int, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	q := query{}
+	q.and("pm.project_id = " + q.arg(s.ProjectID))
+	q.and("u.deactivated_at IS NULL")
+	emails, handles := []string{}, []string{}
+	for _, m := range mentions {
+		if strings.Contains(m, "@") {
+			emails = append(emails, m)
+		} else {
+			handles = append(handles, m)
+		}
+	}
+	// Arguments are added in the order their placeholders appear in the
+	// query.
+	matches := []string{}
+	if len(emails) > 0 {
+		matches = append(matches, "lower(u.email) IN ("+q.argList(emails)+")")
+	}
+	if len(handles) > 0 {
+		matches = append(matches, "lower(u.handle) IN ("+q.argList(handles)+")")
+	}
+	q.and("(" + strings.Join(matches, " OR ") + ")")
+
+	rows, err := tx.Query(
+		fmt.Sprintf(
+			`
+			SELECT u.id
+			FROM user u
+			INNER JOIN project_member pm
+			ON pm.user_id = u.id
+			%s
+			ORDER BY u.id
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// Mentioned returns the IDs of the users mentioned in the source in
+// ascending order.
+func (s MentionSource) Mentioned(db *sql.DB) ([]int, error) {
+	return s.read(db)
+}
+
+func (s MentionSource) read(db interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}) ([]int, error) {
+	rows, err := db.Query(
+		`
+		SELECT user_id
+		FROM mention
+		WHERE task_id = $1 AND COALESCE(comment_id, 0) = $2
+		ORDER BY user_id
+		`,
+		s.TaskID, s.CommentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func scanIDs(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,332 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Notification types. Users receive notifications of every type unless
+// they turn the type off in their NotificationPreferences.
+const (
+	NotificationAssignment   = "assignment"
+	NotificationStatusChange = "status_change"
+	NotificationMention      = "mention"
+	NotificationComment      = "comment"
+	NotificationDueReminder  = "due_reminder"
+)
+
+var NotificationTypes = []string{
+	NotificationAssignment,
+	NotificationStatusChange,
+	NotificationMention,
+	NotificationComment,
+	NotificationDueReminder,
+}
+
+// ValidNotificationType reports whether t is one of NotificationTypes.
+func ValidNotificationType(t string) bool {
+	return slices.Contains(NotificationTypes, t)
+}
+
+// Notification tells a user about something that happened to a task.
+type Notification struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Type   string `json:"type"`
+	// ActorID is the user whose action caused the notification, or 0 if it
+	// was caused by the application itself or the user has been deleted.
+	ActorID   int `json:"actor_id,omitempty"`
+	TaskID    int `json:"task_id,omitempty"`
+	CommentID int `json:"comment_id,omitempty"`
+	// Details describe the event, e.g. "todo -> doing" for a status change
+	// or "due Mon, 15 Jan 2024 14:00 EET" for a due reminder.
+	Details string `json:"details,omitempty"`
+	// DueAt is the due time of the task a due reminder is about.
+	DueAt     int64 `json:"due_at,omitempty"`
+	CreatedAt int64 `json:"created_at"`
+	// ReadAt is the Unix time the user read the notification, or 0 if they
+	// have not.
+	ReadAt int64 `json:"read_at,omitempty"`
+}
+
+type Notifications []Notification
+
+const notificationColumns = `id, user_id, type, COALESCE(actor_id, 0), COALESCE(task_id, 0), COALESCE(comment_id, 0),
+	details, COALESCE(due_at, 0), created_at, COALESCE(read_at, 0)`
+
+func scanNotification(row interface{ Scan(...interface{}) error }, n *Notification) error {
+	return row.Scan(
+		&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.TaskID, &n.CommentID,
+		&n.Details, &n.DueAt, &n.CreatedAt, &n.ReadAt,
+	)
+}
+
+// createNotification inserts the notification unless its user has turned
+// off notifications of its type, in which case n.ID is left 0.
+func createNotification(tx *sql.Tx, n *Notification) error {
+	err := tx.QueryRow(
+		`
+		INSERT INTO notification (user_id, type, actor_id, task_id, comment_id, details, due_at, created_at)
+		SELECT $1, $2, NULLIF($3, 0), NULLIF($4, 0), NULLIF($5, 0), $6, NULLIF($7, 0), $8
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM notification_preference
+			WHERE user_id = $1 AND type = $2 AND NOT enabled
+		)
+		RETURNING id
+		`,
+		n.UserID, n.Type, n.ActorID, n.TaskID, n.CommentID, n.Details, n.DueAt, n.CreatedAt,
+	).Scan(&n.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// Create inserts the notifications whose users receive notifications of
+// their type.
+func (ns Notifications) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range ns {
+		if err := createNotification(tx, &ns[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MarkRead marks the notification with n.ID of user n.UserID as read and
+// reads it into n. Notifications that have already been read keep their
+// read time. It returns sql.ErrNoRows if the user has no such notification.
+func (n *Notification) MarkRead(db *sql.DB, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE notification
+		SET read_at = COALESCE(read_at, $1)
+		WHERE id = $2 AND user_id = $3
+		RETURNING ` + notificationColumns,
+	)
+	if err != nil {
+		return err
+	}
+	return scanNotification(stmt.QueryRow(now, n.ID, n.UserID), n)
+}
+
+// MarkAllNotificationsRead marks the unread notifications of the user as
+// read and returns their number.
+func MarkAllNotificationsRead(db *sql.DB, userID int, now int64) (int64, error) {
+	stmt, err := db.Prepare(
+		`
+		UPDATE notification
+		SET read_at = $1
+		WHERE user_id = $2 AND read_at IS NULL
+		`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	res, err := stmt.Exec(now, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// NotificationFilter selects the notifications of a user for
+// Notifications.List, newest first.
+type NotificationFilter struct {
+	UserID int
+	Unread bool
+	// BeforeID is the ID of the last notification of the previous page.
+	BeforeID int
+	Limit    int
+}
+
+func (f NotificationFilter) query(paginate bool) query {
+	q := query{}
+	q.and("user_id = " + q.arg(f.UserID))
+	if f.Unread {
+		q.and("read_at IS NULL")
+	}
+	if paginate && f.BeforeID > 0 {
+		q.after("id", true, nil, f.BeforeID)
+	}
+	return q
+}
+
+func (ns *Notifications) List(db *sql.DB, f NotificationFilter) error {
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT %s
+			FROM notification
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			notificationColumns, q.whereClause(), orderBy("id", true), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n := Notification{}
+		if err := scanNotification(rows, &n); err != nil {
+			return err
+		}
+		*ns = append(*ns, n)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of notifications matching f, ignoring
+// pagination.
+func (ns *Notifications) Count(db *sql.DB, f NotificationFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM notification
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
+
+// CreateDueReminders notifies the assignees of open tasks in unarchived
+// projects that are due at or before before. A task is reminded of once per
+// due time, so moving the due time reminds of it again. The details of a
+// reminder give the due time in the assignee's time zone. It returns the
+// number of reminders created.
+func CreateDueReminders(db *sql.DB, now, before int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`
+		SELECT t.id, t.assignee_id, t.due_at, u.timezone
+		FROM task t
+		INNER JOIN project p
+		ON p.id = t.project_id
+		INNER JOIN user u
+		ON u.id = t.assignee_id
+		WHERE t.due_at IS NOT NULL AND t.completed_at IS NULL AND t.due_at <= $1
+			AND p.archived = 0 AND u.deactivated_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1
+				FROM notification n
+				WHERE n.task_id = t.id AND n.type = $2 AND n.user_id = t.assignee_id AND n.due_at = t.due_at
+			)
+		`,
+		before, NotificationDueReminder,
+	)
+	if err != nil {
+		return 0, err
+	}
+	reminders := Notifications{}
+	for rows.Next() {
+		n := Notification{Type: NotificationDueReminder, CreatedAt: now}
+		assignee := User{}
+		if err := rows.Scan(&n.TaskID, &n.UserID, &n.DueAt, &assignee.Timezone); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		n.Details = "due " + assignee.FormatTime(time.Unix(n.DueAt, 0))
+		reminders = append(reminders, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	created := int64(0)
+	for i := range reminders {
+		if err := createNotification(tx, &reminders[i]); err != nil {
+			return 0, err
+		}
+		if reminders[i].ID != 0 {
+			created++
+		}
+	}
+	return created, tx.Commit()
+}
+
+// NotificationPreferences tells whether a user receives notifications of
+// each of NotificationTypes.
+type NotificationPreferences map[string]bool
+
+// ReadByUser reads the preferences of the user. Types the user has not set
+// a preference for are enabled.
+func (p NotificationPreferences) ReadByUser(db *sql.DB, userID int) error {
+	for _, t := range NotificationTypes {
+		p[t] = true
+	}
+	rows, err := db.Query(
+		`
+		SELECT type, enabled
+		FROM notification_preference
+		WHERE user_id = $1
+		`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, enabled := "", false
+		if err := rows.Scan(&t, &enabled); err != nil {
+			return err
+		}
+		p[t] = enabled
+	}
+	return rows.Err()
+}
+
+// Update stores the preferences in p for the user. Types missing from p
+// keep their earlier preference.
+func (p NotificationPreferences) Update(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for t, enabled := range p {
+		_, err := tx.Exec(
+			`
+			INSERT INTO notification_preference (user_id, type, enabled) values ($1, $2, $3)
+			ON CONFLICT (user_id, type) DO UPDATE
+			SET enabled = $3
+			`,
+			userID, t, enabled,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
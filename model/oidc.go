@@ -0,0 +1,118 @@
+package model
+
+import (
+	"database/sql"
+)
+
+// OIDCLogin is a login started at an identity provider. Times are Unix
+// seconds.
+type OIDCLogin struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    int64
+	ExpiresAt    int64
+}
+
+// Create stores the login and removes expired ones.
+func (l *OIDCLogin) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`
+		DELETE FROM oidc_login
+		WHERE expires_at <= $1
+		`,
+		l.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`
+		INSERT INTO oidc_login (state_hash, nonce, code_verifier, created_at, expires_at)
+		values ($1, $2, $3, $4, $5)
+		`,
+		l.StateHash, l.Nonce, l.CodeVerifier, l.CreatedAt, l.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Consume removes the unexpired login with l.StateHash and reads it. It
+// returns sql.ErrNoRows if there is no such login.
+func (l *OIDCLogin) Consume(db *sql.DB, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM oidc_login
+		WHERE state_hash = $1 AND expires_at > $2
+		RETURNING nonce, code_verifier, created_at, expires_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(l.StateHash, now).Scan(&l.Nonce, &l.CodeVerifier, &l.CreatedAt, &l.ExpiresAt)
+}
+
+// UserIdentity links a user to their account at an identity provider.
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      int
+	CreatedAt   int64
+	LastLoginAt int64
+}
+
+func (i *UserIdentity) Create(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO user_identity (issuer, subject, user_id, created_at, last_login_at)
+		values ($1, $2, $3, $4, $4)
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(i.Issuer, i.Subject, i.UserID, i.CreatedAt)
+	return err
+}
+
+// Read reads the identity with i.Issuer and i.Subject.
+func (i *UserIdentity) Read(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT user_id, created_at, COALESCE(last_login_at, 0)
+		FROM user_identity
+		WHERE issuer = $1 AND subject = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(i.Issuer, i.Subject).Scan(&i.UserID, &i.CreatedAt, &i.LastLoginAt)
+}
+
+func (i *UserIdentity) UpdateLastLogin(db *sql.DB, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user_identity
+		SET last_login_at = $1
+		WHERE issuer = $2 AND subject = $3
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(now, i.Issuer, i.Subject)
+	if err == nil {
+		i.LastLoginAt = now
+	}
+	return err
+}
@@ -7,8 +7,8 @@ import (
 )
 
 type Permission struct {
-	ID   int
-	Name string
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
 
 type Permissions []Permission
@@ -21,6 +21,7 @@ func (ps *Permissions) ReadRolePermissions(db *sql.DB, roleID constants.RoleID)
 		INNER JOIN permission p
 		ON p.id = rp.permission_id
 		WHERE rp.role_id = $1
+		ORDER BY p.id
 		`,
 	)
 	if err != nil {
@@ -31,7 +32,25 @@ func (ps *Permissions) ReadRolePermissions(db *sql.DB, roleID constants.RoleID)
 	if err != nil {
 		return err
 	}
+	return ps.scan(rows)
+}
 
+func (ps *Permissions) ReadAll(db *sql.DB) error {
+	rows, err := db.Query(
+		`
+		SELECT id, name
+		FROM permission
+		ORDER BY id
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return ps.scan(rows)
+}
+
+func (ps *Permissions) scan(rows *sql.Rows) error {
+	defer rows.Close()
 	for rows.Next() {
 		p := Permission{}
 		if err := rows.Scan(&p.ID, &p.Name); err != nil {
@@ -39,5 +58,16 @@ func (ps *Permissions) ReadRolePermissions(db *sql.DB, roleID constants.RoleID)
 		}
 		*ps = append(*ps, p)
 	}
-	return nil
+	return rows.Err()
+}
+
+// Has reports whether the permissions include name, either directly or
+// through the "all" permission.
+func (ps Permissions) Has(name string) bool {
+	for _, p := range ps {
+		if p.Name == name || p.Name == "all" {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"database/sql"
+)
+
+// PersonalAccessToken lets scripts call the API as a user without their
+// password. Only the hash of the token is stored. Times are Unix seconds.
+type PersonalAccessToken struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"-"`
+	Name      string `json:"name"`
+	TokenHash string `json:"-"`
+	// Scopes are the names of the permissions the token can be used for.
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	ExpiresAt int64    `json:"expires_at"`
+	// LastUsedAt is updated at most once a minute.
+	LastUsedAt int64 `json:"last_used_at,omitempty"`
+}
+
+type PersonalAccessTokens []PersonalAccessToken
+
+// Allows reports whether the token's scopes include permission, either
+// directly or through the "all" permission.
+func (t PersonalAccessToken) Allows(permission string) bool {
+	for _, s := range t.Scopes {
+		if s == permission || s == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// Create inserts the token with the permissions as its scopes.
+func (t *PersonalAccessToken) Create(db *sql.DB, permissions Permissions) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`
+		INSERT INTO personal_access_token (user_id, name, token_hash, created_at, expires_at)
+		values ($1, $2, $3, $4, $5)
+		RETURNING id
+		`,
+		t.UserID, t.Name, t.TokenHash, t.CreatedAt, t.ExpiresAt,
+	).Scan(&t.ID)
+	if err != nil {
+		return err
+	}
+	t.Scopes = []string{}
+	for _, p := range permissions {
+		_, err = tx.Exec(
+			`
+			INSERT INTO personal_access_token_permission (token_id, permission_id) values ($1, $2)
+			`,
+			t.ID, p.ID,
+		)
+		if err != nil {
+			return err
+		}
+		t.Scopes = append(t.Scopes, p.Name)
+	}
+	return tx.Commit()
+}
+
+// ReadByTokenHash reads the token with t.TokenHash together with its
+// scopes.
+func (t *PersonalAccessToken) ReadByTokenHash(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT id, user_id, name, created_at, expires_at, COALESCE(last_used_at, 0)
+		FROM personal_access_token
+		WHERE token_hash = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	err = stmt.QueryRow(t.TokenHash).Scan(&t.ID, &t.UserID, &t.Name, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt)
+	if err != nil {
+		return err
+	}
+	return t.readScopes(db)
+}
+
+func (t *PersonalAccessToken) readScopes(db *sql.DB) error {
+	rows, err := db.Query(
+		`
+		SELECT p.name
+		FROM personal_access_token_permission tp
+		INNER JOIN permission p
+		ON p.id = tp.permission_id
+		WHERE tp.token_id = $1
+		ORDER BY p.id
+		`,
+		t.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	t.Scopes = []string{}
+	for rows.Next() {
+		name := ""
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		t.Scopes = append(t.Scopes, name)
+	}
+	return rows.Err()
+}
+
+// Touch records that the token was used at now, unless that was already
+// recorded within the last minute.
+func (t *PersonalAccessToken) Touch(db *sql.DB, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE personal_access_token
+		SET last_used_at = $1
+		WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $1 - 60)
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(now, t.ID)
+	return err
+}
+
+// Delete revokes the token with t.ID if it belongs to t.UserID.
+func (t *PersonalAccessToken) Delete(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM personal_access_token
+		WHERE id = $1 AND user_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(t.ID, t.UserID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// ReadByUser reads the user's tokens, newest first.
+func (ts *PersonalAccessTokens) ReadByUser(db *sql.DB, userID int) error {
+	rows, err := db.Query(
+		`
+		SELECT id, user_id, name, created_at, expires_at, COALESCE(last_used_at, 0)
+		FROM personal_access_token
+		WHERE user_id = $1
+		ORDER BY id DESC
+		`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := PersonalAccessToken{}
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+			return err
+		}
+		*ts = append(*ts, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range *ts {
+		if err := (*ts)[i].readScopes(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,10 @@ package model
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
 )
 
 type Project struct {
@@ -9,24 +13,81 @@ type Project struct {
 	UserID      int
 	Name        string
 	Description string
+	Archived    bool
+	CreatedAt   int64
+	UpdatedAt   int64
+	// Version is incremented on every change to the project. Update
+	// requires it to match unless it is 0.
+	Version int
+}
+
+type Projects []Project
+
+// ProjectFilter selects and orders projects for Projects.List. The zero
+// value lists every project by ascending ID.
+type ProjectFilter struct {
+	UserID int
+	// MemberID, if set, restricts the projects to those the user is a
+	// member of.
+	MemberID   int
+	NamePrefix string
+	Archived   sql.NullBool
+	// Sort is one of ProjectSorts, optionally prefixed with "-" for
+	// descending order.
+	Sort string
+	// AfterID and AfterValue are the ID and sort column value of the last
+	// project of the previous page.
+	AfterID    int
+	AfterValue string
+	Limit      int
+}
+
+var ProjectSorts = []string{"id", "name"}
+
+// ValidProjectSort reports whether sort can be used as ProjectFilter.Sort.
+func ValidProjectSort(sort string) bool {
+	_, _, ok := sortColumn(sort, ProjectSorts...)
+	return ok
 }
 
+// Create inserts the project with the default workflow and makes its user
+// the project owner.
 func (p *Project) Create(db *sql.DB) error {
-	stmt, err := db.Prepare(
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`
+		INSERT INTO project (user_id, name, description, created_at, updated_at) values ($1, $2, $3, $4, $4)
+		RETURNING id, created_at, updated_at, version
+		`,
+		p.UserID, p.Name, p.Description, time.Now().Unix(),
+	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.Version)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
 		`
-		INSERT INTO project (user_id, name, description) values ($1, $2, $3) RETURNING id
+		INSERT INTO project_member (project_id, user_id, role) values ($1, $2, $3)
 		`,
+		p.ID, p.UserID, constants.ProjectOwner,
 	)
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(p.UserID, p.Name, p.Description).Scan(&p.ID)
+	if err := createWorkflow(tx, p.ID, DefaultWorkflow); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (p *Project) ReadByID(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
-		SELECT user_id, name, description
+		SELECT user_id, name, description, archived, created_at, updated_at, version
 		FROM project
 		WHERE id = $1
 		`,
@@ -34,23 +95,31 @@ func (p *Project) ReadByID(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(p.ID).Scan(&p.UserID, &p.Name, &p.Description)
+	return stmt.QueryRow(p.ID).Scan(&p.UserID, &p.Name, &p.Description, &p.Archived, &p.CreatedAt, &p.UpdatedAt, &p.Version)
 }
 
+// Update updates the project if its version is still p.Version, or
+// regardless of its version if p.Version is 0. It returns sql.ErrNoRows if
+// the project does not exist or its version does not match.
 func (p *Project) Update(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
 		UPDATE project
 		SET name = $1,
-			description = $2
-		WHERE id = $3
-		RETURNING user_id, name, description
+			description = $2,
+			archived = $3,
+			version = version + 1,
+			updated_at = $4
+		WHERE id = $5 AND ($6 = 0 OR version = $6)
+		RETURNING user_id, name, description, archived, created_at, updated_at, version
 		`,
 	)
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(p.Name, p.Description, p.ID).Scan(&p.UserID, &p.Name, &p.Description)
+	return stmt.QueryRow(p.Name, p.Description, p.Archived, time.Now().Unix(), p.ID, p.Version).Scan(
+		&p.UserID, &p.Name, &p.Description, &p.Archived, &p.CreatedAt, &p.UpdatedAt, &p.Version,
+	)
 }
 
 func (p *Project) Delete(db *sql.DB) error {
@@ -66,3 +135,82 @@ func (p *Project) Delete(db *sql.DB) error {
 	_, err = stmt.Exec(p.ID)
 	return err
 }
+
+func (f ProjectFilter) query(paginate bool) query {
+	q := query{}
+	if f.UserID > 0 {
+		q.and("user_id = " + q.arg(f.UserID))
+	}
+	if f.MemberID > 0 {
+		q.and("id IN (SELECT project_id FROM project_member WHERE user_id = " + q.arg(f.MemberID) + ")")
+	}
+	if f.NamePrefix != "" {
+		q.and(`name LIKE ` + q.arg(likePrefix(f.NamePrefix)) + ` ESCAPE '\'`)
+	}
+	if f.Archived.Valid {
+		q.and("archived = " + q.arg(f.Archived.Bool))
+	}
+	if paginate && f.AfterID > 0 {
+		column, desc, _ := sortColumn(f.sort(), ProjectSorts...)
+		q.after(column, desc, f.AfterValue, f.AfterID)
+	}
+	return q
+}
+
+func (f ProjectFilter) sort() string {
+	if ValidProjectSort(f.Sort) {
+		return f.Sort
+	}
+	return "id"
+}
+
+func (ps *Projects) List(db *sql.DB, f ProjectFilter) error {
+	column, desc, _ := sortColumn(f.sort(), ProjectSorts...)
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT id, user_id, name, description, archived, created_at, updated_at, version
+			FROM project
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			q.whereClause(), orderBy(column, desc), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := Project{}
+		err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.Description, &p.Archived, &p.CreatedAt, &p.UpdatedAt, &p.Version)
+		if err != nil {
+			return err
+		}
+		*ps = append(*ps, p)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of projects matching f, ignoring pagination.
+func (ps *Projects) Count(db *sql.DB, f ProjectFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM project
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// ProjectEvent is an entry in the event log of a project, which project
+// event streams are sent and resumed from. Type is one of the task events
+// of WebhookEvents.
+type ProjectEvent struct {
+	ID        int             `json:"id"`
+	ProjectID int             `json:"project_id"`
+	Type      string          `json:"type"`
+	TaskID    int             `json:"task_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+type ProjectEvents []ProjectEvent
+
+const projectEventColumns = `id, project_id, type, COALESCE(task_id, 0), data, created_at`
+
+func scanProjectEvent(row interface{ Scan(...interface{}) error }, e *ProjectEvent) error {
+	data := ""
+	if err := row.Scan(&e.ID, &e.ProjectID, &e.Type, &e.TaskID, &data, &e.CreatedAt); err != nil {
+		return err
+	}
+	e.Data = json.RawMessage(data)
+	return nil
+}
+
+// Create appends the event to the log and removes the events created
+// before retainAfter.
+func (e *ProjectEvent) Create(db *sql.DB, retainAfter int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = scanProjectEvent(
+		tx.QueryRow(
+			`
+			INSERT INTO project_event (project_id, type, task_id, data, created_at)
+			values ($1, $2, NULLIF($3, 0), $4, $5)
+			RETURNING `+projectEventColumns,
+			e.ProjectID, e.Type, e.TaskID, string(e.Data), e.CreatedAt,
+		),
+		e,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`
+		DELETE FROM project_event
+		WHERE created_at < $1
+		`,
+		retainAfter,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListAfter reads at most limit events of the project with IDs greater than
+// afterID, oldest first.
+func (es *ProjectEvents) ListAfter(db *sql.DB, projectID, afterID, limit int) error {
+	rows, err := db.Query(
+		`
+		SELECT `+projectEventColumns+`
+		FROM project_event
+		WHERE project_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+		`,
+		projectID, afterID, limit,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := ProjectEvent{}
+		if err := scanProjectEvent(rows, &e); err != nil {
+			return err
+		}
+		*es = append(*es, e)
+	}
+	return rows.Err()
+}
+
+// LastProjectEventID returns the ID of the latest event in the log, or 0 if
+// the log is empty. IDs are shared by all projects, so streams of any
+// project may start after it.
+func LastProjectEventID(db *sql.DB) (int, error) {
+	id := 0
+	err := db.QueryRow(
+		`
+		SELECT COALESCE(MAX(id), 0)
+		FROM project_event
+		`,
+	).Scan(&id)
+	return id, err
+}
+
+// OldestProjectEventID returns the ID of the oldest event in the log, or
+// the ID the next event will get if the log is empty. Events with smaller
+// IDs have been removed from the log.
+func OldestProjectEventID(db *sql.DB) (int, error) {
+	id := 0
+	err := db.QueryRow(
+		`
+		SELECT COALESCE(
+			(SELECT MIN(id) FROM project_event),
+			(SELECT seq + 1 FROM sqlite_sequence WHERE name = 'project_event'),
+			1
+		)
+		`,
+	).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"database/sql"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+)
+
+type ProjectMember struct {
+	ProjectID int                   `json:"project_id"`
+	UserID    int                   `json:"user_id"`
+	Email     string                `json:"email"`
+	Role      constants.ProjectRole `json:"role"`
+}
+
+type ProjectMembers []ProjectMember
+
+func (m *ProjectMember) Create(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO project_member (project_id, user_id, role) values ($1, $2, $3)
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(m.ProjectID, m.UserID, m.Role)
+	return err
+}
+
+func (m *ProjectMember) Read(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT u.email, pm.role
+		FROM project_member pm
+		INNER JOIN user u
+		ON u.id = pm.user_id
+		WHERE pm.project_id = $1 AND pm.user_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(m.ProjectID, m.UserID).Scan(&m.Email, &m.Role)
+}
+
+func (m *ProjectMember) UpdateRole(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE project_member
+		SET role = $1
+		WHERE project_id = $2 AND user_id = $3
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(m.Role, m.ProjectID, m.UserID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+func (m *ProjectMember) Delete(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM project_member
+		WHERE project_id = $1 AND user_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(m.ProjectID, m.UserID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+func (ms *ProjectMembers) ReadProjectMembers(db *sql.DB, projectID int) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT pm.project_id, pm.user_id, u.email, pm.role
+		FROM project_member pm
+		INNER JOIN user u
+		ON u.id = pm.user_id
+		WHERE pm.project_id = $1
+		ORDER BY pm.user_id
+		`,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := stmt.Query(projectID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := ProjectMember{}
+		if err := rows.Scan(&m.ProjectID, &m.UserID, &m.Email, &m.Role); err != nil {
+			return err
+		}
+		*ms = append(*ms, m)
+	}
+	return rows.Err()
+}
+
+// Owners returns the number of owners among the members.
+func (ms ProjectMembers) Owners() int {
+	count := 0
+	for _, m := range ms {
+		if m.Role == constants.ProjectOwner {
+			count++
+		}
+	}
+	return count
+}
+
+// expectRow returns sql.ErrNoRows if res did not affect any rows.
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
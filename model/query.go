@@ -0,0 +1,94 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// query accumulates WHERE conditions and their numbered arguments for list
+// queries whose filters are only known at run time.
+type query struct {
+	where []string
+	args  []interface{}
+}
+
+// arg appends v to the arguments and returns its placeholder.
+func (q *query) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// argList appends values to the arguments and returns their placeholders
+// separated by commas, e.g. for an IN list.
+func (q *query) argList(values []string) string {
+	placeholders := []string{}
+	for _, v := range values {
+		placeholders = append(placeholders, q.arg(v))
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+func (q *query) and(cond string) {
+	q.where = append(q.where, cond)
+}
+
+func (q *query) whereClause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(q.where, " AND ")
+}
+
+// after restricts the query to rows that come after the row with the given
+// sort value and ID when ordered by column and then by id. column may be
+// "id", in which case value is ignored.
+func (q *query) after(column string, desc bool, value interface{}, id int) {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if column == "id" {
+		q.and(fmt.Sprintf("id %s %s", op, q.arg(id)))
+		return
+	}
+	v, i := q.arg(value), q.arg(id)
+	q.and(fmt.Sprintf("(%s %s %s OR (%s = %s AND id %s %s))", column, op, v, column, v, op, i))
+}
+
+// orderBy returns the ORDER BY expression matching after.
+func orderBy(column string, desc bool) string {
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	if column == "id" {
+		return "id " + dir
+	}
+	return fmt.Sprintf("%s %s, id %s", column, dir, dir)
+}
+
+// sortColumn splits a sort parameter such as "-name" into its column and
+// direction. ok is false if the column is not one of columns.
+func sortColumn(sort string, columns ...string) (column string, desc bool, ok bool) {
+	desc = strings.HasPrefix(sort, "-")
+	column = strings.TrimPrefix(sort, "-")
+	for _, c := range columns {
+		if c == column {
+			return column, desc, true
+		}
+	}
+	return "", false, false
+}
+
+// likePrefix escapes s for use as a LIKE pattern matching values that start
+// with s. Use it together with ESCAPE '\'.
+func likePrefix(s string) string {
+	return likeEscaper.Replace(s) + "%"
+}
+
+// likeContains is like likePrefix but matches s anywhere in the value.
+func likeContains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,224 @@
+package model
+
+import (
+	"database/sql"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+)
+
+type Role struct {
+	ID      constants.RoleID `json:"id"`
+	Name    string           `json:"name"`
+	BuiltIn bool             `json:"built_in"`
+	// TwoFactorRequired requires users with the role to enable two-factor
+	// authentication before they can use routes requiring a permission.
+	TwoFactorRequired bool        `json:"two_factor_required"`
+	Permissions       Permissions `json:"permissions"`
+}
+
+type Roles []Role
+
+// Create inserts the role and attaches permissionIDs to it.
+func (r *Role) Create(db *sql.DB, permissionIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`
+		INSERT INTO role (name) values ($1) RETURNING id
+		`,
+		r.Name,
+	).Scan(&r.ID)
+	if err != nil {
+		return err
+	}
+	for _, pID := range permissionIDs {
+		_, err = tx.Exec(
+			`
+			INSERT INTO role_permission (role_id, permission_id) values ($1, $2)
+			`,
+			r.ID, pID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.BuiltIn = r.ID.BuiltIn()
+	r.Permissions = Permissions{}
+	return r.Permissions.ReadRolePermissions(db, r.ID)
+}
+
+// ReadByID reads the role together with its permissions.
+func (r *Role) ReadByID(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT name, two_factor_required
+		FROM role
+		WHERE id = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	if err := stmt.QueryRow(r.ID).Scan(&r.Name, &r.TwoFactorRequired); err != nil {
+		return err
+	}
+	r.BuiltIn = r.ID.BuiltIn()
+	r.Permissions = Permissions{}
+	return r.Permissions.ReadRolePermissions(db, r.ID)
+}
+
+func (r *Role) UpdateName(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE role
+		SET name = $1
+		WHERE id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(r.Name, r.ID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// Delete removes the role and its permissions. Roles that are still
+// assigned to users cannot be deleted because of the user foreign key.
+func (r *Role) Delete(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`
+		DELETE FROM role_permission
+		WHERE role_id = $1
+		`,
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`
+		DELETE FROM role
+		WHERE id = $1
+		`,
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Role) AttachPermission(db *sql.DB, permissionID int) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO role_permission (role_id, permission_id) values ($1, $2)
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(r.ID, permissionID)
+	return err
+}
+
+func (r *Role) DetachPermission(db *sql.DB, permissionID int) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM role_permission
+		WHERE role_id = $1 AND permission_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(r.ID, permissionID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// UserCount returns the number of users with the role.
+func (r *Role) UserCount(db *sql.DB) (int, error) {
+	count := 0
+	err := db.QueryRow(
+		`
+		SELECT COUNT(*)
+		FROM user
+		WHERE role_id = $1
+		`,
+		r.ID,
+	).Scan(&count)
+	return count, err
+}
+
+// ReadAll reads every role together with its permissions.
+func (rs *Roles) ReadAll(db *sql.DB) error {
+	rows, err := db.Query(
+		`
+		SELECT id, name, two_factor_required
+		FROM role
+		ORDER BY id
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := Role{}
+		if err := rows.Scan(&r.ID, &r.Name, &r.TwoFactorRequired); err != nil {
+			return err
+		}
+		r.BuiltIn = r.ID.BuiltIn()
+		*rs = append(*rs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range *rs {
+		(*rs)[i].Permissions = Permissions{}
+		if err := (*rs)[i].Permissions.ReadRolePermissions(db, (*rs)[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdminCount returns the number of active users whose role has the "all"
+// permission.
+func AdminCount(db *sql.DB) (int, error) {
+	count := 0
+	err := db.QueryRow(
+		`
+		SELECT COUNT(*)
+		FROM user u
+		INNER JOIN role_permission rp
+		ON rp.role_id = u.role_id
+		INNER JOIN permission p
+		ON p.id = rp.permission_id
+		WHERE p.name = 'all' AND u.deactivated_at IS NULL
+		`,
+	).Scan(&count)
+	return count, err
+}
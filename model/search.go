@@ -0,0 +1,202 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+)
+
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// Snippets are generated with these control characters around matches so
+// that the text can be HTML escaped before the matches are wrapped in <mark>.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+var snippetReplacer = strings.NewReplacer(snippetStart, "<mark>", snippetEnd, "</mark>")
+
+type SearchResult struct {
+	Type      string               `json:"type"`
+	ID        int                  `json:"id"`
+	ProjectID int                  `json:"project_id"`
+	Title     string               `json:"title"`
+	Status    constants.TaskStatus `json:"status,omitempty"`
+	Snippet   string               `json:"snippet"`
+	Rank      float64              `json:"rank"`
+}
+
+type SearchResults []SearchResult
+
+// SearchFilter restricts a full-text search. Query uses the SQLite FTS5
+// query syntax: "quoted phrases", prefix* matching, AND, OR and NOT.
+type SearchFilter struct {
+	Query     string
+	ProjectID int
+	// MemberID, if set, restricts the results to projects the user is a
+	// member of.
+	MemberID int
+	Status   constants.TaskStatus
+	Tasks    bool
+	Projects bool
+	// AfterRank, AfterType and AfterID, if AfterType is set, restrict the
+	// results to those that come after the result with the given rank,
+	// type and ID.
+	AfterRank float64
+	AfterType string
+	AfterID   int
+	Limit     int
+}
+
+// union returns the query selecting the matching tasks and projects with
+// their columns, or an empty string if neither can match. Each row has
+// the score bm25 gives it, where lower is better.
+func (f SearchFilter) union(q *query, columns bool) string {
+	selects := []string{}
+	if f.Tasks {
+		q.and("task_fts MATCH " + q.arg(f.Query))
+		if f.ProjectID > 0 {
+			q.and("t.project_id = " + q.arg(f.ProjectID))
+		}
+		if f.MemberID > 0 {
+			q.and("t.project_id IN (SELECT project_id FROM project_member WHERE user_id = " + q.arg(f.MemberID) + ")")
+		}
+		if f.Status != "" {
+			q.and("t.status = " + q.arg(f.Status))
+		}
+		cols := "t.id"
+		if columns {
+			cols = fmt.Sprintf(
+				`'task' AS type, t.id AS id, t.project_id AS project_id,
+				t.title AS title, t.status AS status,
+				snippet(task_fts, -1, '%s', '%s', '…', 15) AS snippet,
+				bm25(task_fts, 2.0, 1.0) AS score`,
+				snippetStart, snippetEnd,
+			)
+		}
+		selects = append(selects, fmt.Sprintf(
+			`
+			SELECT %s
+			FROM task_fts
+			INNER JOIN task t
+			ON t.id = task_fts.rowid
+			%s
+			`,
+			cols, q.whereClause(),
+		))
+		q.where = nil
+	}
+	if f.Projects && f.Status == "" {
+		q.and("project_fts MATCH " + q.arg(f.Query))
+		if f.ProjectID > 0 {
+			q.and("p.id = " + q.arg(f.ProjectID))
+		}
+		if f.MemberID > 0 {
+			q.and("p.id IN (SELECT project_id FROM project_member WHERE user_id = " + q.arg(f.MemberID) + ")")
+		}
+		cols := "p.id"
+		if columns {
+			cols = fmt.Sprintf(
+				`'project' AS type, p.id AS id, p.id AS project_id,
+				p.name AS title, '' AS status,
+				snippet(project_fts, -1, '%s', '%s', '…', 15) AS snippet,
+				bm25(project_fts, 2.0, 1.0) AS score`,
+				snippetStart, snippetEnd,
+			)
+		}
+		selects = append(selects, fmt.Sprintf(
+			`
+			SELECT %s
+			FROM project_fts
+			INNER JOIN project p
+			ON p.id = project_fts.rowid
+			%s
+			`,
+			cols, q.whereClause(),
+		))
+		q.where = nil
+	}
+	return strings.Join(selects, "UNION ALL")
+}
+
+// Search finds at most f.Limit tasks and projects matching f, best match
+// first. Results with the same rank are ordered by type and ID.
+func (rs *SearchResults) Search(db *sql.DB, f SearchFilter) error {
+	q := query{}
+	union := f.union(&q, true)
+	if union == "" {
+		return nil
+	}
+	if f.AfterType != "" {
+		score, typ, id := q.arg(-f.AfterRank), q.arg(f.AfterType), q.arg(f.AfterID)
+		q.and(fmt.Sprintf(
+			"(score > %s OR (score = %s AND (type > %s OR (type = %s AND id > %s))))",
+			score, score, typ, typ, id,
+		))
+	}
+	rows, err := db.Query(
+		`
+		SELECT type, id, project_id, title, status, snippet, score
+		FROM (`+union+`)
+		`+q.whereClause()+`
+		ORDER BY score, type, id
+		LIMIT `+q.arg(f.Limit),
+		q.args...,
+	)
+	if err != nil {
+		return searchError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := SearchResult{}
+		score := 0.0
+		if err := rows.Scan(&r.Type, &r.ID, &r.ProjectID, &r.Title, &r.Status, &r.Snippet, &score); err != nil {
+			return err
+		}
+		r.Snippet = snippetReplacer.Replace(html.EscapeString(r.Snippet))
+		r.Rank = -score
+		*rs = append(*rs, r)
+	}
+	return searchError(rows.Err())
+}
+
+// Count counts the tasks and projects matching f, ignoring its limit and
+// position.
+func (rs *SearchResults) Count(db *sql.DB, f SearchFilter) (int, error) {
+	q := query{}
+	union := f.union(&q, false)
+	if union == "" {
+		return 0, nil
+	}
+	count := 0
+	err := db.QueryRow(
+		`
+		SELECT COUNT(*)
+		FROM (`+union+`)
+		`,
+		q.args...,
+	).Scan(&count)
+	return count, searchError(err)
+}
+
+// searchQueryErrors are the errors FTS5 reports for queries it cannot
+// parse.
+var searchQueryErrors = []string{"fts5: syntax error", "unterminated string", "unknown special query"}
+
+func searchError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, e := range searchQueryErrors {
+		if strings.Contains(err.Error(), e) {
+			return fmt.Errorf("%w: %s", ErrInvalidSearchQuery, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session is a single refresh token. Tokens issued from the same login share
+// a FamilyID; refreshing rotates the token within its family.
+type Session struct {
+	ID        int
+	FamilyID  string
+	UserID    int
+	TokenHash string
+	CreatedAt int64
+	ExpiresAt int64
+	RotatedAt sql.NullInt64
+	RevokedAt sql.NullInt64
+}
+
+func (s *Session) Create(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO session (family_id, user_id, token_hash, created_at, expires_at) values ($1, $2, $3, $4, $5)
+		RETURNING id
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(s.FamilyID, s.UserID, s.TokenHash, s.CreatedAt, s.ExpiresAt).Scan(&s.ID)
+}
+
+func (s *Session) ReadByTokenHash(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT id, family_id, user_id, created_at, expires_at, rotated_at, revoked_at
+		FROM session
+		WHERE token_hash = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(s.TokenHash).Scan(
+		&s.ID,
+		&s.FamilyID,
+		&s.UserID,
+		&s.CreatedAt,
+		&s.ExpiresAt,
+		&s.RotatedAt,
+		&s.RevokedAt,
+	)
+}
+
+// Rotate marks the session identified by TokenHash as used. It returns
+// sql.ErrNoRows if the token has already been rotated, revoked or has expired.
+func (s *Session) Rotate(db *sql.DB) error {
+	now := time.Now().Unix()
+	stmt, err := db.Prepare(
+		`
+		UPDATE session
+		SET rotated_at = $1
+		WHERE token_hash = $2 AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > $1
+		RETURNING id, family_id, user_id, created_at, expires_at, rotated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(now, s.TokenHash).Scan(
+		&s.ID,
+		&s.FamilyID,
+		&s.UserID,
+		&s.CreatedAt,
+		&s.ExpiresAt,
+		&s.RotatedAt,
+	)
+}
+
+func (s *Session) RevokeFamily(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE session
+		SET revoked_at = $1
+		WHERE family_id = $2 AND revoked_at IS NULL
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(time.Now().Unix(), s.FamilyID)
+	return err
+}
+
+// FamilyIsActive reports whether the session family has not been revoked.
+func (s *Session) FamilyIsActive(db *sql.DB) (bool, error) {
+	stmt, err := db.Prepare(
+		`
+		SELECT COUNT(*)
+		FROM session
+		WHERE family_id = $1 AND revoked_at IS NULL
+		`,
+	)
+	if err != nil {
+		return false, err
+	}
+	count := 0
+	if err := stmt.QueryRow(s.FamilyID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
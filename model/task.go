@@ -2,6 +2,8 @@ package model
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
 
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
 )
@@ -14,28 +16,50 @@ type Task struct {
 	Title      string               `json:"title"`
 	Content    string               `json:"content"`
 	Status     constants.TaskStatus `json:"status"`
+	// StartAt and DueAt are Unix times, or 0 if the task has none.
+	StartAt int64 `json:"start_at,omitempty"`
+	DueAt   int64 `json:"due_at,omitempty"`
+	// CompletedAt is the Unix time the task was moved to a done state of its
+	// project's workflow, or 0 if it is not done.
+	CompletedAt int64 `json:"completed_at,omitempty"`
+	CreatedAt   int64 `json:"created_at"`
+	UpdatedAt   int64 `json:"updated_at"`
+	// Version is incremented on every change to the task. Update requires
+	// it to match unless it is 0.
+	Version int `json:"version"`
 }
 
+// Create inserts the task. It is completed if it is created in a done state
+// of its project's workflow.
 func (t *Task) Create(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
-		INSERT INTO task (project_id, assignee_id, creator_id, title, content, status) values(
-			$1, $2, $3, $4, $5, $6
+		INSERT INTO task (
+			project_id, assignee_id, creator_id, title, content, status, start_at, due_at, completed_at,
+			created_at, updated_at
+		) values(
+			$1, $2, $3, $4, $5, $6, NULLIF($7, 0), NULLIF($8, 0),
+			CASE WHEN (SELECT done FROM task_state WHERE project_id = $1 AND name = $6) THEN $9 END,
+			$9, $9
 		)
-		RETURNING id
+		RETURNING id, COALESCE(completed_at, 0), created_at, updated_at, version
 		`,
 	)
 	if err != nil {
 		return err
 	}
 
-	return stmt.QueryRow(t.ProjectID, t.AssigneeID, t.CreatorID, t.Title, t.Content, t.Status).Scan(&t.ID)
+	return stmt.QueryRow(
+		t.ProjectID, t.AssigneeID, t.CreatorID, t.Title, t.Content, t.Status, t.StartAt, t.DueAt, time.Now().Unix(),
+	).Scan(&t.ID, &t.CompletedAt, &t.CreatedAt, &t.UpdatedAt, &t.Version)
 }
 
 func (t *Task) ReadByID(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
-		SELECT assignee_id, creator_id, title, content, status
+		SELECT assignee_id, creator_id, title, content, status,
+			COALESCE(start_at, 0), COALESCE(due_at, 0), COALESCE(completed_at, 0),
+			created_at, updated_at, version
 		FROM task
 		WHERE id = $1 AND project_id = $2
 		`,
@@ -43,9 +67,18 @@ func (t *Task) ReadByID(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(t.ID, t.ProjectID).Scan(&t.AssigneeID, &t.CreatorID, &t.Title, &t.Content, &t.Status)
+	return stmt.QueryRow(t.ID, t.ProjectID).Scan(
+		&t.AssigneeID, &t.CreatorID, &t.Title, &t.Content, &t.Status,
+		&t.StartAt, &t.DueAt, &t.CompletedAt,
+		&t.CreatedAt, &t.UpdatedAt, &t.Version,
+	)
 }
 
+// Update replaces the task's fields if its version is still t.Version, or
+// regardless of its version if t.Version is 0. It returns sql.ErrNoRows if
+// the task does not exist or its version does not match. The completion time
+// is set when the task moves to a done state of its project's workflow and
+// cleared when it moves out of one.
 func (t *Task) Update(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
@@ -54,9 +87,17 @@ func (t *Task) Update(db *sql.DB) error {
 			assignee_id = $2,
 			title = $3,
 			content = $4,
-			status = $5
-		WHERE id = $6 AND project_id = $7
-		RETURNING project_id, assignee_id, creator_id, title, content, status
+			status = $5,
+			start_at = NULLIF($6, 0),
+			due_at = NULLIF($7, 0),
+			completed_at = CASE WHEN (SELECT done FROM task_state WHERE project_id = $1 AND name = $5)
+				THEN COALESCE(completed_at, $8) END,
+			version = version + 1,
+			updated_at = $8
+		WHERE id = $9 AND project_id = $1 AND ($10 = 0 OR version = $10)
+		RETURNING project_id, assignee_id, creator_id, title, content, status,
+			COALESCE(start_at, 0), COALESCE(due_at, 0), COALESCE(completed_at, 0),
+			created_at, updated_at, version
 		`,
 	)
 	if err != nil {
@@ -67,15 +108,52 @@ func (t *Task) Update(db *sql.DB) error {
 		t.AssigneeID,
 		t.Title,
 		t.Content,
-		t.Title,
+		t.Status,
+		t.StartAt,
+		t.DueAt,
+		time.Now().Unix(),
 		t.ID,
-		t.ProjectID,
+		t.Version,
 	).Scan(&t.ProjectID,
 		&t.AssigneeID,
 		&t.CreatorID,
 		&t.Title,
 		&t.Content,
 		&t.Status,
+		&t.StartAt,
+		&t.DueAt,
+		&t.CompletedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.Version,
+	)
+}
+
+// Transition moves the task from status from to t.Status, updating its
+// completion time like Update. It returns sql.ErrNoRows if the task does not
+// exist or is no longer in status from.
+func (t *Task) Transition(db *sql.DB, from constants.TaskStatus) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE task
+		SET status = $1,
+			completed_at = CASE WHEN (SELECT done FROM task_state WHERE project_id = $2 AND name = $1)
+				THEN COALESCE(completed_at, $3) END,
+			version = version + 1,
+			updated_at = $3
+		WHERE id = $4 AND project_id = $2 AND status = $5
+		RETURNING assignee_id, creator_id, title, content, status,
+			COALESCE(start_at, 0), COALESCE(due_at, 0), COALESCE(completed_at, 0),
+			created_at, updated_at, version
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(t.Status, t.ProjectID, time.Now().Unix(), t.ID, from).Scan(
+		&t.AssigneeID, &t.CreatorID, &t.Title, &t.Content, &t.Status,
+		&t.StartAt, &t.DueAt, &t.CompletedAt,
+		&t.CreatedAt, &t.UpdatedAt, &t.Version,
 	)
 }
 
@@ -92,3 +170,200 @@ func (t *Task) Delete(db *sql.DB) error {
 	_, err = stmt.Exec(t.ID, t.ProjectID)
 	return err
 }
+
+type Tasks []Task
+
+// TaskFilter selects and orders the tasks of a project for Tasks.List.
+type TaskFilter struct {
+	ProjectID  int
+	Status     constants.TaskStatus
+	AssigneeID int
+	CreatorID  int
+	// Query matches tasks whose title or content contains it.
+	Query string
+	// Sort is one of TaskSorts, optionally prefixed with "-" for descending
+	// order.
+	Sort string
+	// AfterID and AfterValue are the ID and sort column value of the last
+	// task of the previous page.
+	AfterID    int
+	AfterValue string
+	Limit      int
+}
+
+var TaskSorts = []string{"id", "title", "status"}
+
+// ValidTaskSort reports whether sort can be used as TaskFilter.Sort.
+func ValidTaskSort(sort string) bool {
+	_, _, ok := sortColumn(sort, TaskSorts...)
+	return ok
+}
+
+func (f TaskFilter) sort() string {
+	if ValidTaskSort(f.Sort) {
+		return f.Sort
+	}
+	return "id"
+}
+
+func (f TaskFilter) query(paginate bool) query {
+	q := query{}
+	q.and("project_id = " + q.arg(f.ProjectID))
+	if f.Status != "" {
+		q.and("status = " + q.arg(f.Status))
+	}
+	if f.AssigneeID > 0 {
+		q.and("assignee_id = " + q.arg(f.AssigneeID))
+	}
+	if f.CreatorID > 0 {
+		q.and("creator_id = " + q.arg(f.CreatorID))
+	}
+	if f.Query != "" {
+		pattern := q.arg(likeContains(f.Query))
+		q.and(fmt.Sprintf(`(title LIKE %s ESCAPE '\' OR content LIKE %s ESCAPE '\')`, pattern, pattern))
+	}
+	if paginate && f.AfterID > 0 {
+		column, desc, _ := sortColumn(f.sort(), TaskSorts...)
+		q.after(column, desc, f.AfterValue, f.AfterID)
+	}
+	return q
+}
+
+func (ts *Tasks) List(db *sql.DB, f TaskFilter) error {
+	column, desc, _ := sortColumn(f.sort(), TaskSorts...)
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT id, project_id, assignee_id, creator_id, title, content, status,
+				COALESCE(start_at, 0), COALESCE(due_at, 0), COALESCE(completed_at, 0),
+				created_at, updated_at, version
+			FROM task
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			q.whereClause(), orderBy(column, desc), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	return ts.scan(rows)
+}
+
+func (ts *Tasks) scan(rows *sql.Rows) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		t := Task{}
+		err := rows.Scan(
+			&t.ID, &t.ProjectID, &t.AssigneeID, &t.CreatorID, &t.Title, &t.Content, &t.Status,
+			&t.StartAt, &t.DueAt, &t.CompletedAt,
+			&t.CreatedAt, &t.UpdatedAt, &t.Version,
+		)
+		if err != nil {
+			return err
+		}
+		*ts = append(*ts, t)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of tasks matching f, ignoring pagination.
+func (ts *Tasks) Count(db *sql.DB, f TaskFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM task
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
+
+// DueTaskFilter selects open tasks with a due time for Tasks.ListDue. Tasks
+// are ordered by due time, soonest first.
+type DueTaskFilter struct {
+	// MemberID restricts the tasks to the unarchived projects the user is a
+	// member of.
+	MemberID int
+	// DueFrom and DueBefore bound the due time: DueFrom <= due_at <
+	// DueBefore. A zero bound is open.
+	DueFrom   int64
+	DueBefore int64
+	// AfterID and AfterDue are the ID and due time of the last task of the
+	// previous page.
+	AfterID  int
+	AfterDue int64
+	Limit    int
+}
+
+func (f DueTaskFilter) query(paginate bool) query {
+	q := query{}
+	q.and("due_at IS NOT NULL AND completed_at IS NULL")
+	q.and(
+		"project_id IN (SELECT id FROM project WHERE archived = 0 AND id IN " +
+			"(SELECT project_id FROM project_member WHERE user_id = " + q.arg(f.MemberID) + "))",
+	)
+	if f.DueFrom > 0 {
+		q.and("due_at >= " + q.arg(f.DueFrom))
+	}
+	if f.DueBefore > 0 {
+		q.and("due_at < " + q.arg(f.DueBefore))
+	}
+	if paginate && f.AfterID > 0 {
+		q.after("due_at", false, f.AfterDue, f.AfterID)
+	}
+	return q
+}
+
+func (ts *Tasks) ListDue(db *sql.DB, f DueTaskFilter) error {
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT id, project_id, assignee_id, creator_id, title, content, status,
+				COALESCE(start_at, 0), COALESCE(due_at, 0), COALESCE(completed_at, 0),
+				created_at, updated_at, version
+			FROM task
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			q.whereClause(), orderBy("due_at", false), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	return ts.scan(rows)
+}
+
+// CountDue returns the number of tasks matching f, ignoring pagination.
+func (ts *Tasks) CountDue(db *sql.DB, f DueTaskFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM task
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
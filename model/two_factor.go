@@ -0,0 +1,263 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+)
+
+// UserTOTP is the TOTP secret of a user. Times are Unix seconds.
+type UserTOTP struct {
+	UserID int
+	Secret string
+	// LastCounter is the time step of the last accepted code.
+	LastCounter int64
+	CreatedAt   int64
+}
+
+// Create stores the secret, replacing an earlier one of the user.
+func (t *UserTOTP) Create(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO user_totp (user_id, secret, created_at) values ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = $2,
+			last_counter = 0,
+			created_at = $3
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(t.UserID, t.Secret, t.CreatedAt)
+	return err
+}
+
+func (t *UserTOTP) Read(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT secret, last_counter, created_at
+		FROM user_totp
+		WHERE user_id = $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(t.UserID).Scan(&t.Secret, &t.LastCounter, &t.CreatedAt)
+}
+
+// UseCounter records counter as the time step of the last accepted code.
+// It returns sql.ErrNoRows if a code of the same or a later time step has
+// already been accepted.
+func (t *UserTOTP) UseCounter(db *sql.DB, counter int64) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user_totp
+		SET last_counter = $1
+		WHERE user_id = $2 AND last_counter < $1
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(counter, t.UserID)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	t.LastCounter = counter
+	return nil
+}
+
+// EnableTwoFactor marks the user's TOTP secret as confirmed and replaces
+// their recovery codes with codeHashes.
+func (u *User) EnableTwoFactor(db *sql.DB, codeHashes []string, now int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	version := 0
+	err = tx.QueryRow(
+		`
+		UPDATE user
+		SET two_factor_enabled_at = $1,
+			version = version + 1,
+			updated_at = $1
+		WHERE id = $2
+		RETURNING version
+		`,
+		now, u.ID,
+	).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, u.ID, codeHashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	u.TwoFactorEnabledAt = now
+	u.Version = version
+	u.UpdatedAt = now
+	return nil
+}
+
+// DisableTwoFactor removes the user's TOTP secret and recovery codes.
+func (u *User) DisableTwoFactor(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	version := 0
+	err = tx.QueryRow(
+		`
+		UPDATE user
+		SET two_factor_enabled_at = NULL,
+			version = version + 1,
+			updated_at = $1
+		WHERE id = $2
+		RETURNING version
+		`,
+		now, u.ID,
+	).Scan(&version)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`
+		DELETE FROM user_totp
+		WHERE user_id = $1
+		`,
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, u.ID, nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	u.TwoFactorEnabledAt = 0
+	u.Version = version
+	u.UpdatedAt = now
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces the user's recovery codes with codeHashes.
+func (u *User) ReplaceRecoveryCodes(db *sql.DB, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, u.ID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	_, err := tx.Exec(
+		`
+		DELETE FROM recovery_code
+		WHERE user_id = $1
+		`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		_, err := tx.Exec(
+			`
+			INSERT INTO recovery_code (user_id, code_hash) values ($1, $2)
+			`,
+			userID, h,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the user's recovery code with codeHash used. It
+// returns sql.ErrNoRows if the user has no such unused code.
+func (u *User) UseRecoveryCode(db *sql.DB, codeHash string, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE recovery_code
+		SET used_at = $1
+		WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(now, u.ID, codeHash)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the
+// user.
+func (u *User) RecoveryCodesLeft(db *sql.DB) (int, error) {
+	count := 0
+	err := db.QueryRow(
+		`
+		SELECT COUNT(*)
+		FROM recovery_code
+		WHERE user_id = $1 AND used_at IS NULL
+		`,
+		u.ID,
+	).Scan(&count)
+	return count, err
+}
+
+// TwoFactorRequired reports whether users with the role must enable
+// two-factor authentication.
+func TwoFactorRequired(db *sql.DB, roleID constants.RoleID) (bool, error) {
+	required := false
+	err := db.QueryRow(
+		`
+		SELECT two_factor_required
+		FROM role
+		WHERE id = $1
+		`,
+		roleID,
+	).Scan(&required)
+	return required, err
+}
+
+func (r *Role) UpdateTwoFactorRequired(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE role
+		SET two_factor_required = $1
+		WHERE id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(r.TwoFactorRequired, r.ID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
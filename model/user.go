@@ -2,6 +2,9 @@ package model
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/tomihaapalainen/go-task-mgmt/constants"
 )
@@ -11,24 +14,109 @@ type User struct {
 	Email        string           `json:"email"`
 	PasswordHash string           `json:"-"`
 	RoleID       constants.RoleID `json:"role_id"`
+	TokenVersion int              `json:"-"`
+	// DeactivatedAt is the Unix time the user was deactivated, or 0 if the
+	// user is active.
+	DeactivatedAt int64  `json:"deactivated_at,omitempty"`
+	DisplayName   string `json:"display_name"`
+	// Handle is the unique name the user can be mentioned with, e.g.
+	// "@alice", or empty if they have none.
+	Handle string `json:"handle,omitempty"`
+	// Timezone is an IANA time zone name used to render times for the user.
+	Timezone string `json:"timezone"`
+	Locale   string `json:"locale"`
+	// Avatar is the file name of the user's avatar in config.AVATAR_DIR.
+	Avatar string `json:"avatar,omitempty"`
+	// EmailVerifiedAt is the Unix time the user confirmed their email
+	// address, or 0 if they have not.
+	EmailVerifiedAt int64 `json:"email_verified_at,omitempty"`
+	// TwoFactorEnabledAt is the Unix time the user enabled TOTP two-factor
+	// authentication, or 0 if they have not.
+	TwoFactorEnabledAt int64 `json:"two_factor_enabled_at,omitempty"`
+	CreatedAt          int64 `json:"created_at"`
+	UpdatedAt          int64 `json:"updated_at"`
+	// Version is incremented on every change to the user.
+	Version int `json:"version"`
+}
+
+type Users []User
+
+// UserFilter selects and orders users for Users.List. The zero value lists
+// every user by ascending ID.
+type UserFilter struct {
+	// Query matches users whose email contains it.
+	Query       string
+	RoleID      constants.RoleID
+	Deactivated sql.NullBool
+	// Sort is one of UserSorts, optionally prefixed with "-" for descending
+	// order.
+	Sort string
+	// AfterID and AfterValue are the ID and sort column value of the last
+	// user of the previous page.
+	AfterID    int
+	AfterValue string
+	Limit      int
+}
+
+var UserSorts = []string{"id", "email"}
+
+// ValidUserSort reports whether sort can be used as UserFilter.Sort.
+func ValidUserSort(sort string) bool {
+	_, _, ok := sortColumn(sort, UserSorts...)
+	return ok
+}
+
+func (u User) Active() bool {
+	return u.DeactivatedAt == 0
+}
+
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != 0
+}
+
+func (u User) TwoFactorEnabled() bool {
+	return u.TwoFactorEnabledAt != 0
+}
+
+// Location returns the user's time zone, or UTC if it is unset or unknown.
+func (u User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// FormatTime renders t in the user's time zone, e.g. for due dates in
+// notifications.
+func (u User) FormatTime(t time.Time) string {
+	return t.In(u.Location()).Format("Mon, 02 Jan 2006 15:04 MST")
 }
 
 func (u *User) Create(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
-		INSERT INTO user (email, password_hash, role_id) values ($1, $2, $3) RETURNING id
+		INSERT INTO user (email, password_hash, role_id, created_at, updated_at) values ($1, $2, $3, $4, $4)
+		RETURNING id, created_at, updated_at, version
 		`,
 	)
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(u.Email, u.PasswordHash, u.RoleID).Scan(&u.ID)
+	return stmt.QueryRow(u.Email, u.PasswordHash, u.RoleID, time.Now().Unix()).Scan(
+		&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version,
+	)
 }
 
 func (u *User) ReadByID(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
-		SELECT email, password_hash, role_id
+		SELECT email, password_hash, role_id, token_version, COALESCE(deactivated_at, 0),
+			display_name, COALESCE(handle, ''), timezone, locale, avatar, COALESCE(email_verified_at, 0),
+			COALESCE(two_factor_enabled_at, 0), created_at, updated_at, version
 		FROM user
 		WHERE id = $1
 		`,
@@ -36,13 +124,19 @@ func (u *User) ReadByID(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(u.ID).Scan(&u.Email, &u.PasswordHash, &u.RoleID)
+	return stmt.QueryRow(u.ID).Scan(
+		&u.Email, &u.PasswordHash, &u.RoleID, &u.TokenVersion, &u.DeactivatedAt,
+		&u.DisplayName, &u.Handle, &u.Timezone, &u.Locale, &u.Avatar, &u.EmailVerifiedAt,
+		&u.TwoFactorEnabledAt, &u.CreatedAt, &u.UpdatedAt, &u.Version,
+	)
 }
 
 func (u *User) ReadByEmail(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
-		SELECT id, password_hash, role_id
+		SELECT id, password_hash, role_id, token_version, COALESCE(deactivated_at, 0),
+			display_name, COALESCE(handle, ''), timezone, locale, avatar, COALESCE(email_verified_at, 0),
+			COALESCE(two_factor_enabled_at, 0), created_at, updated_at, version
 		FROM user
 		WHERE email = $1
 		`,
@@ -50,20 +144,398 @@ func (u *User) ReadByEmail(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	return stmt.QueryRow(u.Email).Scan(&u.ID, &u.PasswordHash, &u.RoleID)
+	return stmt.QueryRow(u.Email).Scan(
+		&u.ID, &u.PasswordHash, &u.RoleID, &u.TokenVersion, &u.DeactivatedAt,
+		&u.DisplayName, &u.Handle, &u.Timezone, &u.Locale, &u.Avatar, &u.EmailVerifiedAt,
+		&u.TwoFactorEnabledAt, &u.CreatedAt, &u.UpdatedAt, &u.Version,
+	)
 }
 
 func (u *User) UpdateRole(db *sql.DB) error {
 	stmt, err := db.Prepare(
 		`
 		UPDATE user
-		SET role_id = $1
+		SET role_id = $1,
+			token_version = token_version + 1,
+			version = version + 1,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING token_version, version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(u.RoleID, time.Now().Unix(), u.ID).Scan(&u.TokenVersion, &u.Version, &u.UpdatedAt)
+}
+
+// UpdatePasswordHash replaces the hash of the user's password without
+// touching their sessions, e.g. to rehash it with a higher cost. Use
+// ChangePassword to change the password.
+func (u *User) UpdatePasswordHash(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user
+		SET password_hash = $1,
+			version = version + 1,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(u.PasswordHash, time.Now().Unix(), u.ID).Scan(&u.Version, &u.UpdatedAt)
+}
+
+// ChangePassword stores u.PasswordHash as the user's password. Access
+// tokens issued before the change are invalidated by bumping the user's
+// token version, and all sessions are revoked.
+func (u *User) ChangePassword(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	err = tx.QueryRow(
+		`
+		UPDATE user
+		SET password_hash = $1,
+			token_version = token_version + 1,
+			version = version + 1,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING token_version, version, updated_at
+		`,
+		u.PasswordHash, now, u.ID,
+	).Scan(&u.TokenVersion, &u.Version, &u.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`
+		UPDATE session
+		SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+		`,
+		now, u.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (u *User) UpdateProfile(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user
+		SET display_name = $1,
+			handle = NULLIF($2, ''),
+			timezone = $3,
+			locale = $4,
+			version = version + 1,
+			updated_at = $5
+		WHERE id = $6
+		RETURNING version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(u.DisplayName, u.Handle, u.Timezone, u.Locale, time.Now().Unix(), u.ID).Scan(&u.Version, &u.UpdatedAt)
+}
+
+func (u *User) UpdateAvatar(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user
+		SET avatar = $1,
+			version = version + 1,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(u.Avatar, time.Now().Unix(), u.ID).Scan(&u.Version, &u.UpdatedAt)
+}
+
+func (u *User) UpdateEmail(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user
+		SET email = $1,
+			version = version + 1,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(u.Email, time.Now().Unix(), u.ID).Scan(&u.Version, &u.UpdatedAt)
+}
+
+// SetEmailVerified marks the user's current email address as verified.
+func (u *User) SetEmailVerified(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE user
+		SET email_verified_at = $1,
+			version = version + 1,
+			updated_at = $1
 		WHERE id = $2
+		RETURNING email_verified_at, version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(time.Now().Unix(), u.ID).Scan(&u.EmailVerifiedAt, &u.Version, &u.UpdatedAt)
+}
+
+// RevokeOtherSessions revokes the user's sessions except the session family
+// familyID.
+func (u *User) RevokeOtherSessions(db *sql.DB, familyID string) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE session
+		SET revoked_at = $1
+		WHERE user_id = $2 AND family_id != $3 AND revoked_at IS NULL
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(time.Now().Unix(), u.ID, familyID)
+	return err
+}
+
+func (u *User) RevokeSessions(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE session
+		SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
 		`,
 	)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(u.RoleID, u.ID)
+	_, err = stmt.Exec(time.Now().Unix(), u.ID)
 	return err
 }
+
+// SetDeactivated deactivates or reactivates the user. Deactivation also
+// invalidates the user's access tokens by bumping their token version.
+func (u *User) SetDeactivated(db *sql.DB, deactivated bool) error {
+	now := time.Now().Unix()
+	var deactivatedAt sql.NullInt64
+	if deactivated {
+		deactivatedAt = sql.NullInt64{Int64: now, Valid: true}
+	}
+	stmt, err := db.Prepare(
+		`
+		UPDATE user
+		SET deactivated_at = $1,
+			token_version = token_version + 1,
+			version = version + 1,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING token_version, COALESCE(deactivated_at, 0), version, updated_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(deactivatedAt, now, u.ID).Scan(&u.TokenVersion, &u.DeactivatedAt, &u.Version, &u.UpdatedAt)
+}
+
+// Orphans returns the projects that would be left without an owner if the
+// user were deleted, and the number of tasks the user created or is
+// assigned to.
+func (u *User) Orphans(db *sql.DB) ([]int, int, error) {
+	rows, err := db.Query(
+		`
+		SELECT p.id
+		FROM project p
+		WHERE p.user_id = $1
+		OR (
+			EXISTS (SELECT 1 FROM project_member WHERE project_id = p.id AND user_id = $1 AND role = $2)
+			AND NOT EXISTS (SELECT 1 FROM project_member WHERE project_id = p.id AND user_id != $1 AND role = $2)
+		)
+		ORDER BY p.id
+		`,
+		u.ID, constants.ProjectOwner,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	projectIDs := []int{}
+	for rows.Next() {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		projectIDs = append(projectIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	tasks := 0
+	err = db.QueryRow(
+		`
+		SELECT COUNT(*)
+		FROM task
+		WHERE assignee_id = $1 OR creator_id = $1
+		`,
+		u.ID,
+	).Scan(&tasks)
+	return projectIDs, tasks, err
+}
+
+// Delete removes the user. The projects in projectIDs, which should be
+// the ones returned by Orphans, as well as the user's tasks are handed over
+// to the user transferTo, who becomes an owner of the projects. Sessions and
+// project memberships of the user are deleted with it.
+func (u *User) Delete(db *sql.DB, projectIDs []int, transferTo int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if transferTo > 0 {
+		for _, pID := range projectIDs {
+			_, err = tx.Exec(
+				`
+				INSERT INTO project_member (project_id, user_id, role) values ($1, $2, $3)
+				ON CONFLICT (project_id, user_id) DO UPDATE SET role = excluded.role
+				`,
+				pID, transferTo, constants.ProjectOwner,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		now := time.Now().Unix()
+		for _, stmt := range []string{
+			"UPDATE project SET user_id = $1, version = version + 1, updated_at = $2 WHERE user_id = $3",
+			"UPDATE task SET assignee_id = $1, version = version + 1, updated_at = $2 WHERE assignee_id = $3",
+			"UPDATE task SET creator_id = $1, version = version + 1, updated_at = $2 WHERE creator_id = $3",
+		} {
+			if _, err = tx.Exec(stmt, transferTo, now, u.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	res, err := tx.Exec(
+		`
+		DELETE FROM user
+		WHERE id = $1
+		`,
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (f UserFilter) query(paginate bool) query {
+	q := query{}
+	if f.Query != "" {
+		q.and(`email LIKE ` + q.arg(likeContains(f.Query)) + ` ESCAPE '\'`)
+	}
+	if f.RoleID > 0 {
+		q.and("role_id = " + q.arg(f.RoleID))
+	}
+	if f.Deactivated.Valid {
+		if f.Deactivated.Bool {
+			q.and("deactivated_at IS NOT NULL")
+		} else {
+			q.and("deactivated_at IS NULL")
+		}
+	}
+	if paginate && f.AfterID > 0 {
+		column, desc, _ := sortColumn(f.sort(), UserSorts...)
+		q.after(column, desc, f.AfterValue, f.AfterID)
+	}
+	return q
+}
+
+func (f UserFilter) sort() string {
+	if ValidUserSort(f.Sort) {
+		return f.Sort
+	}
+	return "id"
+}
+
+func (us *Users) List(db *sql.DB, f UserFilter) error {
+	column, desc, _ := sortColumn(f.sort(), UserSorts...)
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT id, email, role_id, COALESCE(deactivated_at, 0), display_name, COALESCE(handle, ''), timezone, locale, avatar,
+				COALESCE(email_verified_at, 0), COALESCE(two_factor_enabled_at, 0), created_at, updated_at, version
+			FROM user
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			q.whereClause(), orderBy(column, desc), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u := User{}
+		err := rows.Scan(
+			&u.ID, &u.Email, &u.RoleID, &u.DeactivatedAt, &u.DisplayName, &u.Handle, &u.Timezone, &u.Locale, &u.Avatar,
+			&u.EmailVerifiedAt, &u.TwoFactorEnabledAt, &u.CreatedAt, &u.UpdatedAt, &u.Version,
+		)
+		if err != nil {
+			return err
+		}
+		*us = append(*us, u)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of users matching f, ignoring pagination.
+func (us *Users) Count(db *sql.DB, f UserFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM user
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
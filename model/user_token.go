@@ -0,0 +1,87 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Purposes of user tokens. A token can only be consumed for the purpose it
+// was created for.
+const (
+	TokenEmailChange       = "email_change"
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+	// TokenTwoFactorLogin tokens are not stored: the TOTP or recovery code
+	// presented with them can only be used once.
+	TokenTwoFactorLogin = "two_factor_login"
+)
+
+// UserToken records a single-use token sent to a user out of band. The
+// token itself is signed and carries its expiry; only its hash is stored so
+// that it can be used once.
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	TokenHash string
+	// Data holds purpose specific data, such as the new address of an email
+	// change.
+	Data      string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    sql.NullInt64
+}
+
+// Create stores the token, replacing any unused tokens the user has for the
+// same purpose.
+func (t *UserToken) Create(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`
+		DELETE FROM user_token
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+		`,
+		t.UserID, t.Purpose,
+	)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(
+		`
+		INSERT INTO user_token (user_id, purpose, token_hash, data, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+		`,
+		t.UserID, t.Purpose, t.TokenHash, t.Data, t.CreatedAt, t.ExpiresAt,
+	).Scan(&t.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Consume marks the unused, unexpired token with t.TokenHash and t.Purpose
+// as used and reads it into t. It returns sql.ErrNoRows if there is no such
+// token.
+func (t *UserToken) Consume(db *sql.DB) error {
+	now := time.Now().Unix()
+	stmt, err := db.Prepare(
+		`
+		UPDATE user_token
+		SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING id, user_id, data, created_at, expires_at, used_at
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRow(now, t.TokenHash, t.Purpose).Scan(
+		&t.ID, &t.UserID, &t.Data, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt,
+	)
+}
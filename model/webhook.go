@@ -0,0 +1,478 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Webhook events.
+const (
+	WebhookTaskCreated    = "task.created"
+	WebhookTaskUpdated    = "task.updated"
+	WebhookTaskDeleted    = "task.deleted"
+	WebhookProjectUpdated = "project.updated"
+)
+
+var WebhookEvents = []string{
+	WebhookTaskCreated,
+	WebhookTaskUpdated,
+	WebhookTaskDeleted,
+	WebhookProjectUpdated,
+}
+
+// ValidWebhookEvent reports whether e is one of WebhookEvents.
+func ValidWebhookEvent(e string) bool {
+	return slices.Contains(WebhookEvents, e)
+}
+
+// Statuses of webhook deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook subscribes a URL to events of a project.
+type Webhook struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"project_id"`
+	URL       string `json:"url"`
+	// Secret is the key deliveries are signed with. It is never returned.
+	Secret string   `json:"-"`
+	Events []string `json:"events"`
+	// Failures is the number of failed delivery attempts in a row.
+	Failures int `json:"failures"`
+	// DisabledAt is the Unix time the webhook was disabled, or 0 if it is
+	// active. Webhooks are disabled after too many failures in a row.
+	DisabledAt int64 `json:"disabled_at,omitempty"`
+	CreatedAt  int64 `json:"created_at"`
+	UpdatedAt  int64 `json:"updated_at"`
+}
+
+type Webhooks []Webhook
+
+const webhookColumns = `id, project_id, url, secret, events, failures, COALESCE(disabled_at, 0), created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }, w *Webhook) error {
+	events := ""
+	err := row.Scan(
+		&w.ID, &w.ProjectID, &w.URL, &w.Secret, &events, &w.Failures, &w.DisabledAt, &w.CreatedAt, &w.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	w.Events = strings.Split(events, ",")
+	return nil
+}
+
+func (w *Webhook) Create(db *sql.DB, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO webhook (project_id, url, secret, events, disabled_at, created_at, updated_at)
+		values ($1, $2, $3, $4, NULLIF($5, 0), $6, $6)
+		RETURNING ` + webhookColumns,
+	)
+	if err != nil {
+		return err
+	}
+	return scanWebhook(stmt.QueryRow(w.ProjectID, w.URL, w.Secret, strings.Join(w.Events, ","), w.DisabledAt, now), w)
+}
+
+// Read reads the webhook with w.ID of project w.ProjectID.
+func (w *Webhook) Read(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		SELECT ` + webhookColumns + `
+		FROM webhook
+		WHERE id = $1 AND project_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	return scanWebhook(stmt.QueryRow(w.ID, w.ProjectID), w)
+}
+
+// Update replaces the URL, secret, events, failure count and disabled time
+// of the webhook.
+func (w *Webhook) Update(db *sql.DB, now int64) error {
+	stmt, err := db.Prepare(
+		`
+		UPDATE webhook
+		SET url = $1,
+			secret = $2,
+			events = $3,
+			failures = $4,
+			disabled_at = NULLIF($5, 0),
+			updated_at = $6
+		WHERE id = $7 AND project_id = $8
+		RETURNING ` + webhookColumns,
+	)
+	if err != nil {
+		return err
+	}
+	return scanWebhook(
+		stmt.QueryRow(w.URL, w.Secret, strings.Join(w.Events, ","), w.Failures, w.DisabledAt, now, w.ID, w.ProjectID),
+		w,
+	)
+}
+
+func (w *Webhook) Delete(db *sql.DB) error {
+	stmt, err := db.Prepare(
+		`
+		DELETE FROM webhook
+		WHERE id = $1 AND project_id = $2
+		`,
+	)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(w.ID, w.ProjectID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+func (ws *Webhooks) ReadByProject(db *sql.DB, projectID int) error {
+	rows, err := db.Query(
+		`
+		SELECT `+webhookColumns+`
+		FROM webhook
+		WHERE project_id = $1
+		ORDER BY id
+		`,
+		projectID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		w := Webhook{}
+		if err := scanWebhook(rows, &w); err != nil {
+			return err
+		}
+		*ws = append(*ws, w)
+	}
+	return rows.Err()
+}
+
+// EnqueueWebhookEvent queues a delivery of the payload to each active
+// webhook of the project subscribed to the event and returns their number.
+func EnqueueWebhookEvent(db *sql.DB, projectID int, event string, payload []byte, now int64) (int64, error) {
+	stmt, err := db.Prepare(
+		`
+		INSERT INTO webhook_delivery (webhook_id, event, payload, next_attempt_at, created_at)
+		SELECT id, $1, $2, $3, $3
+		FROM webhook
+		WHERE project_id = $4 AND disabled_at IS NULL AND instr(',' || events || ',', ',' || $1 || ',') > 0
+		`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	res, err := stmt.Exec(event, string(payload), now, projectID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// WebhookDelivery is a payload queued for delivery to a webhook.
+type WebhookDelivery struct {
+	ID        int             `json:"id"`
+	WebhookID int             `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	// Status is one of DeliveryPending, DeliveryDelivered and
+	// DeliveryFailed. Failed deliveries are not retried.
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// NextAttemptAt is the Unix time a pending delivery is attempted next.
+	NextAttemptAt int64 `json:"next_attempt_at,omitempty"`
+	CreatedAt     int64 `json:"created_at"`
+	DeliveredAt   int64 `json:"delivered_at,omitempty"`
+	// Log is only read by WebhookDeliveries.List.
+	Log []WebhookAttempt `json:"log,omitempty"`
+}
+
+type WebhookDeliveries []WebhookDelivery
+
+// WebhookAttempt is the outcome of an attempt to deliver a payload.
+type WebhookAttempt struct {
+	ID          int   `json:"id"`
+	DeliveryID  int   `json:"delivery_id"`
+	AttemptedAt int64 `json:"attempted_at"`
+	// StatusCode is the status of the response, or 0 if there was none.
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Succeeded reports whether the receiver accepted the payload.
+func (a WebhookAttempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event, payload, status, attempts, COALESCE(next_attempt_at, 0),
+	created_at, COALESCE(delivered_at, 0)`
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, d *WebhookDelivery, extra ...interface{}) error {
+	payload := ""
+	dest := []interface{}{
+		&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.CreatedAt, &d.DeliveredAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	d.Payload = json.RawMessage(payload)
+	return nil
+}
+
+// PendingWebhookDelivery is a delivery due to be attempted together with
+// where it goes.
+type PendingWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// ListPendingWebhookDeliveries reads at most limit pending deliveries to
+// active webhooks that are due at now, oldest first.
+func ListPendingWebhookDeliveries(db *sql.DB, now int64, limit int) ([]PendingWebhookDelivery, error) {
+	rows, err := db.Query(
+		`
+		SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, COALESCE(d.next_attempt_at, 0),
+			d.created_at, COALESCE(d.delivered_at, 0), w.url, w.secret
+		FROM webhook_delivery d
+		INNER JOIN webhook w
+		ON w.id = d.webhook_id
+		WHERE d.status = $1 AND d.next_attempt_at <= $2 AND w.disabled_at IS NULL
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT $3
+		`,
+		DeliveryPending, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []PendingWebhookDelivery{}
+	for rows.Next() {
+		p := PendingWebhookDelivery{}
+		if err := scanWebhookDelivery(rows, &p.WebhookDelivery, &p.URL, &p.Secret); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+// RecordAttempt logs the attempt to deliver d and updates d and its
+// webhook. A successful attempt marks d delivered and resets the failure
+// count of the webhook. After a failed attempt d is retried at retryAt, or
+// marked failed if retryAt is 0, and the webhook is disabled if it has
+// failed disableAfter times in a row.
+func (d *WebhookDelivery) RecordAttempt(db *sql.DB, a *WebhookAttempt, retryAt int64, disableAfter int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	a.DeliveryID = d.ID
+	err = tx.QueryRow(
+		`
+		INSERT INTO webhook_attempt (delivery_id, attempted_at, status_code, error, duration_ms)
+		values ($1, $2, $3, $4, $5)
+		RETURNING id
+		`,
+		a.DeliveryID, a.AttemptedAt, a.StatusCode, a.Error, a.DurationMS,
+	).Scan(&a.ID)
+	if err != nil {
+		return err
+	}
+
+	if a.Succeeded() {
+		err = scanWebhookDelivery(
+			tx.QueryRow(
+				`
+				UPDATE webhook_delivery
+				SET status = $1,
+					attempts = attempts + 1,
+					next_attempt_at = NULL,
+					delivered_at = $2
+				WHERE id = $3
+				RETURNING `+webhookDeliveryColumns,
+				DeliveryDelivered, a.AttemptedAt, d.ID,
+			),
+			d,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`
+			UPDATE webhook
+			SET failures = 0
+			WHERE id = $1
+			`,
+			d.WebhookID,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	status := DeliveryPending
+	if retryAt == 0 {
+		status = DeliveryFailed
+	}
+	err = scanWebhookDelivery(
+		tx.QueryRow(
+			`
+			UPDATE webhook_delivery
+			SET status = $1,
+				attempts = attempts + 1,
+				next_attempt_at = NULLIF($2, 0)
+			WHERE id = $3
+			RETURNING `+webhookDeliveryColumns,
+			status, retryAt, d.ID,
+		),
+		d,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`
+		UPDATE webhook
+		SET failures = failures + 1,
+			disabled_at = CASE WHEN failures + 1 >= $1 THEN COALESCE(disabled_at, $2) ELSE disabled_at END
+		WHERE id = $3
+		`,
+		disableAfter, a.AttemptedAt, d.WebhookID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WebhookDeliveryFilter selects the deliveries of a webhook for
+// WebhookDeliveries.List, newest first.
+type WebhookDeliveryFilter struct {
+	WebhookID int
+	Status    string
+	// BeforeID is the ID of the last delivery of the previous page.
+	BeforeID int
+	Limit    int
+}
+
+func (f WebhookDeliveryFilter) query(paginate bool) query {
+	q := query{}
+	q.and("webhook_id = " + q.arg(f.WebhookID))
+	if f.Status != "" {
+		q.and("status = " + q.arg(f.Status))
+	}
+	if paginate && f.BeforeID > 0 {
+		q.after("id", true, nil, f.BeforeID)
+	}
+	return q
+}
+
+// List reads the deliveries matching f together with the log of their
+// attempts.
+func (ds *WebhookDeliveries) List(db *sql.DB, f WebhookDeliveryFilter) error {
+	q := f.query(true)
+	limit := q.arg(f.Limit)
+	rows, err := db.Query(
+		fmt.Sprintf(
+			`
+			SELECT %s
+			FROM webhook_delivery
+			%s
+			ORDER BY %s
+			LIMIT %s
+			`,
+			webhookDeliveryColumns, q.whereClause(), orderBy("id", true), limit,
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	deliveries := map[int]int{}
+	ids := []string{}
+	q = query{}
+	for rows.Next() {
+		d := WebhookDelivery{}
+		if err := scanWebhookDelivery(rows, &d); err != nil {
+			return err
+		}
+		deliveries[d.ID] = len(*ds)
+		ids = append(ids, q.arg(d.ID))
+		*ds = append(*ds, d)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err = db.Query(
+		fmt.Sprintf(
+			`
+			SELECT id, delivery_id, attempted_at, status_code, error, duration_ms
+			FROM webhook_attempt
+			WHERE delivery_id IN (%s)
+			ORDER BY id
+			`,
+			strings.Join(ids, ", "),
+		),
+		q.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := WebhookAttempt{}
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.AttemptedAt, &a.StatusCode, &a.Error, &a.DurationMS); err != nil {
+			return err
+		}
+		d := &(*ds)[deliveries[a.DeliveryID]]
+		d.Log = append(d.Log, a)
+	}
+	return rows.Err()
+}
+
+// Count returns the number of deliveries matching f, ignoring pagination.
+func (ds *WebhookDeliveries) Count(db *sql.DB, f WebhookDeliveryFilter) (int, error) {
+	q := f.query(false)
+	count := 0
+	err := db.QueryRow(
+		fmt.Sprintf(
+			`
+			SELECT COUNT(*)
+			FROM webhook_delivery
+			%s
+			`,
+			q.whereClause(),
+		),
+		q.args...,
+	).Scan(&count)
+	return count, err
+}
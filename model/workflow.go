@@ -0,0 +1,225 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+)
+
+// ErrStateInUse is returned by Workflow.Replace if tasks of the project are
+// in a state the new workflow does not have.
+var ErrStateInUse = errors.New("task state in use")
+
+// WorkflowState is a status tasks of a project can be in. Tasks in a done
+// state count as completed.
+type WorkflowState struct {
+	Name constants.TaskStatus `json:"name"`
+	Done bool                 `json:"done"`
+}
+
+// WorkflowTransition allows tasks to move from one state to another.
+type WorkflowTransition struct {
+	From constants.TaskStatus `json:"from"`
+	To   constants.TaskStatus `json:"to"`
+}
+
+// Workflow defines the states of the tasks of a project and the moves
+// between them. New tasks start in the first state.
+type Workflow struct {
+	ProjectID   int                  `json:"project_id"`
+	States      []WorkflowState      `json:"states"`
+	Transitions []WorkflowTransition `json:"transitions"`
+}
+
+// DefaultWorkflow is the workflow of new projects. It allows every move
+// between its states.
+var DefaultWorkflow = Workflow{
+	States: []WorkflowState{
+		{Name: constants.Todo},
+		{Name: constants.Doing},
+		{Name: constants.Done, Done: true},
+	},
+	Transitions: []WorkflowTransition{
+		{From: constants.Todo, To: constants.Doing},
+		{From: constants.Todo, To: constants.Done},
+		{From: constants.Doing, To: constants.Todo},
+		{From: constants.Doing, To: constants.Done},
+		{From: constants.Done, To: constants.Todo},
+		{From: constants.Done, To: constants.Doing},
+	},
+}
+
+// State returns the state with the name. ok is false if the workflow has
+// no such state.
+func (w Workflow) State(name constants.TaskStatus) (state WorkflowState, ok bool) {
+	for _, s := range w.States {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return WorkflowState{}, false
+}
+
+// Initial returns the state new tasks start in.
+func (w Workflow) Initial() constants.TaskStatus {
+	if len(w.States) == 0 {
+		return ""
+	}
+	return w.States[0].Name
+}
+
+// Allows reports whether tasks may move from one state to the other.
+func (w Workflow) Allows(from, to constants.TaskStatus) bool {
+	for _, t := range w.Transitions {
+		if t.From == from && t.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Workflow) Read(db *sql.DB) error {
+	rows, err := db.Query(
+		`
+		SELECT name, done
+		FROM task_state
+		WHERE project_id = $1
+		ORDER BY position
+		`,
+		w.ProjectID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w.States = []WorkflowState{}
+	for rows.Next() {
+		s := WorkflowState{}
+		if err := rows.Scan(&s.Name, &s.Done); err != nil {
+			return err
+		}
+		w.States = append(w.States, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(w.States) == 0 {
+		return sql.ErrNoRows
+	}
+
+	rows, err = db.Query(
+		`
+		SELECT t.from_state, t.to_state
+		FROM task_transition t
+		INNER JOIN task_state f
+		ON f.project_id = t.project_id AND f.name = t.from_state
+		INNER JOIN task_state s
+		ON s.project_id = t.project_id AND s.name = t.to_state
+		WHERE t.project_id = $1
+		ORDER BY f.position, s.position
+		`,
+		w.ProjectID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w.Transitions = []WorkflowTransition{}
+	for rows.Next() {
+		t := WorkflowTransition{}
+		if err := rows.Scan(&t.From, &t.To); err != nil {
+			return err
+		}
+		w.Transitions = append(w.Transitions, t)
+	}
+	return rows.Err()
+}
+
+// Replace replaces the workflow of the project. It returns ErrStateInUse if
+// tasks of the project are in a state that is removed. Tasks in a state
+// that becomes done are completed now, and tasks in a state that is no
+// longer done are no longer completed.
+func (w *Workflow) Replace(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := query{}
+	q.and("project_id = " + q.arg(w.ProjectID))
+	names := []string{}
+	for _, s := range w.States {
+		names = append(names, q.arg(s.Name))
+	}
+	q.and(fmt.Sprintf("status NOT IN (%s)", strings.Join(names, ", ")))
+	inUse := 0
+	err = tx.QueryRow("SELECT COUNT(*) FROM task "+q.whereClause(), q.args...).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return ErrStateInUse
+	}
+
+	_, err = tx.Exec(
+		`
+		DELETE FROM task_state
+		WHERE project_id = $1
+		`,
+		w.ProjectID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := createWorkflow(tx, w.ProjectID, *w); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`
+		UPDATE task
+		SET completed_at = CASE WHEN completed_at IS NULL THEN $1 END,
+			version = version + 1,
+			updated_at = $1
+		WHERE project_id = $2
+			AND (completed_at IS NULL) = (SELECT done FROM task_state s WHERE s.project_id = task.project_id AND s.name = task.status)
+		`,
+		time.Now().Unix(), w.ProjectID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createWorkflow(tx *sql.Tx, projectID int, w Workflow) error {
+	for i, s := range w.States {
+		_, err := tx.Exec(
+			`
+			INSERT INTO task_state (project_id, name, position, done) values ($1, $2, $3, $4)
+			`,
+			projectID, s.Name, i, s.Done,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range w.Transitions {
+		_, err := tx.Exec(
+			`
+			INSERT INTO task_transition (project_id, from_state, to_state) values ($1, $2, $3)
+			`,
+			projectID, t.From, t.To,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package mw
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -9,13 +10,32 @@ import (
 )
 
 func ContentTypeApplicationJSONOnly(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		contentType := c.Request().Header.Get("Content-Type")
-		if contentType != "application/json" {
+	return ContentTypeApplicationJSONOnlyExcept()(next)
+}
+
+// ContentTypeApplicationJSONOnlyExcept is like ContentTypeApplicationJSONOnly
+// but also accepts multipart/form-data uploads on the given route paths.
+func ContentTypeApplicationJSONOnlyExcept(multipartPaths ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			contentType := c.Request().Header.Get("Content-Type")
+			if contentType == "application/json" {
+				return next(c)
+			}
+			if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == "multipart/form-data" {
+				for _, path := range multipartPaths {
+					if c.Path() == path {
+						return next(c)
+					}
+				}
+			}
 			return c.JSON(
 				http.StatusBadRequest,
 				schema.MessageResponse{Message: fmt.Sprintf("invalid Content-Type: '%s'", contentType)})
 		}
-		return next(c)
 	}
 }
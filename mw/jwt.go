@@ -1,10 +1,12 @@
 package mw
 
 import (
-	"encoding/json"
+	"database/sql"
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
@@ -12,39 +14,91 @@ import (
 	"github.com/tomihaapalainen/go-task-mgmt/utils"
 )
 
-func JwtMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		tokenStr := utils.ReadAuthorizationToken(c)
-		if tokenStr == "" {
-			return c.JSON(
-				http.StatusUnauthorized,
-				schema.MessageResponse{Message: "Missing authorization header"},
-			)
-		}
+// JwtMiddleware authenticates the user with the bearer token, either an
+// access token issued at login or a personal access token. The user is
+// stored in the context as "user". For access tokens the session family is
+// stored as "session_id", and for personal access tokens the token as
+// "personal_access_token". The Unix time the token expires at is stored as
+// "token_expires_at".
+func JwtMiddleware(db *sql.DB) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			refused, err := authenticate(c, db)
+			if err != nil {
+				return err
+			}
+			if refused != nil {
+				return refused.respond(c)
+			}
+			return next(c)
+		})
+	}
+}
 
-		claims, err := utils.ParseClaims(tokenStr)
-		if err != nil {
-			log.Println("err parsing auth token: ", err)
-			return c.JSON(
-				http.StatusUnauthorized,
-				schema.MessageResponse{Message: "Error parsing authorization token"},
-			)
-		}
-		err = claims.Valid()
-		if err != nil {
-			log.Println("err invalid claims: ", err)
-			return c.JSON(
-				http.StatusUnauthorized,
-				schema.MessageResponse{Message: "Invalid claims"},
-			)
-		}
-		userData := claims["data"].(string)
-		user := model.User{}
-		if err := json.Unmarshal([]byte(userData), &user); err != nil {
-			log.Println("err unmarshaling: ", err)
-			return errors.New("internal server error")
+// refusal is the response a request that may not proceed is answered with.
+type refusal struct {
+	status  int
+	message string
+}
+
+func (r *refusal) respond(c echo.Context) error {
+	return c.JSON(r.status, schema.MessageResponse{Message: r.message})
+}
+
+// authenticate makes the checks of JwtMiddleware and stores the values it
+// describes in the context. It returns the refusal if the request is not
+// authenticated.
+func authenticate(c echo.Context, db *sql.DB) (*refusal, error) {
+	tokenStr := utils.ReadAuthorizationToken(c)
+	if tokenStr == "" {
+		return &refusal{http.StatusUnauthorized, "Missing authorization header"}, nil
+	}
+
+	if strings.HasPrefix(tokenStr, utils.PersonalAccessTokenPrefix) {
+		return authenticatePersonalAccessToken(c, db, tokenStr)
+	}
+
+	claims, err := utils.ParseClaims(tokenStr)
+	if err != nil {
+		log.Println("err parsing auth token: ", err)
+		return &refusal{http.StatusUnauthorized, "Error parsing authorization token"}, nil
+	}
+	sessionID := claims.SessionID
+	if sessionID == "" {
+		return &refusal{http.StatusUnauthorized, "Invalid claims"}, nil
+	}
+	session := model.Session{FamilyID: sessionID}
+	active, err := session.FamilyIsActive(db)
+	if err != nil {
+		log.Println("err reading session: ", err)
+		return nil, errors.New("internal server error")
+	}
+	if !active {
+		return &refusal{http.StatusUnauthorized, "Session has been revoked"}, nil
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return &refusal{http.StatusUnauthorized, "Invalid claims"}, nil
+	}
+	user, err := readUser(db, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &refusal{http.StatusUnauthorized, "Invalid claims"}, nil
 		}
-		c.Set("user", user)
-		return next(c)
+		log.Println("err reading user: ", err)
+		return nil, errors.New("internal server error")
+	}
+	if !user.Active() {
+		return &refusal{http.StatusUnauthorized, "User has been deactivated"}, nil
+	}
+	if claims.TokenVersion != user.TokenVersion {
+		return &refusal{http.StatusUnauthorized, "Token has been invalidated"}, nil
+	}
+	c.Set("user", user)
+	c.Set("session_id", sessionID)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Unix())
 	}
+	return nil, nil
 }
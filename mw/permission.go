@@ -8,30 +8,105 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/tomihaapalainen/go-task-mgmt/model"
-	"github.com/tomihaapalainen/go-task-mgmt/schema"
 )
 
+// PermissionRequired requires the user's role to have the permission, and
+// the scopes of the personal access token to include it if the request was
+// made with one. Users who have not verified their email address, or who
+// have not enabled two-factor authentication required by their role, are
+// refused.
 func PermissionRequired(db *sql.DB, permission string) func(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return echo.HandlerFunc(func(c echo.Context) error {
-			user := c.Get("user").(model.User)
-			permissions := model.Permissions{}
-			if err := permissions.ReadRolePermissions(db, user.RoleID); err != nil {
-				return errors.New("unable to read role permissions")
-			}
-			hasPermission := false
-			for _, p := range permissions {
-				if permission == p.Name || p.Name == "all" {
-					hasPermission = true
-				}
+			_, refused, err := checkPermission(c, db, permission)
+			if err != nil {
+				return err
 			}
-			if !hasPermission {
-				return c.JSON(
-					http.StatusForbidden,
-					schema.MessageResponse{
-						Message: fmt.Sprintf("user '%s' does not have permission to run this command", user.Email)})
+			if refused != nil {
+				return refused.respond(c)
 			}
 			return next(c)
 		})
 	}
 }
+
+// checkPermission makes the checks of PermissionRequired and returns the
+// permissions of the user's role. It returns the refusal if the user may
+// not use the permission.
+func checkPermission(c echo.Context, db *sql.DB, permission string) (model.Permissions, *refusal, error) {
+	user := c.Get("user").(model.User)
+	if !user.EmailVerified() {
+		return nil, unverifiedRefusal(user), nil
+	}
+	missing, err := twoFactorMissing(db, user)
+	if err != nil {
+		return nil, nil, errors.New("unable to read role two-factor policy")
+	}
+	if missing {
+		return nil, twoFactorRefusal(user), nil
+	}
+	permissions := model.Permissions{}
+	if err := permissions.ReadRolePermissions(db, user.RoleID); err != nil {
+		return nil, nil, errors.New("unable to read role permissions")
+	}
+	if !permissions.Has(permission) {
+		return nil, &refusal{
+			http.StatusForbidden,
+			fmt.Sprintf("user '%s' does not have permission to run this command", user.Email)}, nil
+	}
+	if !TokenAllows(c, permission) {
+		return nil, scopeRefusal(permission), nil
+	}
+	return permissions, nil, nil
+}
+
+// VerifiedEmailRequired refuses users who have not verified their email
+// address. PermissionRequired and ProjectPermissionRequired include this
+// check.
+func VerifiedEmailRequired(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(model.User)
+		if !user.EmailVerified() {
+			return unverifiedRefusal(user).respond(c)
+		}
+		return next(c)
+	}
+}
+
+// TwoFactorPolicyRequired refuses users whose role requires two-factor
+// authentication they have not enabled. PermissionRequired and
+// ProjectPermissionRequired include this check.
+func TwoFactorPolicyRequired(db *sql.DB) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := c.Get("user").(model.User)
+			missing, err := twoFactorMissing(db, user)
+			if err != nil {
+				return errors.New("unable to read role two-factor policy")
+			}
+			if missing {
+				return twoFactorRefusal(user).respond(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+func unverifiedRefusal(user model.User) *refusal {
+	return &refusal{http.StatusForbidden, fmt.Sprintf("user '%s' has not verified their email address", user.Email)}
+}
+
+// twoFactorMissing reports whether the user's role requires two-factor
+// authentication the user has not enabled.
+func twoFactorMissing(db *sql.DB, user model.User) (bool, error) {
+	if user.TwoFactorEnabled() {
+		return false, nil
+	}
+	return model.TwoFactorRequired(db, user.RoleID)
+}
+
+func twoFactorRefusal(user model.User) *refusal {
+	return &refusal{
+		http.StatusForbidden,
+		fmt.Sprintf("the role of user '%s' requires two-factor authentication to be enabled", user.Email)}
+}
@@ -0,0 +1,73 @@
+package mw
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+	"github.com/tomihaapalainen/go-task-mgmt/utils"
+)
+
+func authenticatePersonalAccessToken(c echo.Context, db *sql.DB, tokenStr string) (*refusal, error) {
+	pat := model.PersonalAccessToken{TokenHash: utils.HashToken(tokenStr)}
+	if err := pat.ReadByTokenHash(db); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &refusal{http.StatusUnauthorized, "Invalid personal access token"}, nil
+		}
+		log.Println("err reading personal access token: ", err)
+		return nil, errors.New("internal server error")
+	}
+	now := time.Now().Unix()
+	if pat.ExpiresAt <= now {
+		return &refusal{http.StatusUnauthorized, "Personal access token has expired"}, nil
+	}
+
+	user, err := readUser(db, pat.UserID)
+	if err != nil {
+		log.Println("err reading user: ", err)
+		return nil, errors.New("internal server error")
+	}
+	if !user.Active() {
+		return &refusal{http.StatusUnauthorized, "User has been deactivated"}, nil
+	}
+	if err := pat.Touch(db, now); err != nil {
+		log.Println("err updating personal access token last use: ", err)
+	}
+	c.Set("user", user)
+	c.Set("personal_access_token", pat)
+	c.Set("token_expires_at", pat.ExpiresAt)
+	return nil, nil
+}
+
+// TokenAllows reports whether the request may use permission as far as its
+// credentials are concerned: requests made with a personal access token are
+// limited to the token's scopes.
+func TokenAllows(c echo.Context, permission string) bool {
+	pat, ok := c.Get("personal_access_token").(model.PersonalAccessToken)
+	return !ok || pat.Allows(permission)
+}
+
+// SessionRequired refuses requests made with a personal access token, for
+// routes that manage the account itself.
+func SessionRequired(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := c.Get("personal_access_token").(model.PersonalAccessToken); ok {
+			return c.JSON(
+				http.StatusForbidden,
+				schema.MessageResponse{Message: "personal access tokens cannot be used for this route"})
+		}
+		return next(c)
+	}
+}
+
+func scopeRefusal(permission string) *refusal {
+	return &refusal{
+		http.StatusForbidden,
+		fmt.Sprintf("personal access token does not have the scope '%s'", permission)}
+}
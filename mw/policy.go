@@ -0,0 +1,65 @@
+package mw
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+	"github.com/tomihaapalainen/go-task-mgmt/policy"
+	"github.com/tomihaapalainen/go-task-mgmt/schema"
+)
+
+// TaskPolicyRequired evaluates policy.TaskRules for the task named by the
+// "projectID" and "id" route parameters. It must run after
+// ProjectPermissionRequired. The task is stored in the context as "task".
+func TaskPolicyRequired(db *sql.DB, permission string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			user := c.Get("user").(model.User)
+
+			pID, err := strconv.Atoi(c.Param("projectID"))
+			if err != nil || pID <= 0 {
+				return c.JSON(
+					http.StatusBadRequest,
+					schema.MessageResponse{Message: fmt.Sprintf("invalid project ID '%s'", c.Param("projectID"))})
+			}
+			tID, err := strconv.Atoi(c.Param("id"))
+			if err != nil || tID <= 0 {
+				return c.JSON(
+					http.StatusBadRequest,
+					schema.MessageResponse{Message: fmt.Sprintf("invalid task ID '%s'", c.Param("id"))})
+			}
+
+			task := model.Task{ID: tID, ProjectID: pID}
+			if err := task.ReadByID(db); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return c.JSON(
+						http.StatusNotFound,
+						schema.MessageResponse{Message: fmt.Sprintf("task '%d' not found in project '%d'", tID, pID)})
+				}
+				return errors.New("unable to read task")
+			}
+
+			permissions := model.Permissions{}
+			if err := permissions.ReadRolePermissions(db, user.RoleID); err != nil {
+				return errors.New("unable to read role permissions")
+			}
+			role, _ := c.Get("project_role").(constants.ProjectRole)
+			subject := policy.Subject{User: user, Permissions: permissions, ProjectRole: role}
+			if ok, reason := policy.EvaluateTask(permission, subject, task); !ok {
+				return c.JSON(
+					http.StatusForbidden,
+					schema.MessageResponse{
+						Message: fmt.Sprintf("user '%s' may not %s: %s", user.Email, permission, reason)})
+			}
+
+			c.Set("task", task)
+			return next(c)
+		})
+	}
+}
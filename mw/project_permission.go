@@ -0,0 +1,92 @@
+package mw
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+)
+
+// ProjectPermissionRequired is like PermissionRequired for routes under a
+// project. Besides the permission in their global role, the user must be a
+// member of the project whose project role grants the permission. Users
+// with the "all" permission are not required to be members. Users are
+// refused as in PermissionRequired if they have not verified their email
+// address or enabled two-factor authentication required by their role.
+//
+// The project ID is read from the "projectID" route parameter, or "id" if
+// the route has no "projectID" parameter. The member's project role is
+// stored in the context as "project_role".
+func ProjectPermissionRequired(db *sql.DB, permission string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			refused, err := checkProjectPermission(c, db, permission)
+			if err != nil {
+				return err
+			}
+			if refused != nil {
+				return refused.respond(c)
+			}
+			return next(c)
+		})
+	}
+}
+
+// ProjectPermissionHolds repeats the checks of JwtMiddleware and
+// ProjectPermissionRequired(db, permission) for a request that has passed
+// them. Handlers that keep serving a request, such as event streams, use it
+// to stop once the checks no longer hold. It reports whether they hold.
+func ProjectPermissionHolds(c echo.Context, db *sql.DB, permission string) (bool, error) {
+	refused, err := authenticate(c, db)
+	if err != nil || refused != nil {
+		return false, err
+	}
+	refused, err = checkProjectPermission(c, db, permission)
+	return err == nil && refused == nil, err
+}
+
+// checkProjectPermission makes the checks of ProjectPermissionRequired. It
+// returns the refusal if the user may not use the permission in the
+// project.
+func checkProjectPermission(c echo.Context, db *sql.DB, permission string) (*refusal, error) {
+	user := c.Get("user").(model.User)
+	permissions, refused, err := checkPermission(c, db, permission)
+	if err != nil || refused != nil {
+		return refused, err
+	}
+
+	projectID := c.Param("projectID")
+	if projectID == "" {
+		projectID = c.Param("id")
+	}
+	pID, err := strconv.Atoi(projectID)
+	if err != nil || pID <= 0 {
+		return &refusal{http.StatusBadRequest, fmt.Sprintf("invalid project ID '%s'", projectID)}, nil
+	}
+
+	member := model.ProjectMember{ProjectID: pID, UserID: user.ID}
+	if err := member.Read(db); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("unable to read project membership")
+		}
+		if permissions.Has("all") {
+			return nil, nil
+		}
+		return &refusal{
+			http.StatusForbidden,
+			fmt.Sprintf("user '%s' is not a member of project '%d'", user.Email, pID)}, nil
+	}
+	c.Set("project_role", member.Role)
+	if !permissions.Has("all") && !member.Role.HasPermission(permission) {
+		return &refusal{
+			http.StatusForbidden,
+			fmt.Sprintf(
+				"project role '%s' of user '%s' does not have permission to run this command",
+				member.Role, user.Email)}, nil
+	}
+	return nil, nil
+}
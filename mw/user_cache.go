@@ -0,0 +1,50 @@
+package mw
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+)
+
+type cachedUser struct {
+	user    model.User
+	expires time.Time
+}
+
+var userCache = struct {
+	sync.Mutex
+	users map[int]cachedUser
+}{users: map[int]cachedUser{}}
+
+// readUser returns the user with the given ID, reading it from the database
+// if it is not cached or the cached entry is older than config.USER_CACHE_TTL.
+func readUser(db *sql.DB, id int) (model.User, error) {
+	userCache.Lock()
+	cached, ok := userCache.users[id]
+	userCache.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	user := model.User{ID: id}
+	if err := user.ReadByID(db); err != nil {
+		return model.User{}, err
+	}
+
+	userCache.Lock()
+	userCache.users[id] = cachedUser{user: user, expires: time.Now().Add(config.USER_CACHE_TTL)}
+	userCache.Unlock()
+	return user, nil
+}
+
+// ForgetUser drops the cached copy of the user so that the next request
+// reads it from the database. Call it after changing a user's role or
+// credentials.
+func ForgetUser(id int) {
+	userCache.Lock()
+	delete(userCache.users, id)
+	userCache.Unlock()
+}
@@ -0,0 +1,388 @@
+// Package oidc logs users in with an external OpenID Connect identity
+// provider using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/keys"
+)
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Config describes the client registered with the identity provider.
+type Config struct {
+	// Issuer is the issuer URL of the provider. Its metadata is discovered
+	// from Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// GroupsClaim names the ID token claim listing the user's groups.
+	GroupsClaim string
+	// GroupRoles maps the user's groups onto roles. The first rule with a
+	// group the user is in decides the role.
+	GroupRoles []GroupRole
+}
+
+type GroupRole struct {
+	Group  string
+	RoleID constants.RoleID
+}
+
+// RoleFor returns the role of a user in groups, or false if no rule
+// matches.
+func (cfg Config) RoleFor(groups []string) (constants.RoleID, bool) {
+	for _, gr := range cfg.GroupRoles {
+		for _, g := range groups {
+			if g == gr.Group {
+				return gr.RoleID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// ParseGroupRoles parses rules such as "admins=1,managers=2".
+func ParseGroupRoles(s string) ([]GroupRole, error) {
+	rules := []GroupRole{}
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		group, roleID, ok := strings.Cut(rule, "=")
+		id, err := strconv.Atoi(strings.TrimSpace(roleID))
+		if !ok || err != nil || id <= 0 || strings.TrimSpace(group) == "" {
+			return nil, fmt.Errorf("invalid group role '%s'", rule)
+		}
+		rules = append(rules, GroupRole{Group: strings.TrimSpace(group), RoleID: constants.RoleID(id)})
+	}
+	return rules, nil
+}
+
+// FromEnv reads the configuration from GO_TASK_MGMT_OIDC_ISSUER,
+// GO_TASK_MGMT_OIDC_CLIENT_ID, GO_TASK_MGMT_OIDC_CLIENT_SECRET,
+// GO_TASK_MGMT_OIDC_REDIRECT_URL, GO_TASK_MGMT_OIDC_SCOPES (space
+// separated, default "email profile"), GO_TASK_MGMT_OIDC_GROUPS_CLAIM
+// (default "groups") and GO_TASK_MGMT_OIDC_GROUP_ROLES. ok is false if no
+// issuer is set.
+func FromEnv() (Config, bool, error) {
+	cfg := Config{
+		Issuer:       os.Getenv("GO_TASK_MGMT_OIDC_ISSUER"),
+		ClientID:     os.Getenv("GO_TASK_MGMT_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("GO_TASK_MGMT_OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("GO_TASK_MGMT_OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("GO_TASK_MGMT_OIDC_SCOPES")),
+		GroupsClaim:  os.Getenv("GO_TASK_MGMT_OIDC_GROUPS_CLAIM"),
+	}
+	if cfg.Issuer == "" {
+		return Config{}, false, nil
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return Config{}, false, errors.New("GO_TASK_MGMT_OIDC_CLIENT_ID and GO_TASK_MGMT_OIDC_REDIRECT_URL must be set")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	rules, err := ParseGroupRoles(os.Getenv("GO_TASK_MGMT_OIDC_GROUP_ROLES"))
+	if err != nil {
+		return Config{}, false, err
+	}
+	cfg.GroupRoles = rules
+	return cfg, true, nil
+}
+
+// Metadata is the part of the provider's discovery document the client
+// uses.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider is a client of an identity provider. Its metadata and keys are
+// fetched on first use and cached.
+type Provider struct {
+	Config
+	Client *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     map[string]crypto.PublicKey
+	// keysFetched limits how often an unknown key ID refetches the keys.
+	keysFetched time.Time
+}
+
+func NewProvider(cfg Config) *Provider {
+	return &Provider{Config: cfg, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// Metadata returns the provider's discovery document.
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	m := Metadata{}
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return Metadata{}, err
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != issuer {
+		return Metadata{}, fmt.Errorf("discovered issuer '%s' does not match '%s'", m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return Metadata{}, errors.New("incomplete provider metadata")
+	}
+	if len(m.CodeChallengeMethods) > 0 {
+		supported := false
+		for _, method := range m.CodeChallengeMethods {
+			supported = supported || method == "S256"
+		}
+		if !supported {
+			return Metadata{}, errors.New("provider does not support PKCE with S256")
+		}
+	}
+	p.metadata = &m
+	return m, nil
+}
+
+// GenerateVerifier returns a random PKCE code verifier.
+func GenerateVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL the user is sent to to log in at the
+// provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s: %s %s", res.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// key returns the provider's public key with the ID kid, refetching the
+// provider's keys if it is unknown, at most once a minute.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	k, ok := p.keys[kid]
+	stale := time.Since(p.keysFetched) > time.Minute
+	p.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key ID '%s'", kid)
+	}
+
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jwks := keys.JWKS{}
+	if err := p.getJSON(ctx, m.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	fetched := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		fetched[jwk.Kid] = public
+	}
+
+	p.mu.Lock()
+	p.keys = fetched
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+	if k, ok := fetched[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key ID '%s'", kid)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	mc := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(
+		rawIDToken,
+		mc,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return p.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(m.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	if n, _ := mc["nonce"].(string); nonce == "" || n != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	aud, _ := mc.GetAudience()
+	if azp, ok := mc["azp"].(string); (len(aud) > 1 || ok) && azp != p.ClientID {
+		return Claims{}, fmt.Errorf("%w: authorized party '%s' is not the client", ErrInvalidIDToken, azp)
+	}
+	c := Claims{Issuer: m.Issuer}
+	c.Subject, _ = mc.GetSubject()
+	if c.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	c.Email, _ = mc["email"].(string)
+	c.Name, _ = mc["name"].(string)
+	switch v := mc["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+	switch v := mc[p.GroupsClaim].(type) {
+	case string:
+		c.Groups = []string{v}
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				c.Groups = append(c.Groups, s)
+			}
+		}
+	}
+	return c, nil
+}
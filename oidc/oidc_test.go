@@ -0,0 +1,133 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *Provider) {
+	idp, err := oidctest.NewServer("client", "secret")
+	assert.AssertEq(t, err, nil)
+	t.Cleanup(idp.Close)
+	p := NewProvider(Config{
+		Issuer:       idp.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/callback",
+		GroupsClaim:  "groups",
+	})
+	return idp, p
+}
+
+func TestAuthorizationCodeFlowShouldPass(t *testing.T) {
+	idp, p := newTestProvider(t)
+	ctx := context.Background()
+	verifier, err := GenerateVerifier()
+	assert.AssertEq(t, err, nil)
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", verifier)
+	assert.AssertEq(t, err, nil)
+	redirect, err := idp.Authorize(authURL, jwt.MapClaims{
+		"sub":            "user-1",
+		"email":          "sso@example.com",
+		"email_verified": true,
+		"groups":         []string{"staff", "admins"},
+	})
+	assert.AssertEq(t, err, nil)
+	u, err := url.Parse(redirect)
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, u.Query().Get("state"), "state")
+
+	// The code can only be redeemed with the verifier it was issued for.
+	_, err = p.Exchange(ctx, u.Query().Get("code"), "wrong verifier")
+	assert.AssertNotEq(t, err, nil)
+	redirect, err = idp.Authorize(authURL, jwt.MapClaims{
+		"sub":            "user-1",
+		"email":          "sso@example.com",
+		"email_verified": true,
+		"groups":         []string{"staff", "admins"},
+	})
+	assert.AssertEq(t, err, nil)
+	u, err = url.Parse(redirect)
+	assert.AssertEq(t, err, nil)
+	rawIDToken, err := p.Exchange(ctx, u.Query().Get("code"), verifier)
+	assert.AssertEq(t, err, nil)
+
+	_, err = p.Verify(ctx, rawIDToken, "other nonce")
+	assert.AssertEq(t, errors.Is(err, ErrInvalidIDToken), true)
+	claims, err := p.Verify(ctx, rawIDToken, "nonce")
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, claims.Subject, "user-1")
+	assert.AssertEq(t, claims.Email, "sso@example.com")
+	assert.AssertEq(t, claims.EmailVerified, true)
+	assert.AssertEq(t, len(claims.Groups), 2)
+}
+
+func TestVerifyShouldRejectInvalidTokens(t *testing.T) {
+	idp, p := newTestProvider(t)
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   "client",
+			"sub":   "user-1",
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+	token, err := idp.Sign(valid())
+	assert.AssertEq(t, err, nil)
+	_, err = p.Verify(context.Background(), token, "nonce")
+	assert.AssertEq(t, err, nil)
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"issuer":     func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"audience":   func(c jwt.MapClaims) { c["aud"] = "other client" },
+		"expired":    func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"no nonce":   func(c jwt.MapClaims) { delete(c, "nonce") },
+		"no subject": func(c jwt.MapClaims) { delete(c, "sub") },
+		"azp":        func(c jwt.MapClaims) { c["aud"] = []string{"client", "other"} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims := valid()
+			change(claims)
+			token, err := idp.Sign(claims)
+			assert.AssertEq(t, err, nil)
+			_, err = p.Verify(context.Background(), token, "nonce")
+			assert.AssertEq(t, errors.Is(err, ErrInvalidIDToken), true)
+		})
+	}
+
+	// A token signed by a key the provider has not published is rejected.
+	other, err := oidctest.NewServer("client", "secret")
+	assert.AssertEq(t, err, nil)
+	defer other.Close()
+	token, err = other.Sign(valid())
+	assert.AssertEq(t, err, nil)
+	_, err = p.Verify(context.Background(), token, "nonce")
+	assert.AssertEq(t, errors.Is(err, ErrInvalidIDToken), true)
+}
+
+func TestRoleForShouldUseFirstMatchingRule(t *testing.T) {
+	rules, err := ParseGroupRoles("admins=1, managers=2")
+	assert.AssertEq(t, err, nil)
+	cfg := Config{GroupRoles: rules}
+
+	roleID, ok := cfg.RoleFor([]string{"managers", "admins"})
+	assert.AssertEq(t, ok, true)
+	assert.AssertEq(t, roleID, constants.AdminRoleID)
+	_, ok = cfg.RoleFor([]string{"staff"})
+	assert.AssertEq(t, ok, false)
+
+	_, err = ParseGroupRoles("admins")
+	assert.AssertNotEq(t, err, nil)
+}
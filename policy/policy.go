@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tomihaapalainen/go-task-mgmt/constants"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+)
+
+// Subject is the user a policy is evaluated for.
+type Subject struct {
+	User        model.User
+	Permissions model.Permissions
+	// ProjectRole is empty if the user is not a member of the project.
+	ProjectRole constants.ProjectRole
+}
+
+// TaskRule decides whether subject may act on task. If it does not allow
+// the action it returns the reason.
+type TaskRule func(s Subject, t model.Task) (bool, string)
+
+// TaskRules are evaluated after the permission check for routes acting on
+// an existing task. Permissions without a rule are allowed.
+var TaskRules = map[string]TaskRule{
+	"update task": AnyOf(IsAdmin, IsProjectManager, IsTaskCreator, IsTaskAssignee),
+	"delete task": AnyOf(IsAdmin, IsProjectManager, IsTaskCreator, IsTaskAssignee),
+}
+
+// EvaluateTask applies the rule for permission.
+func EvaluateTask(permission string, s Subject, t model.Task) (bool, string) {
+	rule, ok := TaskRules[permission]
+	if !ok {
+		return true, ""
+	}
+	return rule(s, t)
+}
+
+func IsAdmin(s Subject, t model.Task) (bool, string) {
+	return s.Permissions.Has("all"), "user is not an admin"
+}
+
+// IsProjectManager allows project owners and maintainers as well as members
+// with the global project manager role.
+func IsProjectManager(s Subject, t model.Task) (bool, string) {
+	switch {
+	case s.ProjectRole == constants.ProjectOwner, s.ProjectRole == constants.ProjectMaintainer:
+		return true, ""
+	case s.ProjectRole != "" && s.User.RoleID == constants.ProjectManagerRoleID:
+		return true, ""
+	}
+	return false, "user does not manage the project"
+}
+
+func IsTaskCreator(s Subject, t model.Task) (bool, string) {
+	return t.CreatorID == s.User.ID, "user did not create the task"
+}
+
+func IsTaskAssignee(s Subject, t model.Task) (bool, string) {
+	return t.AssigneeID == s.User.ID, "user is not assigned to the task"
+}
+
+// AnyOf allows the action if any of rules allows it. The reason lists why
+// every rule denied it.
+func AnyOf(rules ...TaskRule) TaskRule {
+	return func(s Subject, t model.Task) (bool, string) {
+		reasons := []string{}
+		for _, rule := range rules {
+			ok, reason := rule(s, t)
+			if ok {
+				return true, ""
+			}
+			reasons = append(reasons, reason)
+		}
+		return false, fmt.Sprintf("task '%d': %s", t.ID, strings.Join(reasons, ", "))
+	}
+}
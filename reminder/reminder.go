@@ -0,0 +1,39 @@
+// Package reminder reminds assignees of tasks that are due soon.
+package reminder
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+)
+
+// Remind notifies the assignees of open tasks due within
+// config.DUE_REMINDER_BEFORE of now that they have not been reminded of.
+func Remind(db *sql.DB, now time.Time) (int64, error) {
+	return model.CreateDueReminders(db, now.Unix(), now.Add(config.DUE_REMINDER_BEFORE).Unix())
+}
+
+// RemindEvery runs Remind every interval until ctx is done.
+func RemindEvery(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := Remind(db, now)
+			if err != nil {
+				log.Println("err creating due reminders: ", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("created %d due reminders\n", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package schema
+
+import "github.com/tomihaapalainen/go-task-mgmt/constants"
+
+// ProjectPatchIn updates the fields of a project that are not nil.
+type ProjectPatchIn struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Archived    *bool   `json:"archived"`
+}
+
+type ProjectMemberIn struct {
+	UserID int                   `json:"user_id"`
+	Role   constants.ProjectRole `json:"role"`
+}
+
+// WebhookIn creates a webhook, or updates the fields of one that are not
+// nil. Active re-enables a disabled webhook or disables an active one.
+type WebhookIn struct {
+	URL    *string  `json:"url"`
+	Secret *string  `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// WebhookPayload is the body delivered to webhooks. Data is the task or
+// project the event is about.
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	ProjectID int         `json:"project_id"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
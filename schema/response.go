@@ -1,11 +1,43 @@
 package schema
 
 type AuthResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	Expires     int64
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	Expires      int64
+	RefreshToken string `json:"refresh_token"`
 }
 
 type MessageResponse struct {
 	Message string `json:"message"`
 }
+
+// TwoFactorChallenge is the login response for users with two-factor
+// authentication. The token is exchanged for an AuthResponse together with
+// a TOTP or recovery code.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"`
+	Expires           int64  `json:"expires"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI of the secret, usually shown as a QR code.
+	URI string `json:"uri"`
+}
+
+// RecoveryCodes are shown once; only their hashes are stored.
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// NewPersonalAccessToken is the only response that includes the token
+// itself; only its hash is stored.
+type NewPersonalAccessToken struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	ExpiresAt int64    `json:"expires_at"`
+	Token     string   `json:"token"`
+}
@@ -6,3 +6,16 @@ type AssignRole struct {
 	RoleID constants.RoleID `json:"role_id"`
 	UserID int              `json:"user_id"`
 }
+
+type RoleIn struct {
+	Name          string `json:"name"`
+	PermissionIDs []int  `json:"permission_ids"`
+}
+
+type RolePermissionIn struct {
+	PermissionID int `json:"permission_id"`
+}
+
+type TwoFactorPolicyIn struct {
+	Required bool `json:"required"`
+}
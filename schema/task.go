@@ -7,4 +7,31 @@ type TaskIn struct {
 	Title      string               `json:"title"`
 	Content    string               `json:"content"`
 	Status     constants.TaskStatus `json:"status"`
+	// StartAt and DueAt are RFC 3339 times or YYYY-MM-DD dates. Dates are
+	// taken in the user's time zone: a task starts at the beginning of its
+	// start date and is due at the end of its due date. An empty string
+	// leaves the time unset.
+	StartAt string `json:"start_at"`
+	DueAt   string `json:"due_at"`
+}
+
+// TaskPatchIn is like TaskIn, but fields that are left out keep their
+// current value. An empty start_at or due_at clears the time.
+type TaskPatchIn struct {
+	AssigneeID *int                  `json:"assignee_id"`
+	Title      *string               `json:"title"`
+	Content    *string               `json:"content"`
+	Status     *constants.TaskStatus `json:"status"`
+	StartAt    *string               `json:"start_at"`
+	DueAt      *string               `json:"due_at"`
+}
+
+type TaskTransitionIn struct {
+	Status constants.TaskStatus `json:"status"`
+}
+
+type CommentIn struct {
+	Content string `json:"content"`
+	// ParentID is the comment to reply to, if any.
+	ParentID int `json:"parent_id"`
 }
@@ -4,3 +4,72 @@ type UserIn struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+type RefreshIn struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ProfileIn updates the fields of the profile that are not nil.
+type ProfileIn struct {
+	DisplayName *string `json:"display_name"`
+	// Handle is cleared if it is empty.
+	Handle   *string `json:"handle"`
+	Timezone *string `json:"timezone"`
+	Locale   *string `json:"locale"`
+}
+
+type PasswordChangeIn struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type EmailChangeIn struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type TokenIn struct {
+	Token string `json:"token"`
+}
+
+type EmailIn struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetIn struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type PasswordIn struct {
+	Password string `json:"password"`
+}
+
+type TwoFactorCodeIn struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorDisableIn struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TwoFactorLoginIn completes a login with the token of a
+// TwoFactorChallenge and either a TOTP code or a recovery code.
+type TwoFactorLoginIn struct {
+	Token        string `json:"token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// PersonalAccessTokenIn creates a personal access token. Scopes are
+// permission names, such as "read task".
+type PersonalAccessTokenIn struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// NotificationPreferencesIn turns the notification types it names on or
+// off.
+type NotificationPreferencesIn map[string]bool
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeShouldMatchRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last 6 digits.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		code, err := Code(rfcSecret, Counter(time.Unix(unix, 0)))
+		assert.AssertEq(t, err, nil)
+		assert.AssertEq(t, code, want)
+	}
+}
+
+func TestValidateShouldAllowSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, Counter(now)-1)
+	assert.AssertEq(t, err, nil)
+
+	counter, ok := Validate(rfcSecret, code, now, 1)
+	assert.AssertEq(t, ok, true)
+	assert.AssertEq(t, counter, Counter(now)-1)
+
+	_, ok = Validate(rfcSecret, code, now, 0)
+	assert.AssertEq(t, ok, false)
+	_, ok = Validate(rfcSecret, "12345", now, 1)
+	assert.AssertEq(t, ok, false)
+}
+
+func TestGenerateSecretShouldRoundTrip(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.AssertEq(t, err, nil)
+	now := time.Now()
+	code, err := Code(secret, Counter(now))
+	assert.AssertEq(t, err, nil)
+	_, ok := Validate(secret, code, now, 0)
+	assert.AssertEq(t, ok, true)
+}
+
+func TestURIShouldIncludeSecretAndIssuer(t *testing.T) {
+	u, err := url.Parse(URI("go-task-mgmt", "user@example.com", rfcSecret))
+	assert.AssertEq(t, err, nil)
+	assert.AssertEq(t, u.Scheme, "otpauth")
+	assert.AssertEq(t, u.Host, "totp")
+	assert.AssertEq(t, u.Path, "/go-task-mgmt:user@example.com")
+	assert.AssertEq(t, u.Query().Get("secret"), rfcSecret)
+	assert.AssertEq(t, u.Query().Get("issuer"), "go-task-mgmt")
+}
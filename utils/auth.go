@@ -1,13 +1,25 @@
 package utils
 
 import (
-	"os"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/keys"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var dummyHashes sync.Map
+
 func ReadAuthorizationToken(c echo.Context) string {
 	authHeader := c.Request().Header.Get("Authorization")
 	split := strings.Split(authHeader, "Bearer ")
@@ -17,18 +29,147 @@ func ReadAuthorizationToken(c echo.Context) string {
 	return split[1]
 }
 
-func ParseToken(s string) (*jwt.Token, error) {
-	token, err := jwt.Parse(s, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("GO_TASK_MGMT_SIGNING_SECRET")), nil
-	})
-	return token, err
+// Claims are the claims of an access token.
+type Claims struct {
+	SessionID    string `json:"sid"`
+	TokenVersion int    `json:"ver"`
+	jwt.RegisteredClaims
 }
 
-func ParseClaims(s string) (jwt.MapClaims, error) {
-	claims := jwt.MapClaims{}
-	var err error
-	_, err = jwt.ParseWithClaims(s, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("GO_TASK_MGMT_SIGNING_SECRET")), nil
-	})
+// SignClaims signs claims with the current signing key.
+func SignClaims(claims *Claims) (string, error) {
+	return keys.Default().Sign(claims)
+}
+
+// ParseClaims verifies an access token and checks its expiry, issuer and
+// audience.
+func ParseClaims(s string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := keys.Default().Parse(
+		s,
+		claims,
+		jwt.WithIssuer(config.JWT_ISSUER),
+		jwt.WithAudience(config.JWT_AUDIENCE),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	return claims, err
 }
+
+// userTokenAudience is the audience of user tokens with the given purpose.
+// It differs from config.JWT_AUDIENCE so that user tokens are never
+// accepted as access tokens, and per purpose so that a token cannot be used
+// for another purpose.
+func userTokenAudience(purpose string) string {
+	return config.JWT_AUDIENCE + "/" + purpose
+}
+
+// SignUserToken signs a token to be sent to the user out of band, e.g. to
+// verify their email address. The token expires after ttl.
+func SignUserToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	jti, err := GenerateToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return keys.Default().Sign(&jwt.RegisteredClaims{
+		Issuer:    config.JWT_ISSUER,
+		Audience:  jwt.ClaimStrings{userTokenAudience(purpose)},
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		ID:        jti,
+	})
+}
+
+// ParseUserToken verifies a token created by SignUserToken for purpose and
+// returns the ID of the user it was issued to.
+func ParseUserToken(s, purpose string) (int, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := keys.Default().Parse(
+		s,
+		claims,
+		jwt.WithIssuer(config.JWT_ISSUER),
+		jwt.WithAudience(userTokenAudience(purpose)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return 0, errors.New("invalid subject")
+	}
+	return userID, nil
+}
+
+// HashPassword hashes password with the configured bcrypt cost.
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), config.BCRYPT_COST)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// CheckPassword reports whether password matches hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// CheckPasswordDummy runs a comparison against a throwaway hash of the
+// configured cost so that unknown accounts take as long to reject as
+// known ones. It always reports false.
+func CheckPasswordDummy(password string) bool {
+	cost := config.BCRYPT_COST
+	hash, ok := dummyHashes.Load(cost)
+	if !ok {
+		b, err := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+		if err != nil {
+			return false
+		}
+		hash, _ = dummyHashes.LoadOrStore(cost, b)
+	}
+	bcrypt.CompareHashAndPassword(hash.([]byte), []byte(password))
+	return false
+}
+
+// PasswordNeedsRehash reports whether hash was generated with a lower cost
+// than the one currently configured.
+func PasswordNeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false
+	}
+	return cost < config.BCRYPT_COST
+}
+
+// GenerateToken returns a URL-safe random token of n bytes of entropy.
+func GenerateToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PersonalAccessTokenPrefix starts every personal access token, telling
+// them apart from JWTs and making them easy to find in leaked secrets.
+const PersonalAccessTokenPrefix = "gtm_pat_"
+
+// GeneratePersonalAccessToken returns a new random personal access token.
+func GeneratePersonalAccessToken() (string, error) {
+	token, err := GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of an opaque token. Tokens
+// are high entropy, so a fast hash is sufficient for storing them.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
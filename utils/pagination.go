@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+)
+
+const DefaultPageLimit = 20
+const MaxPageLimit = 100
+
+// EncodeCursor returns an opaque pagination cursor holding v.
+func EncodeCursor(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor into v.
+func DecodeCursor(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// ParseLimit parses a page size query parameter. An empty value gives
+// DefaultPageLimit and values above MaxPageLimit are capped.
+func ParseLimit(s string) (int, bool) {
+	if s == "" {
+		return DefaultPageLimit, true
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return limit, true
+}
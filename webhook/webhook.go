@@ -0,0 +1,221 @@
+// Package webhook delivers queued webhook payloads to their receivers.
+//
+// Each delivery is a POST of the JSON payload with the headers
+//
+//	X-Webhook-Event: task.created
+//	X-Webhook-Delivery: <delivery ID>
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of the body keyed with the secret>
+//
+// Receivers should check the signature and treat the delivery ID as an
+// idempotency key, as a delivery may be attempted more than once.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+	"github.com/tomihaapalainen/go-task-mgmt/model"
+)
+
+// batchSize is the number of deliveries read from the outbox at a time.
+const batchSize = 50
+
+// maxErrorLength limits the error stored for an attempt.
+const maxErrorLength = 500
+
+// ErrAddressNotPublic is returned for webhook hosts that resolve to an
+// address that is not on the public internet.
+var ErrAddressNotPublic = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether webhooks may be delivered to addr. Loopback
+// addresses are allowed if config.WEBHOOK_ALLOW_LOOPBACK is set, which only
+// tests do.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() {
+		return config.WEBHOOK_ALLOW_LOOPBACK
+	}
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// CheckURL checks that the host of u only resolves to public addresses, so
+// that webhooks cannot be used to reach the server's own network. The
+// dispatcher checks the address again when it connects, as the host may
+// resolve differently by then.
+func CheckURL(ctx context.Context, u *url.URL) error {
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !publicAddr(addr) {
+			return ErrAddressNotPublic
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return ErrAddressNotPublic
+		}
+	}
+	return nil
+}
+
+// Sign returns the X-Webhook-Signature header value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body with secret.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Backoff returns how long to wait before retrying a delivery that has
+// failed attempts times.
+func Backoff(attempts int) time.Duration {
+	if shift := attempts - 1; shift < 32 {
+		return min(config.WEBHOOK_BACKOFF_BASE<<shift, config.WEBHOOK_BACKOFF_MAX)
+	}
+	return config.WEBHOOK_BACKOFF_MAX
+}
+
+type Dispatcher struct {
+	db     *sql.DB
+	client *http.Client
+}
+
+// NewDispatcher returns a dispatcher whose requests time out after
+// config.WEBHOOK_TIMEOUT. It refuses to connect to addresses that are not
+// public, does not use a proxy and does not follow redirects.
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout: config.WEBHOOK_TIMEOUT,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(addrPort.Addr()) {
+				return ErrAddressNotPublic
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   config.WEBHOOK_TIMEOUT,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{db: db, client: client}
+}
+
+// DeliverPending attempts the deliveries that are due at now and returns
+// the number of attempts made.
+func (d *Dispatcher) DeliverPending(ctx context.Context, now time.Time) (int, error) {
+	attempted := 0
+	for {
+		pending, err := model.ListPendingWebhookDeliveries(d.db, now.Unix(), batchSize)
+		if err != nil {
+			return attempted, err
+		}
+		for _, p := range pending {
+			if err := ctx.Err(); err != nil {
+				return attempted, err
+			}
+			if err := d.deliver(ctx, p, now); err != nil {
+				return attempted, err
+			}
+			attempted++
+		}
+		// Failed attempts are rescheduled after now, so every delivery is
+		// attempted at most once per call.
+		if len(pending) < batchSize {
+			return attempted, nil
+		}
+	}
+}
+
+// deliver attempts the delivery at now and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, p model.PendingWebhookDelivery, now time.Time) error {
+	start := time.Now()
+	a := model.WebhookAttempt{AttemptedAt: now.Unix()}
+	a.StatusCode, a.Error = d.post(ctx, p)
+	a.DurationMS = time.Since(start).Milliseconds()
+	if len(a.Error) > maxErrorLength {
+		a.Error = a.Error[:maxErrorLength]
+	}
+
+	retryAt := int64(0)
+	if p.Attempts+1 < config.WEBHOOK_MAX_ATTEMPTS {
+		retryAt = now.Add(Backoff(p.Attempts + 1)).Unix()
+	}
+	delivery := p.WebhookDelivery
+	return delivery.RecordAttempt(d.db, &a, retryAt, config.WEBHOOK_DISABLE_AFTER)
+}
+
+// post sends the payload and returns the response status and, if the
+// receiver did not accept it, why.
+func (d *Dispatcher) post(ctx context.Context, p model.PendingWebhookDelivery) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, strings.NewReader(string(p.Payload)))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", config.JWT_ISSUER+"-webhook")
+	req.Header.Set("X-Webhook-Event", p.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(p.ID))
+	req.Header.Set("X-Webhook-Signature", Sign(p.Secret, p.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Sprintf("unexpected response status %d", res.StatusCode)
+	}
+	return res.StatusCode, ""
+}
+
+// RunEvery delivers pending deliveries every interval until ctx is done.
+func (d *Dispatcher) RunEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := d.DeliverPending(ctx, now); err != nil {
+				log.Println("err delivering webhooks: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/tomihaapalainen/go-task-mgmt/assert"
+	"github.com/tomihaapalainen/go-task-mgmt/config"
+)
+
+func TestSignShouldMatchHMACSHA256(t *testing.T) {
+	// echo -n '{"event":"task.created"}' | openssl dgst -sha256 -hmac 'webhook secret key'
+	body := []byte(`{"event":"task.created"}`)
+	signature := Sign("webhook secret key", body)
+	assert.AssertEq(t, signature, "sha256=2ea87d848097dd6aa56c744f77a997240e87583a5b8135fe1b02c1980b9cdfc8")
+	assert.AssertEq(t, Verify("webhook secret key", body, signature), true)
+	assert.AssertEq(t, Verify("another secret key", body, signature), false)
+	assert.AssertEq(t, Verify("webhook secret key", []byte(`{"event":"task.deleted"}`), signature), false)
+}
+
+func TestBackoffShouldDoubleUpToMax(t *testing.T) {
+	assert.AssertEq(t, Backoff(1), config.WEBHOOK_BACKOFF_BASE)
+	assert.AssertEq(t, Backoff(2), 2*config.WEBHOOK_BACKOFF_BASE)
+	assert.AssertEq(t, Backoff(3), 4*config.WEBHOOK_BACKOFF_BASE)
+	assert.AssertEq(t, Backoff(20), config.WEBHOOK_BACKOFF_MAX)
+	assert.AssertEq(t, Backoff(100), config.WEBHOOK_BACKOFF_MAX)
+}
+
+func TestPublicAddrShouldRefuseInternalAddresses(t *testing.T) {
+	for _, addr := range []string{
+		"127.0.0.1", "::1", "0.0.0.0", "10.1.2.3", "172.16.0.1", "192.168.0.1", "100.64.0.1",
+		"169.254.169.254", "fe80::1", "fd00:ec2::254", "::ffff:10.0.0.1", "224.0.0.1",
+	} {
+		assert.AssertEq(t, publicAddr(netip.MustParseAddr(addr)), false)
+	}
+	for _, addr := range []string{"203.0.113.10", "8.8.8.8", "2001:4860:4860::8888"} {
+		assert.AssertEq(t, publicAddr(netip.MustParseAddr(addr)), true)
+	}
+
+	config.WEBHOOK_ALLOW_LOOPBACK = true
+	defer func() { config.WEBHOOK_ALLOW_LOOPBACK = false }()
+	assert.AssertEq(t, publicAddr(netip.MustParseAddr("127.0.0.1")), true)
+	assert.AssertEq(t, publicAddr(netip.MustParseAddr("10.1.2.3")), false)
+}